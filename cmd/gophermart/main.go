@@ -37,13 +37,17 @@ func main() {
 
 	storage := psql.NewPsqlStorage(db)
 
-	h := handler.NewHandler(storage)
+	h, err := handler.NewHandler(storage)
+	if err != nil {
+		logger.ErrorLogger("Can't init handler: ", err)
+		return
+	}
 
 	go storage.Sync()
 
 	srv := http.Server{
 		Addr:    config.ReadyConfig.ServerAddr,
-		Handler: router.RequestsRouter(h),
+		Handler: router.RequestsRouter(h, storage),
 	}
 
 	idleConnsClosed := make(chan struct{})
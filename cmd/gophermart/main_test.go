@@ -1,21 +1,37 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"database/sql"
+	"encoding/csv"
+	"encoding/json"
 	"math/rand"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/knstch/gophermart/cmd/config"
+	"github.com/knstch/gophermart/internal/app/apierror"
 	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/cookie"
+	"github.com/knstch/gophermart/internal/app/events"
 	"github.com/knstch/gophermart/internal/app/handler"
 	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/knstch/gophermart/internal/app/oidc/oidctest"
+	"github.com/knstch/gophermart/internal/app/pagination"
 	"github.com/knstch/gophermart/internal/app/router"
 	"github.com/knstch/gophermart/internal/app/storage/psql"
+	"github.com/knstch/gophermart/internal/app/totp"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/uptrace/bun"
 	"github.com/uptrace/bun/dialect/pgdialect"
 )
@@ -29,6 +45,32 @@ func loginGenerator(length int) string {
 	return string(result)
 }
 
+// orderGenerator returns a random order number passing the Luhn check.
+func orderGenerator() string {
+	digits := make([]int, 16)
+	for i := 0; i < 15; i++ {
+		digits[i] = rand.Intn(10)
+	}
+	sum := 0
+	for i := 14; i >= 0; i -= 2 {
+		doubled := digits[i] * 2
+		if doubled > 9 {
+			doubled -= 9
+		}
+		sum += doubled
+	}
+	for i := 13; i >= 0; i -= 2 {
+		sum += digits[i]
+	}
+	digits[15] = (10 - sum%10) % 10
+
+	result := make([]byte, len(digits))
+	for i, digit := range digits {
+		result[i] = byte('0' + digit)
+	}
+	return string(result)
+}
+
 type testUser struct {
 	login    string
 	password string
@@ -36,21 +78,43 @@ type testUser struct {
 
 var testUserOne = testUser{
 	login:    loginGenerator(10),
-	password: "12345",
+	password: "gopher-12345",
 }
 
 var testUserTwo = testUser{
 	login:    loginGenerator(10),
-	password: "12345",
+	password: "gopher-12345",
 }
 
 var testUserThree = testUser{
 	login:    loginGenerator(10),
-	password: "12345",
+	password: "gopher-12345",
 }
 
 var orderNum = "5105105105105100"
 
+// addCookies adds cookies to a request and copies the CSRF token
+// to the request header the way a frontend does.
+func addCookies(req *http.Request, cookies []*http.Cookie) {
+	for _, c := range cookies {
+		req.AddCookie(c)
+		if c.Name == cookie.CSRFCookie {
+			req.Header.Set(cookie.CSRFHeader, c.Value)
+		}
+	}
+}
+
+// withoutRequestID removes the random request ID from an error body, so it can be compared.
+func withoutRequestID(body string) string {
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(body), &fields); err != nil {
+		return body
+	}
+	delete(fields, "request_id")
+	stripped, _ := json.Marshal(fields)
+	return string(stripped)
+}
+
 func TestSignUp(t *testing.T) {
 	config.ParseConfig()
 	db, err := sql.Open("pgx", config.ReadyConfig.Database)
@@ -59,9 +123,10 @@ func TestSignUp(t *testing.T) {
 	}
 	storage := psql.NewPsqlStorage(db)
 
-	h := handler.NewHandler(storage)
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
 
-	router := router.RequestsRouter(h)
+	router := router.RequestsRouter(h, storage)
 
 	type want struct {
 		statusCode  int
@@ -96,7 +161,7 @@ func TestSignUp(t *testing.T) {
 			want: want{
 				statusCode:  409,
 				contentType: "application/json; charset=utf-8",
-				body:        `{"error":"Login is already taken"}`,
+				body:        `{"code":"login_taken","error":"Login is already taken"}`,
 			},
 			reqest: request{
 				contentType: "application/json",
@@ -108,7 +173,7 @@ func TestSignUp(t *testing.T) {
 			want: want{
 				statusCode:  400,
 				contentType: "application/json; charset=utf-8",
-				body:        `{"error":"Wrong request"}`,
+				body:        `{"code":"bad_request","error":"Wrong request"}`,
 			},
 			reqest: request{
 				contentType: "application/json",
@@ -120,7 +185,7 @@ func TestSignUp(t *testing.T) {
 			want: want{
 				statusCode:  400,
 				contentType: "application/json; charset=utf-8",
-				body:        `{"error":"Wrong request"}`,
+				body:        `{"code":"bad_request","error":"Wrong request"}`,
 			},
 			reqest: request{
 				contentType: "application/json",
@@ -132,7 +197,7 @@ func TestSignUp(t *testing.T) {
 			want: want{
 				statusCode:  400,
 				contentType: "application/json; charset=utf-8",
-				body:        `{"error":"Wrong request"}`,
+				body:        `{"code":"bad_request","error":"Wrong request"}`,
 			},
 			reqest: request{
 				contentType: "application/json",
@@ -144,7 +209,7 @@ func TestSignUp(t *testing.T) {
 			want: want{
 				statusCode:  400,
 				contentType: "application/json; charset=utf-8",
-				body:        `{"error": "Wrong request"}`,
+				body:        `{"code":"bad_request","error": "Wrong request"}`,
 			},
 			reqest: request{
 				contentType: "application/json",
@@ -156,7 +221,7 @@ func TestSignUp(t *testing.T) {
 			want: want{
 				statusCode:  400,
 				contentType: "application/json; charset=utf-8",
-				body:        `{"error":"Wrong request"}`,
+				body:        `{"code":"bad_request","error":"Wrong request"}`,
 			},
 			reqest: request{
 				contentType: "application/json",
@@ -175,6 +240,33 @@ func TestSignUp(t *testing.T) {
 				body:        `{"login": "` + testUserTwo.login + `","password": "` + testUserTwo.password + `"}`,
 			},
 		},
+		{
+			name: "#9 login differing only by case is taken",
+			want: want{
+				statusCode:  409,
+				contentType: "application/json; charset=utf-8",
+				body:        `{"code":"login_taken","error":"Login is already taken"}`,
+			},
+			reqest: request{
+				contentType: "application/json",
+				body:        `{"login": " ` + strings.ToUpper(testUserOne.login) + ` ","password": "` + testUserOne.password + `"}`,
+			},
+		},
+		{
+			name: "#10 bad request, credentials break the policy",
+			want: want{
+				statusCode:  400,
+				contentType: "application/json; charset=utf-8",
+				body: `{"code":"validation_failed","error":"Validation failed","fields":[
+					{"field":"login","message":"must be at least 3 characters long"},
+					{"field":"password","message":"is too common"}
+				]}`,
+			},
+			reqest: request{
+				contentType: "application/json",
+				body:        `{"login": " ","password": "password123"}`,
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -186,7 +278,7 @@ func TestSignUp(t *testing.T) {
 
 			assert.Equal(t, tt.want.statusCode, rr.Code)
 			assert.Equal(t, tt.want.contentType, rr.Header().Get("Content-Type"))
-			assert.JSONEq(t, tt.want.body, rr.Body.String())
+			assert.JSONEq(t, tt.want.body, withoutRequestID(rr.Body.String()))
 		})
 	}
 }
@@ -198,9 +290,10 @@ func TestAuth(t *testing.T) {
 	}
 	storage := psql.NewPsqlStorage(db)
 
-	h := handler.NewHandler(storage)
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
 
-	router := router.RequestsRouter(h)
+	router := router.RequestsRouter(h, storage)
 
 	type want struct {
 		statusCode  int
@@ -235,7 +328,7 @@ func TestAuth(t *testing.T) {
 			want: want{
 				statusCode:  400,
 				contentType: "application/json; charset=utf-8",
-				body:        `{"error":"Wrong request"}`,
+				body:        `{"code":"bad_request","error":"Wrong request"}`,
 			},
 			reqest: request{
 				contentType: "application/json",
@@ -247,7 +340,7 @@ func TestAuth(t *testing.T) {
 			want: want{
 				statusCode:  401,
 				contentType: "application/json; charset=utf-8",
-				body:        `{"error":"Wrong email or password"}`,
+				body:        `{"code":"wrong_credentials","error":"Wrong email or password"}`,
 			},
 			reqest: request{
 				contentType: "application/json",
@@ -265,7 +358,7 @@ func TestAuth(t *testing.T) {
 
 			assert.Equal(t, tt.want.statusCode, rr.Code)
 			assert.Equal(t, tt.want.contentType, rr.Header().Get("Content-Type"))
-			assert.JSONEq(t, tt.want.body, rr.Body.String())
+			assert.JSONEq(t, tt.want.body, withoutRequestID(rr.Body.String()))
 		})
 	}
 }
@@ -277,9 +370,10 @@ func TestUploadOrder(t *testing.T) {
 	}
 	storage := psql.NewPsqlStorage(db)
 
-	h := handler.NewHandler(storage)
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
 
-	router := router.RequestsRouter(h)
+	router := router.RequestsRouter(h, storage)
 
 	type want struct {
 		statusCode  int
@@ -329,7 +423,7 @@ func TestUploadOrder(t *testing.T) {
 			want: want{
 				statusCode:  422,
 				contentType: "application/json; charset=utf-8",
-				body:        `{"error":"Wrong order number"}`,
+				body:        `{"code":"order_invalid","error":"Wrong order number"}`,
 			},
 			reqest: request{
 				contentType: "text/plain; charset=utf-8",
@@ -355,7 +449,7 @@ func TestUploadOrder(t *testing.T) {
 			want: want{
 				statusCode:  409,
 				contentType: "application/json; charset=utf-8",
-				body:        `{"error":"Order is already loaded by another user"}`,
+				body:        `{"code":"order_owned_by_another_user","error":"Order is already loaded by another user"}`,
 			},
 			reqest: request{
 				contentType: "text/plain; charset=utf-8",
@@ -368,7 +462,7 @@ func TestUploadOrder(t *testing.T) {
 			want: want{
 				statusCode:  401,
 				contentType: "application/json; charset=utf-8",
-				body:        `{"error":"You are not authenticated"}`,
+				body:        `{"code":"unauthenticated","error":"You are not authenticated"}`,
 			},
 			reqest: request{
 				contentType: "text/plain; charset=utf-8",
@@ -392,15 +486,13 @@ func TestUploadOrder(t *testing.T) {
 
 			req := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/orders/", bytes.NewBuffer([]byte(tt.reqest.body)))
 			req.Header.Set("Content-Type", tt.reqest.contentType)
-			for _, cookie := range cookies {
-				req.AddCookie(cookie)
-			}
+			addCookies(req, cookies)
 			rr := httptest.NewRecorder()
 			router.ServeHTTP(rr, req)
 
 			assert.Equal(t, tt.want.statusCode, rr.Code)
 			assert.Equal(t, tt.want.contentType, rr.Header().Get("Content-Type"))
-			assert.JSONEq(t, tt.want.body, rr.Body.String())
+			assert.JSONEq(t, tt.want.body, withoutRequestID(rr.Body.String()))
 		})
 	}
 }
@@ -412,9 +504,10 @@ func TestGetOrders(t *testing.T) {
 	}
 	storage := psql.NewPsqlStorage(db)
 
-	h := handler.NewHandler(storage)
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
 
-	router := router.RequestsRouter(h)
+	router := router.RequestsRouter(h, storage)
 
 	var orderTest common.Order
 	ctx := context.Background()
@@ -477,7 +570,7 @@ func TestGetOrders(t *testing.T) {
 			want: want{
 				statusCode:  401,
 				contentType: "application/json; charset=utf-8",
-				body:        `{"error": "You are not authenticated"}`,
+				body:        `{"code":"unauthenticated","error": "You are not authenticated"}`,
 			},
 			reqest: request{
 				user: testUserThree,
@@ -508,17 +601,2148 @@ func TestGetOrders(t *testing.T) {
 			cookies := getCookieRes.Result().Cookies()
 
 			req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/user/orders/", nil)
-			for _, cookie := range cookies {
-				req.AddCookie(cookie)
-			}
+			addCookies(req, cookies)
 			rr := httptest.NewRecorder()
 			router.ServeHTTP(rr, req)
 
 			assert.Equal(t, tt.want.statusCode, rr.Code)
 			assert.Equal(t, tt.want.contentType, rr.Header().Get("Content-Type"))
 			if tt.want.body != "" {
-				assert.JSONEq(t, tt.want.body, rr.Body.String())
+				assert.JSONEq(t, tt.want.body, withoutRequestID(rr.Body.String()))
+			}
+		})
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+
+	user := testUser{
+		login:    loginGenerator(10),
+		password: "gopher-12345",
+	}
+
+	signUpReqBody := `{"login": "` + user.login + `","password": "` + user.password + `"}`
+	signUpReq := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/register", bytes.NewBuffer([]byte(signUpReqBody)))
+	signUpReq.Header.Set("Content-Type", "application/json")
+	signUpRes := httptest.NewRecorder()
+	router.ServeHTTP(signUpRes, signUpReq)
+	defer signUpRes.Result().Body.Close()
+
+	oldCookies := signUpRes.Result().Cookies()
+
+	type want struct {
+		statusCode int
+		body       string
+	}
+
+	tests := []struct {
+		name string
+		want want
+		body string
+	}{
+		{
+			name: "#1 wrong current password",
+			want: want{
+				statusCode: 401,
+				body:       `{"code":"wrong_password","error":"Wrong current password"}`,
+			},
+			body: `{"current_password": "wrong-password","new_password": "gopher-67890"}`,
+		},
+		{
+			name: "#2 new password breaks the policy",
+			want: want{
+				statusCode: 400,
+				body:       `{"code":"validation_failed","error":"Validation failed","fields":[{"field":"new_password","message":"must be at least 8 characters long"}]}`,
+			},
+			body: `{"current_password": "` + user.password + `","new_password": "short"}`,
+		},
+		{
+			name: "#3 password changed",
+			want: want{
+				statusCode: 200,
+				body:       `{"message":"Password successfully changed"}`,
+			},
+			body: `{"current_password": "` + user.password + `","new_password": "gopher-67890"}`,
+		},
+		{
+			name: "#4 old session is revoked",
+			want: want{
+				statusCode: 401,
+				body:       `{"code":"unauthenticated","error":"You are not authenticated"}`,
+			},
+			body: `{"current_password": "gopher-67890","new_password": "gopher-12345"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/password", bytes.NewBuffer([]byte(tt.body)))
+			req.Header.Set("Content-Type", "application/json")
+			addCookies(req, oldCookies)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.want.statusCode, rr.Code)
+			assert.JSONEq(t, tt.want.body, withoutRequestID(rr.Body.String()))
+		})
+	}
+}
+
+func TestChangePasswordLockout(t *testing.T) {
+	maxAttempts, baseDelay := config.ReadyConfig.LoginMaxAttempts, config.ReadyConfig.LoginBaseDelay
+	config.ReadyConfig.LoginMaxAttempts, config.ReadyConfig.LoginBaseDelay = 2, 0
+	defer func() {
+		config.ReadyConfig.LoginMaxAttempts, config.ReadyConfig.LoginBaseDelay = maxAttempts, baseDelay
+	}()
+
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+
+	send := func(method string, url string, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBuffer([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		addCookies(req, cookies)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	cookies := send(http.MethodPost, "http://localhost:8080/api/user/register", `{"login": "`+strings.ToLower(loginGenerator(10))+`","password": "gopher-12345"}`, nil).Result().Cookies()
+
+	wrong := `{"current_password": "wrong-password","new_password": "gopher-67890"}`
+	assert.Equal(t, 401, send(http.MethodPost, "http://localhost:8080/api/user/password", wrong, cookies).Code)
+
+	rr := send(http.MethodPost, "http://localhost:8080/api/user/password", wrong, cookies)
+	assert.Equal(t, 429, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"too_many_attempts"`)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+
+	rr = send(http.MethodPost, "http://localhost:8080/api/user/password", `{"current_password": "gopher-12345","new_password": "gopher-67890"}`, cookies)
+	assert.Equal(t, 429, rr.Code)
+}
+
+func TestAdminAccess(t *testing.T) {
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+
+	admin := testUser{
+		login:    strings.ToLower(loginGenerator(10)),
+		password: "gopher-12345",
+	}
+
+	signUpReqBody := `{"login": "` + admin.login + `","password": "` + admin.password + `"}`
+	signUpReq := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/register", bytes.NewBuffer([]byte(signUpReqBody)))
+	signUpReq.Header.Set("Content-Type", "application/json")
+	signUpRes := httptest.NewRecorder()
+	router.ServeHTTP(signUpRes, signUpReq)
+	defer signUpRes.Result().Body.Close()
+
+	userCookies := signUpRes.Result().Cookies()
+
+	err = storage.GrantRole(context.Background(), admin.login, common.RoleAdmin)
+	if err != nil {
+		logger.ErrorLogger("Can't grant admin role: ", err)
+	}
+
+	type want struct {
+		statusCode int
+		body       string
+	}
+
+	tests := []struct {
+		name    string
+		want    want
+		cookies []*http.Cookie
+		login   bool
+	}{
+		{
+			name: "#1 user without cookie",
+			want: want{
+				statusCode: 401,
+				body:       `{"code":"unauthenticated","error":"You are not authenticated"}`,
+			},
+		},
+		{
+			name: "#2 session issued before the role was granted is revoked",
+			want: want{
+				statusCode: 401,
+				body:       `{"code":"unauthenticated","error":"You are not authenticated"}`,
+			},
+			cookies: userCookies,
+		},
+		{
+			name: "#3 admin gets a user",
+			want: want{
+				statusCode: 200,
+				body:       `{"id":0,"login":"` + admin.login + `","roles":["user","admin"],"current":0,"withdrawn":0,"two_factor":false,"state":"active"}`,
+			},
+			login: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cookies := tt.cookies
+			if tt.login {
+				getCookieReq := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/login", bytes.NewBuffer([]byte(signUpReqBody)))
+				getCookieReq.Header.Set("Content-Type", "application/json")
+				getCookieRes := httptest.NewRecorder()
+				router.ServeHTTP(getCookieRes, getCookieReq)
+				defer getCookieRes.Result().Body.Close()
+				cookies = getCookieRes.Result().Cookies()
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/admin/users/"+admin.login, nil)
+			addCookies(req, cookies)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			body := rr.Body.String()
+			if rr.Code == http.StatusOK {
+				var info common.AccountInfo
+				assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &info))
+				assert.NotZero(t, info.ID)
+				info.ID = 0
+				encoded, err := json.Marshal(info)
+				assert.NoError(t, err)
+				body = string(encoded)
 			}
+
+			assert.Equal(t, tt.want.statusCode, rr.Code)
+			assert.JSONEq(t, tt.want.body, withoutRequestID(body))
+		})
+	}
+
+	t.Run("#4 user without the admin role", func(t *testing.T) {
+		getCookieReqBody := `{"login": "` + testUserOne.login + `","password": "` + testUserOne.password + `"}`
+		getCookieReq := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/login", bytes.NewBuffer([]byte(getCookieReqBody)))
+		getCookieReq.Header.Set("Content-Type", "application/json")
+		getCookieRes := httptest.NewRecorder()
+		router.ServeHTTP(getCookieRes, getCookieReq)
+		defer getCookieRes.Result().Body.Close()
+
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/admin/users/"+admin.login, nil)
+		addCookies(req, getCookieRes.Result().Cookies())
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.JSONEq(t, `{"code":"forbidden","error":"You don't have access"}`, withoutRequestID(rr.Body.String()))
+
+		var apiErr apierror.Error
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &apiErr))
+		assert.NotEmpty(t, apiErr.RequestID)
+		assert.Equal(t, rr.Header().Get("X-Request-ID"), apiErr.RequestID)
+	})
+}
+
+func TestAdminAPIKeys(t *testing.T) {
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+
+	send := func(method string, url string, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBuffer([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		addCookies(req, cookies)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	user := strings.ToLower(loginGenerator(10))
+	userCookies := send(http.MethodPost, "http://localhost:8080/api/user/register", `{"login": "`+user+`","password": "gopher-12345"}`, nil).Result().Cookies()
+
+	admin := strings.ToLower(loginGenerator(10))
+	adminCredentials := `{"login": "` + admin + `","password": "gopher-12345"}`
+	send(http.MethodPost, "http://localhost:8080/api/user/register", adminCredentials, nil)
+	err = storage.GrantRole(context.Background(), admin, common.RoleAdmin)
+	assert.NoError(t, err)
+	adminCookies := send(http.MethodPost, "http://localhost:8080/api/user/login", adminCredentials, nil).Result().Cookies()
+
+	createKey := func(login string) *httptest.ResponseRecorder {
+		return send(http.MethodPost, "http://localhost:8080/api/admin/api-keys", `{"login": "`+login+`","name": "partner","scopes": ["orders:read"]}`, adminCookies)
+	}
+
+	t.Run("#1 logins are case-insensitive", func(t *testing.T) {
+		rr := createKey(strings.ToUpper(user))
+		assert.Equal(t, 201, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"login":"`+user+`"`)
+
+		rr = send(http.MethodGet, "http://localhost:8080/api/admin/api-keys?login="+strings.ToUpper(user), "", adminCookies)
+		assert.Equal(t, 200, rr.Code)
+		var keys []common.APIKey
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &keys))
+		assert.Len(t, keys, 1)
+	})
+
+	t.Run("#2 deleted account gets no keys", func(t *testing.T) {
+		account, err := storage.GetAccountInfo(context.Background(), user)
+		require.NoError(t, err)
+		assert.Equal(t, 200, send(http.MethodDelete, "http://localhost:8080/api/user", `{"password": "gopher-12345"}`, userCookies).Code)
+
+		rr := createKey("deleted#" + strconv.FormatInt(account.ID, 10))
+		assert.Equal(t, 404, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"code":"user_not_found"`)
+	})
+}
+
+func TestSessions(t *testing.T) {
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+
+	login := loginGenerator(10)
+	credentials := `{"login": "` + login + `","password": "gopher-12345"}`
+
+	signUpReq := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/register", bytes.NewBuffer([]byte(credentials)))
+	signUpReq.Header.Set("Content-Type", "application/json")
+	signUpReq.Header.Set("User-Agent", "laptop")
+	signUpRes := httptest.NewRecorder()
+	router.ServeHTTP(signUpRes, signUpReq)
+	defer signUpRes.Result().Body.Close()
+	laptopCookies := signUpRes.Result().Cookies()
+
+	loginReq := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/login", bytes.NewBuffer([]byte(credentials)))
+	loginReq.Header.Set("Content-Type", "application/json")
+	loginReq.Header.Set("User-Agent", "phone")
+	loginRes := httptest.NewRecorder()
+	router.ServeHTTP(loginRes, loginReq)
+	defer loginRes.Result().Body.Close()
+	phoneCookies := loginRes.Result().Cookies()
+
+	getSessions := func(cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/user/sessions", nil)
+		addCookies(req, cookies)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := getSessions(laptopCookies)
+	assert.Equal(t, 200, rr.Code)
+
+	var sessions []common.Session
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &sessions))
+	assert.Len(t, sessions, 2)
+
+	var phoneSession string
+	for _, session := range sessions {
+		assert.Equal(t, session.UserAgent == "laptop", session.Current)
+		if session.UserAgent == "phone" {
+			phoneSession = session.ID
+		}
+	}
+
+	tests := []struct {
+		name       string
+		id         string
+		statusCode int
+	}{
+		{
+			name:       "#1 unknown session",
+			id:         "unknown",
+			statusCode: 404,
+		},
+		{
+			name:       "#2 phone session revoked",
+			id:         phoneSession,
+			statusCode: 200,
+		},
+		{
+			name:       "#3 session already revoked",
+			id:         phoneSession,
+			statusCode: 404,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "http://localhost:8080/api/user/sessions/"+tt.id, nil)
+			addCookies(req, laptopCookies)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.statusCode, rr.Code)
+		})
+	}
+
+	assert.Equal(t, 401, getSessions(phoneCookies).Code)
+	assert.Equal(t, 200, getSessions(laptopCookies).Code)
+}
+
+func TestUpdateProfile(t *testing.T) {
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+
+	user := testUser{
+		login:    strings.ToLower(loginGenerator(10)),
+		password: "gopher-12345",
+	}
+	another := testUser{
+		login:    strings.ToLower(loginGenerator(10)),
+		password: "gopher-12345",
+	}
+	newLogin := strings.ToLower(loginGenerator(10))
+
+	var cookies []*http.Cookie
+	for _, u := range []testUser{another, user} {
+		signUpReqBody := `{"login": "` + u.login + `","password": "` + u.password + `"}`
+		signUpReq := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/register", bytes.NewBuffer([]byte(signUpReqBody)))
+		signUpReq.Header.Set("Content-Type", "application/json")
+		signUpRes := httptest.NewRecorder()
+		router.ServeHTTP(signUpRes, signUpReq)
+		defer signUpRes.Result().Body.Close()
+		cookies = signUpRes.Result().Cookies()
+	}
+
+	type want struct {
+		statusCode int
+		body       string
+	}
+
+	tests := []struct {
+		name string
+		want want
+		body string
+	}{
+		{
+			name: "#1 login breaks the policy",
+			want: want{
+				statusCode: 400,
+				body:       `{"code":"validation_failed","error":"Validation failed","fields":[{"field":"login","message":"must be at least 3 characters long"}]}`,
+			},
+			body: `{"login": "ab"}`,
+		},
+		{
+			name: "#2 login differing only by case is taken",
+			want: want{
+				statusCode: 409,
+				body:       `{"code":"login_taken","error":"Login is already taken"}`,
+			},
+			body: `{"login": "` + strings.ToUpper(another.login) + `"}`,
+		},
+		{
+			name: "#3 login changed",
+			want: want{
+				statusCode: 200,
+				body:       `{"message":"Profile successfully updated"}`,
+			},
+			body: `{"login": "` + newLogin + `"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, "http://localhost:8080/api/user/profile", bytes.NewBuffer([]byte(tt.body)))
+			req.Header.Set("Content-Type", "application/json")
+			addCookies(req, cookies)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.want.statusCode, rr.Code)
+			assert.JSONEq(t, tt.want.body, withoutRequestID(rr.Body.String()))
 		})
 	}
+
+	balanceReq := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/user/balance/", nil)
+	addCookies(balanceReq, cookies)
+	balanceRes := httptest.NewRecorder()
+	router.ServeHTTP(balanceRes, balanceReq)
+	assert.Equal(t, 200, balanceRes.Code, "session survives the rename")
+
+	for login, statusCode := range map[string]int{user.login: 401, newLogin: 200} {
+		loginReqBody := `{"login": "` + login + `","password": "` + user.password + `"}`
+		loginReq := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/login", bytes.NewBuffer([]byte(loginReqBody)))
+		loginReq.Header.Set("Content-Type", "application/json")
+		loginRes := httptest.NewRecorder()
+		router.ServeHTTP(loginRes, loginReq)
+		assert.Equal(t, statusCode, loginRes.Code)
+	}
+}
+
+func TestAccountExportAndDeletion(t *testing.T) {
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+
+	user := testUser{
+		login:    strings.ToLower(loginGenerator(10)),
+		password: "gopher-12345",
+	}
+	credentials := `{"login": "` + user.login + `","password": "` + user.password + `"}`
+
+	signUpReq := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/register", bytes.NewBuffer([]byte(credentials)))
+	signUpReq.Header.Set("Content-Type", "application/json")
+	signUpRes := httptest.NewRecorder()
+	router.ServeHTTP(signUpRes, signUpReq)
+	defer signUpRes.Result().Body.Close()
+	cookies := signUpRes.Result().Cookies()
+
+	send := func(method string, url string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBuffer([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		addCookies(req, cookies)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	exportRes := send(http.MethodGet, "http://localhost:8080/api/user/export", "")
+	assert.Equal(t, 200, exportRes.Code)
+	assert.Contains(t, exportRes.Header().Get("Content-Disposition"), "attachment")
+
+	var export common.AccountExport
+	assert.NoError(t, json.Unmarshal(exportRes.Body.Bytes(), &export))
+	assert.Equal(t, user.login, export.Account.Login)
+	assert.Empty(t, export.Orders)
+	assert.Len(t, export.Sessions, 1)
+
+	tests := []struct {
+		name       string
+		body       string
+		statusCode int
+	}{
+		{
+			name:       "#1 wrong password",
+			body:       `{"password": "wrong-password"}`,
+			statusCode: 401,
+		},
+		{
+			name:       "#2 account deleted",
+			body:       `{"password": "` + user.password + `"}`,
+			statusCode: 200,
+		},
+		{
+			name:       "#3 session is revoked",
+			body:       `{"password": "` + user.password + `"}`,
+			statusCode: 401,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := send(http.MethodDelete, "http://localhost:8080/api/user", tt.body)
+			assert.Equal(t, tt.statusCode, rr.Code)
+		})
+	}
+
+	loginReq := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/login", bytes.NewBuffer([]byte(credentials)))
+	loginReq.Header.Set("Content-Type", "application/json")
+	loginRes := httptest.NewRecorder()
+	router.ServeHTTP(loginRes, loginReq)
+	assert.Equal(t, 401, loginRes.Code)
+
+	info, err := storage.GetAccountInfo(context.Background(), user.login)
+	assert.ErrorIs(t, err, psql.ErrNoRows)
+	assert.Empty(t, info.Login)
+}
+
+func TestDeleteAccountConfirmation(t *testing.T) {
+	maxAttempts, baseDelay := config.ReadyConfig.LoginMaxAttempts, config.ReadyConfig.LoginBaseDelay
+	config.ReadyConfig.LoginMaxAttempts, config.ReadyConfig.LoginBaseDelay = 2, 0
+	defer func() {
+		config.ReadyConfig.LoginMaxAttempts, config.ReadyConfig.LoginBaseDelay = maxAttempts, baseDelay
+	}()
+
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+
+	send := func(method string, url string, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBuffer([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		addCookies(req, cookies)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	register := func() []*http.Cookie {
+		return send(http.MethodPost, "http://localhost:8080/api/user/register", `{"login": "`+strings.ToLower(loginGenerator(10))+`","password": "gopher-12345"}`, nil).Result().Cookies()
+	}
+
+	t.Run("#1 two-factor authentication needs a code", func(t *testing.T) {
+		cookies := register()
+
+		var enrolment struct {
+			Secret string `json:"secret"`
+		}
+		assert.NoError(t, json.Unmarshal(send(http.MethodPost, "http://localhost:8080/api/user/2fa", "", cookies).Body.Bytes(), &enrolment))
+		code, err := totp.Code(enrolment.Secret, time.Now())
+		assert.NoError(t, err)
+		confirmRes := send(http.MethodPost, "http://localhost:8080/api/user/2fa/confirm", `{"code": "`+code+`"}`, cookies)
+		assert.Equal(t, 200, confirmRes.Code)
+		var recovery struct {
+			Codes []string `json:"recovery_codes"`
+		}
+		assert.NoError(t, json.Unmarshal(confirmRes.Body.Bytes(), &recovery))
+
+		rr := send(http.MethodDelete, "http://localhost:8080/api/user", `{"password": "gopher-12345"}`, cookies)
+		assert.Equal(t, 403, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"code":"second_factor_required"`)
+
+		rr = send(http.MethodDelete, "http://localhost:8080/api/user", `{"password": "gopher-12345","code": "wrong-recovery-code"}`, cookies)
+		assert.Equal(t, 401, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"code":"wrong_code"`)
+
+		rr = send(http.MethodDelete, "http://localhost:8080/api/user", `{"password": "gopher-12345","code": "`+recovery.Codes[0]+`"}`, cookies)
+		assert.Equal(t, 200, rr.Code)
+	})
+
+	t.Run("#2 wrong passwords lock the account", func(t *testing.T) {
+		cookies := register()
+
+		assert.Equal(t, 401, send(http.MethodDelete, "http://localhost:8080/api/user", `{"password": "wrong-password"}`, cookies).Code)
+
+		rr := send(http.MethodDelete, "http://localhost:8080/api/user", `{"password": "wrong-password"}`, cookies)
+		assert.Equal(t, 429, rr.Code)
+		assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+
+		assert.Equal(t, 429, send(http.MethodDelete, "http://localhost:8080/api/user", `{"password": "gopher-12345"}`, cookies).Code)
+	})
+}
+
+func TestTwoFactor(t *testing.T) {
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+
+	user := testUser{
+		login:    strings.ToLower(loginGenerator(10)),
+		password: "gopher-12345",
+	}
+	credentials := `{"login": "` + user.login + `","password": "` + user.password + `"}`
+
+	send := func(method string, url string, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBuffer([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		addCookies(req, cookies)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	signUpRes := send(http.MethodPost, "http://localhost:8080/api/user/register", credentials, nil)
+	cookies := signUpRes.Result().Cookies()
+
+	startRes := send(http.MethodPost, "http://localhost:8080/api/user/2fa", "", cookies)
+	assert.Equal(t, 200, startRes.Code)
+	var enrolment struct {
+		Secret string `json:"secret"`
+		URI    string `json:"otpauth_uri"`
+	}
+	assert.NoError(t, json.Unmarshal(startRes.Body.Bytes(), &enrolment))
+	assert.Contains(t, enrolment.URI, "otpauth://totp/")
+
+	confirmRes := send(http.MethodPost, "http://localhost:8080/api/user/2fa/confirm", `{"code": "000000x"}`, cookies)
+	assert.Equal(t, 400, confirmRes.Code)
+
+	code, err := totp.Code(enrolment.Secret, time.Now())
+	assert.NoError(t, err)
+	confirmRes = send(http.MethodPost, "http://localhost:8080/api/user/2fa/confirm", `{"code": "`+code+`"}`, cookies)
+	assert.Equal(t, 200, confirmRes.Code)
+	var recovery struct {
+		Codes []string `json:"recovery_codes"`
+	}
+	assert.NoError(t, json.Unmarshal(confirmRes.Body.Bytes(), &recovery))
+	assert.Len(t, recovery.Codes, 10)
+
+	loginRes := send(http.MethodPost, "http://localhost:8080/api/user/login", credentials, nil)
+	assert.Equal(t, 202, loginRes.Code)
+	assert.Empty(t, loginRes.Result().Cookies())
+	var challenge struct {
+		Challenge string `json:"challenge"`
+	}
+	assert.NoError(t, json.Unmarshal(loginRes.Body.Bytes(), &challenge))
+
+	tests := []struct {
+		name       string
+		challenge  string
+		code       string
+		statusCode int
+	}{
+		{
+			name:       "#1 wrong challenge",
+			challenge:  "wrong",
+			code:       recovery.Codes[0],
+			statusCode: 401,
+		},
+		{
+			name:       "#2 used TOTP code",
+			challenge:  challenge.Challenge,
+			code:       code,
+			statusCode: 401,
+		},
+		{
+			name:       "#3 recovery code",
+			challenge:  challenge.Challenge,
+			code:       recovery.Codes[0],
+			statusCode: 200,
+		},
+		{
+			name:       "#4 used recovery code",
+			challenge:  challenge.Challenge,
+			code:       recovery.Codes[0],
+			statusCode: 401,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"challenge": "` + tt.challenge + `","code": "` + tt.code + `"}`
+			rr := send(http.MethodPost, "http://localhost:8080/api/user/login/2fa", body, nil)
+			assert.Equal(t, tt.statusCode, rr.Code)
+		})
+	}
+
+	disableRes := send(http.MethodDelete, "http://localhost:8080/api/user/2fa", `{"password": "wrong-password","code": "`+recovery.Codes[1]+`"}`, cookies)
+	assert.Equal(t, 401, disableRes.Code)
+	disableRes = send(http.MethodDelete, "http://localhost:8080/api/user/2fa", `{"password": "`+user.password+`","code": "wrong-recovery-code"}`, cookies)
+	assert.Equal(t, 401, disableRes.Code)
+
+	disableRes = send(http.MethodDelete, "http://localhost:8080/api/user/2fa", `{"password": "`+user.password+`","code": "`+recovery.Codes[1]+`"}`, cookies)
+	assert.Equal(t, 200, disableRes.Code)
+
+	loginRes = send(http.MethodPost, "http://localhost:8080/api/user/login", credentials, nil)
+	assert.Equal(t, 200, loginRes.Code)
+}
+
+func TestDisableTwoFactorLockout(t *testing.T) {
+	maxAttempts, baseDelay := config.ReadyConfig.LoginMaxAttempts, config.ReadyConfig.LoginBaseDelay
+	config.ReadyConfig.LoginMaxAttempts, config.ReadyConfig.LoginBaseDelay = 2, 0
+	defer func() {
+		config.ReadyConfig.LoginMaxAttempts, config.ReadyConfig.LoginBaseDelay = maxAttempts, baseDelay
+	}()
+
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+
+	send := func(method string, url string, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBuffer([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		addCookies(req, cookies)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	cookies := send(http.MethodPost, "http://localhost:8080/api/user/register", `{"login": "`+strings.ToLower(loginGenerator(10))+`","password": "gopher-12345"}`, nil).Result().Cookies()
+
+	var enrolment struct {
+		Secret string `json:"secret"`
+	}
+	assert.NoError(t, json.Unmarshal(send(http.MethodPost, "http://localhost:8080/api/user/2fa", "", cookies).Body.Bytes(), &enrolment))
+	code, err := totp.Code(enrolment.Secret, time.Now())
+	assert.NoError(t, err)
+	confirmRes := send(http.MethodPost, "http://localhost:8080/api/user/2fa/confirm", `{"code": "`+code+`"}`, cookies)
+	assert.Equal(t, 200, confirmRes.Code)
+	var recovery struct {
+		Codes []string `json:"recovery_codes"`
+	}
+	assert.NoError(t, json.Unmarshal(confirmRes.Body.Bytes(), &recovery))
+
+	wrongCode := `{"password": "gopher-12345","code": "wrong-recovery-code"}`
+	assert.Equal(t, 401, send(http.MethodDelete, "http://localhost:8080/api/user/2fa", wrongCode, cookies).Code)
+
+	rr := send(http.MethodDelete, "http://localhost:8080/api/user/2fa", wrongCode, cookies)
+	assert.Equal(t, 429, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"too_many_attempts"`)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+
+	rr = send(http.MethodDelete, "http://localhost:8080/api/user/2fa", `{"password": "gopher-12345","code": "`+recovery.Codes[0]+`"}`, cookies)
+	assert.Equal(t, 429, rr.Code)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	stub := oidctest.NewProvider("gophermart", "client-secret")
+	defer stub.Close()
+	stub.User = oidctest.User{Subject: loginGenerator(10), PreferredUsername: strings.ToLower(loginGenerator(10))}
+
+	config.ReadyConfig.OIDCIssuer = stub.Issuer()
+	config.ReadyConfig.OIDCClientID = "gophermart"
+	config.ReadyConfig.OIDCClientSecret = "client-secret"
+	config.ReadyConfig.OIDCAutoCreate = true
+	defer func() {
+		config.ReadyConfig.OIDCIssuer = ""
+		config.ReadyConfig.OIDCAutoCreate = false
+	}()
+
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+
+	noRedirects := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	// login goes to the provider and returns the callback URL and the flow cookies.
+	login := func() (string, []*http.Cookie) {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/user/oidc/login", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusFound, rr.Code)
+
+		res, err := noRedirects.Get(rr.Header().Get("Location"))
+		if !assert.NoError(t, err) {
+			return "", nil
+		}
+		defer res.Body.Close()
+		return res.Header.Get("Location"), rr.Result().Cookies()
+	}
+
+	tests := []struct {
+		name       string
+		state      string
+		statusCode int
+	}{
+		{
+			name:       "#1 state doesn't match",
+			state:      "another-state",
+			statusCode: 400,
+		},
+		{
+			name:       "#2 account created on the first login",
+			statusCode: 200,
+		},
+		{
+			name:       "#3 second login",
+			statusCode: 200,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			callback, cookies := login()
+			if tt.state != "" {
+				callbackURL, err := url.Parse(callback)
+				assert.NoError(t, err)
+				query := callbackURL.Query()
+				query.Set("state", tt.state)
+				callbackURL.RawQuery = query.Encode()
+				callback = callbackURL.String()
+			}
+
+			req := httptest.NewRequest(http.MethodGet, callback, nil)
+			addCookies(req, cookies)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			assert.Equal(t, tt.statusCode, rr.Code)
+
+			if tt.statusCode == 200 {
+				balanceReq := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/user/balance/", nil)
+				addCookies(balanceReq, rr.Result().Cookies())
+				balanceRes := httptest.NewRecorder()
+				router.ServeHTTP(balanceRes, balanceReq)
+				assert.Equal(t, 200, balanceRes.Code)
+			}
+		})
+	}
+
+	info, err := storage.GetAccountInfo(context.Background(), stub.User.PreferredUsername)
+	assert.NoError(t, err)
+	assert.Equal(t, stub.User.PreferredUsername, info.Login)
+
+	passwordLogin := `{"login": "` + stub.User.PreferredUsername + `","password": ""}`
+	loginReq := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/login", bytes.NewBuffer([]byte(passwordLogin)))
+	loginReq.Header.Set("Content-Type", "application/json")
+	loginRes := httptest.NewRecorder()
+	router.ServeHTTP(loginRes, loginReq)
+	assert.Equal(t, 401, loginRes.Code)
+}
+
+func TestOIDCAccountDeletion(t *testing.T) {
+	stub := oidctest.NewProvider("gophermart", "client-secret")
+	defer stub.Close()
+
+	config.ReadyConfig.OIDCIssuer = stub.Issuer()
+	config.ReadyConfig.OIDCClientID = "gophermart"
+	config.ReadyConfig.OIDCClientSecret = "client-secret"
+	config.ReadyConfig.OIDCAutoCreate = true
+	defer func() {
+		config.ReadyConfig.OIDCIssuer = ""
+		config.ReadyConfig.OIDCAutoCreate = false
+	}()
+
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+
+	noRedirects := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	// login creates an account on the provider login and returns the session cookies.
+	login := func() []*http.Cookie {
+		stub.User = oidctest.User{Subject: loginGenerator(10), PreferredUsername: strings.ToLower(loginGenerator(10))}
+
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/user/oidc/login", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusFound, rr.Code)
+
+		res, err := noRedirects.Get(rr.Header().Get("Location"))
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		callbackReq := httptest.NewRequest(http.MethodGet, res.Header.Get("Location"), nil)
+		addCookies(callbackReq, rr.Result().Cookies())
+		callbackRes := httptest.NewRecorder()
+		router.ServeHTTP(callbackRes, callbackReq)
+		require.Equal(t, 200, callbackRes.Code)
+		return callbackRes.Result().Cookies()
+	}
+	deleteAccount := func(cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, "http://localhost:8080/api/user", bytes.NewBuffer([]byte(`{}`)))
+		req.Header.Set("Content-Type", "application/json")
+		addCookies(req, cookies)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("#1 fresh login confirms the deletion", func(t *testing.T) {
+		assert.Equal(t, 200, deleteAccount(login()).Code)
+
+		_, err := storage.GetAccountInfo(context.Background(), stub.User.PreferredUsername)
+		assert.Error(t, err)
+	})
+
+	t.Run("#2 stale login has to log in again", func(t *testing.T) {
+		cookies := login()
+
+		_, err := bun.NewDB(db, pgdialect.New()).NewUpdate().
+			TableExpr("sessions").
+			Set("created_at = ?", time.Now().Add(-time.Hour)).
+			Where("user_id = (SELECT id FROM users WHERE login = ?)", stub.User.PreferredUsername).
+			Exec(context.Background())
+		require.NoError(t, err)
+
+		rr := deleteAccount(cookies)
+		assert.Equal(t, 403, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"code":"reauthentication_required"`)
+
+		_, err = storage.GetAccountInfo(context.Background(), stub.User.PreferredUsername)
+		assert.NoError(t, err)
+	})
+}
+
+func TestAccountState(t *testing.T) {
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+
+	send := func(method string, url string, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBuffer([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		addCookies(req, cookies)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	user := strings.ToLower(loginGenerator(10))
+	userCredentials := `{"login": "` + user + `","password": "gopher-12345"}`
+	userCookies := send(http.MethodPost, "http://localhost:8080/api/user/register", userCredentials, nil).Result().Cookies()
+
+	admin := strings.ToLower(loginGenerator(10))
+	adminCredentials := `{"login": "` + admin + `","password": "gopher-12345"}`
+	send(http.MethodPost, "http://localhost:8080/api/user/register", adminCredentials, nil)
+	err = storage.GrantRole(context.Background(), strings.ToUpper(admin), common.RoleAdmin)
+	assert.NoError(t, err)
+	adminCookies := send(http.MethodPost, "http://localhost:8080/api/user/login", adminCredentials, nil).Result().Cookies()
+
+	// Logins are case-insensitive, so admins find users however they type the login.
+	stateURL := "http://localhost:8080/api/admin/users/" + strings.ToUpper(user) + "/state"
+	withdrawal := `{"order": "2377225624", "sum": 1}`
+
+	tests := []struct {
+		name        string
+		state       string
+		statusCode  int
+		balance     int
+		withdraw    int
+		loginStatus int
+	}{
+		{
+			name:       "#1 no reason",
+			state:      `{"state": "frozen"}`,
+			statusCode: 400,
+		},
+		{
+			name:       "#2 unknown state",
+			state:      `{"state": "deleted", "reason": "test"}`,
+			statusCode: 400,
+		},
+		{
+			name:        "#3 frozen account can't withdraw",
+			state:       `{"state": "frozen", "reason": "Chargeback"}`,
+			statusCode:  200,
+			balance:     200,
+			withdraw:    403,
+			loginStatus: 200,
+		},
+		{
+			name:        "#4 suspended account is rejected",
+			state:       `{"state": "suspended", "reason": "Account takeover"}`,
+			statusCode:  200,
+			balance:     403,
+			withdraw:    403,
+			loginStatus: 403,
+		},
+		{
+			name:        "#5 active account again",
+			state:       `{"state": "active"}`,
+			statusCode:  200,
+			balance:     200,
+			withdraw:    402,
+			loginStatus: 200,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := send(http.MethodPut, stateURL, tt.state, adminCookies)
+			assert.Equal(t, tt.statusCode, rr.Code)
+			if tt.statusCode != 200 {
+				return
+			}
+
+			assert.Equal(t, tt.balance, send(http.MethodGet, "http://localhost:8080/api/user/balance/", "", userCookies).Code)
+			assert.Equal(t, tt.withdraw, send(http.MethodPost, "http://localhost:8080/api/user/balance/withdraw", withdrawal, userCookies).Code)
+			assert.Equal(t, tt.loginStatus, send(http.MethodPost, "http://localhost:8080/api/user/login", userCredentials, nil).Code)
+		})
+	}
+
+	historyRes := send(http.MethodGet, stateURL, "", adminCookies)
+	assert.Equal(t, 200, historyRes.Code)
+	var history []common.AccountStateChange
+	assert.NoError(t, json.Unmarshal(historyRes.Body.Bytes(), &history))
+	if assert.Len(t, history, 3) {
+		assert.Equal(t, common.AccountActive, history[0].State)
+		assert.Equal(t, common.AccountSuspended, history[0].From)
+		assert.Equal(t, "Account takeover", history[1].Reason)
+		assert.Equal(t, admin, history[2].ChangedBy)
+	}
+}
+
+func TestGetOrdersPagination(t *testing.T) {
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+
+	send := func(method string, url string, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBuffer([]byte(body)))
+		addCookies(req, cookies)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	credentials := `{"login": "` + strings.ToLower(loginGenerator(10)) + `","password": "gopher-12345"}`
+	cookies := send(http.MethodPost, "http://localhost:8080/api/user/register", credentials, nil).Result().Cookies()
+
+	uploaded := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		number := orderGenerator()
+		uploaded[number] = true
+		assert.Equal(t, 202, send(http.MethodPost, "http://localhost:8080/api/user/orders/", number, cookies).Code)
+	}
+
+	getPage := func(query string) ([]common.Order, string, int) {
+		rr := send(http.MethodGet, "http://localhost:8080/api/user/orders/?"+query, "", cookies)
+		var orders []common.Order
+		if rr.Code == 200 {
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &orders))
+		}
+		return orders, rr.Header().Get("X-Next-Cursor"), rr.Code
+	}
+
+	t.Run("#1 pages cover all orders", func(t *testing.T) {
+		first, cursor, code := getPage("limit=2")
+		assert.Equal(t, 200, code)
+		assert.Len(t, first, 2)
+		assert.NotEmpty(t, cursor)
+
+		second, last, code := getPage("limit=2&cursor=" + url.QueryEscape(cursor))
+		assert.Equal(t, 200, code)
+		assert.Len(t, second, 1)
+		assert.Empty(t, last)
+
+		seen := make(map[string]bool)
+		for _, order := range append(first, second...) {
+			seen[order.Order] = true
+		}
+		assert.Equal(t, uploaded, seen)
+	})
+
+	t.Run("#2 descending order is reversed", func(t *testing.T) {
+		asc, _, _ := getPage("sort=asc")
+		desc, _, _ := getPage("sort=desc")
+		if assert.Len(t, desc, 3) && assert.Len(t, asc, 3) {
+			assert.Equal(t, asc[0].Order, desc[2].Order)
+			assert.Equal(t, asc[2].Order, desc[0].Order)
+		}
+	})
+
+	t.Run("#3 filters", func(t *testing.T) {
+		orders, _, code := getPage("status=NEW,PROCESSING,INVALID,PROCESSED")
+		assert.Equal(t, 200, code)
+		assert.Len(t, orders, 3)
+
+		_, _, code = getPage("from=" + url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)))
+		assert.Equal(t, 204, code)
+	})
+
+	t.Run("#4 wrong parameters", func(t *testing.T) {
+		for _, query := range []string{"limit=0", "limit=101", "cursor=broken", "status=LOST", "sort=up", "from=yesterday"} {
+			_, _, code := getPage(query)
+			assert.Equal(t, 400, code, query)
+		}
+	})
+
+	t.Run("#5 request without parameters gets every order", func(t *testing.T) {
+		numbers := make([]string, pagination.DefaultLimit)
+		for i := range numbers {
+			numbers[i] = orderGenerator()
+		}
+		batch, err := json.Marshal(numbers)
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/orders/batch", bytes.NewBuffer(batch))
+		req.Header.Set("Content-Type", "application/json")
+		addCookies(req, cookies)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, 200, rr.Code)
+
+		orders, cursor, code := getPage("")
+		assert.Equal(t, 200, code)
+		assert.Len(t, orders, pagination.DefaultLimit+3)
+		assert.Empty(t, cursor)
+
+		orders, cursor, _ = getPage("limit=" + strconv.Itoa(pagination.DefaultLimit))
+		assert.Len(t, orders, pagination.DefaultLimit)
+		assert.NotEmpty(t, cursor)
+	})
+}
+
+func TestGetWithdrawalsPagination(t *testing.T) {
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+
+	send := func(method string, url string, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBuffer([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		addCookies(req, cookies)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	login := strings.ToLower(loginGenerator(10))
+	credentials := `{"login": "` + login + `","password": "gopher-12345"}`
+	cookies := send(http.MethodPost, "http://localhost:8080/api/user/register", credentials, nil).Result().Cookies()
+
+	dbBun := bun.NewDB(db, pgdialect.New())
+	_, err = dbBun.NewUpdate().
+		TableExpr("users").
+		Set("balance = 100").
+		Where("login = ?", login).
+		Exec(context.Background())
+	assert.NoError(t, err)
+
+	for _, sum := range []string{"10", "20", "30"} {
+		rr := send(http.MethodPost, "http://localhost:8080/api/user/balance/withdraw", `{"order": "`+orderGenerator()+`", "sum": `+sum+`}`, cookies)
+		assert.Equal(t, 200, rr.Code)
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		statusCode int
+		count      int
+		total      string
+		next       bool
+	}{
+		{
+			name:       "#1 first page",
+			query:      "limit=2",
+			statusCode: 200,
+			count:      2,
+			total:      "60",
+			next:       true,
+		},
+		{
+			name:       "#2 sum filter",
+			query:      "min_sum=15&max_sum=30",
+			statusCode: 200,
+			count:      2,
+			total:      "50",
+		},
+		{
+			name:       "#3 nothing matches",
+			query:      "max_sum=5",
+			statusCode: 204,
+		},
+		{
+			name:       "#4 negative sum",
+			query:      "min_sum=-1",
+			statusCode: 400,
+		},
+		{
+			name:       "#5 empty sum range",
+			query:      "min_sum=20&max_sum=10",
+			statusCode: 400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := send(http.MethodGet, "http://localhost:8080/api/user/withdrawals?"+tt.query, "", cookies)
+			assert.Equal(t, tt.statusCode, rr.Code)
+			if tt.statusCode != 200 {
+				return
+			}
+
+			var withdrawals []common.OrdersWithSpentBonuses
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &withdrawals))
+			assert.Len(t, withdrawals, tt.count)
+			assert.Equal(t, tt.total, rr.Header().Get("X-Total-Sum"))
+			assert.Equal(t, tt.next, rr.Header().Get("X-Next-Cursor") != "")
+		})
+	}
+
+	t.Run("#6 request without parameters gets every withdrawal", func(t *testing.T) {
+		_, err = dbBun.NewUpdate().
+			TableExpr("users").
+			Set("balance = balance + ?", pagination.DefaultLimit).
+			Where("login = ?", login).
+			Exec(context.Background())
+		assert.NoError(t, err)
+		for i := 0; i < pagination.DefaultLimit; i++ {
+			rr := send(http.MethodPost, "http://localhost:8080/api/user/balance/withdraw", `{"order": "`+orderGenerator()+`", "sum": 1}`, cookies)
+			assert.Equal(t, 200, rr.Code)
+		}
+
+		rr := send(http.MethodGet, "http://localhost:8080/api/user/withdrawals", "", cookies)
+		assert.Equal(t, 200, rr.Code)
+		assert.Empty(t, rr.Header().Get("X-Next-Cursor"))
+
+		var withdrawals []common.OrdersWithSpentBonuses
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &withdrawals))
+		assert.Len(t, withdrawals, pagination.DefaultLimit+3)
+	})
+}
+
+func TestGetOrder(t *testing.T) {
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+
+	send := func(method string, url string, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBuffer([]byte(body)))
+		addCookies(req, cookies)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	register := func() ([]*http.Cookie, int64) {
+		login := strings.ToLower(loginGenerator(10))
+		cookies := send(http.MethodPost, "http://localhost:8080/api/user/register", `{"login": "`+login+`","password": "gopher-12345"}`, nil).Result().Cookies()
+		var user psql.User
+		assert.NoError(t, bun.NewDB(db, pgdialect.New()).NewSelect().Model(&user).Where("login = ?", login).Scan(context.Background()))
+		return cookies, user.ID
+	}
+
+	owner, ownerID := register()
+	stranger, _ := register()
+
+	number := orderGenerator()
+	assert.Equal(t, 202, send(http.MethodPost, "http://localhost:8080/api/user/orders/", number, owner).Code)
+
+	for _, update := range []common.OrderUpdateFromAccural{
+		{Order: number, Status: "PROCESSING", Payload: []byte(`{"order":"` + number + `","status":"PROCESSING"}`)},
+		{Order: number, Status: "PROCESSING", Payload: []byte(`{"order":"` + number + `","status":"PROCESSING"}`)},
+		{Order: number},
+		{Order: number, Status: "PROCESSED", Accrual: 500, Payload: []byte(`{"order":"` + number + `","status":"PROCESSED","accrual":500}`)},
+	} {
+		assert.NoError(t, storage.UpdateStatus(context.Background(), update, ownerID))
+	}
+
+	t.Run("#1 order with timeline", func(t *testing.T) {
+		rr := send(http.MethodGet, "http://localhost:8080/api/user/orders/"+number, "", owner)
+		assert.Equal(t, 200, rr.Code)
+
+		var order common.OrderDetails
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &order))
+		assert.Equal(t, number, order.Order)
+		assert.Equal(t, "PROCESSED", order.Status)
+		if assert.Len(t, order.Timeline, 3) {
+			assert.Equal(t, "", order.Timeline[0].From)
+			assert.Equal(t, "NEW", order.Timeline[0].Status)
+			assert.Equal(t, "NEW", order.Timeline[1].From)
+			assert.Equal(t, "PROCESSING", order.Timeline[1].Status)
+			assert.Equal(t, "PROCESSED", order.Timeline[2].Status)
+			if assert.NotNil(t, order.Timeline[2].Accrual) {
+				assert.Equal(t, float32(500), *order.Timeline[2].Accrual)
+			}
+			assert.JSONEq(t, `{"order":"`+number+`","status":"PROCESSED","accrual":500}`, string(order.Timeline[2].Payload))
+		}
+	})
+
+	t.Run("#2 order of another user", func(t *testing.T) {
+		assert.Equal(t, 404, send(http.MethodGet, "http://localhost:8080/api/user/orders/"+number, "", stranger).Code)
+	})
+
+	t.Run("#3 unknown and wrong numbers", func(t *testing.T) {
+		assert.Equal(t, 404, send(http.MethodGet, "http://localhost:8080/api/user/orders/"+orderGenerator(), "", owner).Code)
+		assert.Equal(t, 422, send(http.MethodGet, "http://localhost:8080/api/user/orders/12345", "", owner).Code)
+	})
+}
+
+func TestUploadOrders(t *testing.T) {
+	limit := config.ReadyConfig.OrdersBatchLimit
+	config.ReadyConfig.OrdersBatchLimit = 5
+	defer func() {
+		config.ReadyConfig.OrdersBatchLimit = limit
+	}()
+
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+
+	send := func(contentType string, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/orders/batch", bytes.NewBuffer([]byte(body)))
+		req.Header.Set("Content-Type", contentType)
+		addCookies(req, cookies)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	register := func() []*http.Cookie {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/register",
+			bytes.NewBuffer([]byte(`{"login": "`+strings.ToLower(loginGenerator(10))+`","password": "gopher-12345"}`)))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Result().Cookies()
+	}
+
+	user, other := register(), register()
+
+	othersOrder := orderGenerator()
+	assert.Equal(t, 200, send("application/json", `["`+othersOrder+`"]`, other).Code)
+
+	results := func(rr *httptest.ResponseRecorder) map[string]string {
+		var list []common.OrderUploadResult
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &list))
+		byNumber := make(map[string]string)
+		for _, result := range list {
+			byNumber[result.Number] = result.Result
+		}
+		return byNumber
+	}
+
+	t.Run("#1 JSON array", func(t *testing.T) {
+		first, second := orderGenerator(), orderGenerator()
+		rr := send("application/json", `["`+first+`", "`+second+`", "`+othersOrder+`", "12345", "`+first+`"]`, user)
+		assert.Equal(t, 200, rr.Code)
+
+		var list []common.OrderUploadResult
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &list))
+		assert.Equal(t, []common.OrderUploadResult{
+			{Number: first, Result: common.UploadAccepted},
+			{Number: second, Result: common.UploadAccepted},
+			{Number: othersOrder, Result: common.UploadOwnedByOther},
+			{Number: "12345", Result: common.UploadInvalid},
+			{Number: first, Result: common.UploadAlreadyYours},
+		}, list)
+
+		assert.Equal(t, map[string]string{first: common.UploadAlreadyYours}, results(send("application/json", `["`+first+`"]`, user)))
+	})
+
+	t.Run("#2 CSV body with a header", func(t *testing.T) {
+		number := orderGenerator()
+		rr := send("text/csv", "number,shop\n"+number+",Gopher Store\n\n", user)
+		assert.Equal(t, 200, rr.Code)
+		assert.Equal(t, map[string]string{number: common.UploadAccepted}, results(rr))
+	})
+
+	t.Run("#3 CSV file", func(t *testing.T) {
+		number := orderGenerator()
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		file, err := form.CreateFormFile("file", "receipts.csv")
+		assert.NoError(t, err)
+		_, err = file.Write([]byte(number + "\n"))
+		assert.NoError(t, err)
+		assert.NoError(t, form.Close())
+
+		rr := send(form.FormDataContentType(), body.String(), user)
+		assert.Equal(t, 200, rr.Code)
+		assert.Equal(t, map[string]string{number: common.UploadAccepted}, results(rr))
+	})
+
+	t.Run("#4 wrong requests", func(t *testing.T) {
+		assert.Equal(t, 400, send("application/json", `[]`, user).Code)
+		assert.Equal(t, 400, send("application/json", `["1", "2", "3", "4", "5", "6"]`, user).Code)
+		assert.Equal(t, 400, send("application/json", `{"orders": []}`, user).Code)
+		assert.Equal(t, 415, send("text/plain", orderGenerator(), user).Code)
+		assert.Equal(t, 401, send("application/json", `["`+orderGenerator()+`"]`, nil).Code)
+	})
+}
+
+func TestEvents(t *testing.T) {
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	send := func(method string, url string, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBuffer([]byte(body)))
+		addCookies(req, cookies)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	login := strings.ToLower(loginGenerator(10))
+	cookies := send(http.MethodPost, "http://localhost:8080/api/user/register", `{"login": "`+login+`","password": "gopher-12345"}`, nil).Result().Cookies()
+	var user psql.User
+	assert.NoError(t, bun.NewDB(db, pgdialect.New()).NewSelect().Model(&user).Where("login = ?", login).Scan(context.Background()))
+
+	number := orderGenerator()
+	assert.Equal(t, 202, send(http.MethodPost, "http://localhost:8080/api/user/orders/", number, cookies).Code)
+
+	type event struct {
+		id   string
+		name string
+		data string
+	}
+
+	// open connects to the stream and returns a channel of events read from it.
+	open := func(lastEventID string) (chan event, func()) {
+		ctx, cancel := context.WithCancel(context.Background())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/user/events", nil)
+		assert.NoError(t, err)
+		addCookies(req, cookies)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			return nil, cancel
+		}
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		received := make(chan event, 10)
+		go func() {
+			defer resp.Body.Close()
+			scanner := bufio.NewScanner(resp.Body)
+			var current event
+			for scanner.Scan() {
+				line := scanner.Text()
+				switch {
+				case strings.HasPrefix(line, "id: "):
+					current.id = strings.TrimPrefix(line, "id: ")
+				case strings.HasPrefix(line, "event: "):
+					current.name = strings.TrimPrefix(line, "event: ")
+				case strings.HasPrefix(line, "data: "):
+					current.data = strings.TrimPrefix(line, "data: ")
+				case line == "" && current.id != "":
+					received <- current
+					current = event{}
+				}
+			}
+		}()
+		return received, cancel
+	}
+
+	next := func(received chan event) event {
+		select {
+		case e := <-received:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event received")
+			return event{}
+		}
+	}
+
+	received, closeStream := open("")
+	assert.NoError(t, storage.UpdateStatus(context.Background(), common.OrderUpdateFromAccural{Order: number, Status: "PROCESSED", Accrual: 500}, user.ID))
+
+	orderEvent := next(received)
+	assert.Equal(t, "order", orderEvent.name)
+	var change events.OrderStatusData
+	assert.NoError(t, json.Unmarshal([]byte(orderEvent.data), &change))
+	assert.Equal(t, events.OrderStatusData{Number: number, From: "NEW", Status: "PROCESSED", Accrual: 500, ChangedAt: change.ChangedAt}, change)
+
+	balanceEvent := next(received)
+	assert.Equal(t, "balance", balanceEvent.name)
+	assert.JSONEq(t, `{"current": 500, "withdrawn": 0}`, balanceEvent.data)
+	closeStream()
+
+	t.Run("#1 reconnect with Last-Event-ID", func(t *testing.T) {
+		received, closeStream := open(orderEvent.id)
+		defer closeStream()
+
+		missed := next(received)
+		assert.Equal(t, balanceEvent, missed)
+	})
+
+	t.Run("#2 not authenticated", func(t *testing.T) {
+		assert.Equal(t, 401, send(http.MethodGet, "http://localhost:8080/api/user/events", "", nil).Code)
+	})
+
+	t.Run("#3 withdrawal changes the balance", func(t *testing.T) {
+		received, closeStream := open("")
+		defer closeStream()
+
+		assert.NoError(t, storage.SpendBonuses(context.Background(), user.ID, orderGenerator(), 30))
+
+		withdrawalEvent := next(received)
+		assert.Equal(t, "balance", withdrawalEvent.name)
+		assert.JSONEq(t, `{"current": 470, "withdrawn": 30}`, withdrawalEvent.data)
+	})
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+
+	send := func(method string, url string, body string, key string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBuffer([]byte(body)))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		addCookies(req, cookies)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	login := strings.ToLower(loginGenerator(10))
+	cookies := send(http.MethodPost, "http://localhost:8080/api/user/register", `{"login": "`+login+`","password": "gopher-12345"}`, "", nil).Result().Cookies()
+
+	_, err = bun.NewDB(db, pgdialect.New()).NewUpdate().
+		TableExpr("users").
+		Set("balance = 100").
+		Where("login = ?", login).
+		Exec(context.Background())
+	assert.NoError(t, err)
+
+	t.Run("#1 retried withdrawal is replayed", func(t *testing.T) {
+		withdrawal := `{"order": "` + orderGenerator() + `", "sum": 30}`
+		key := loginGenerator(16)
+
+		first := send(http.MethodPost, "http://localhost:8080/api/user/balance/withdraw", withdrawal, key, cookies)
+		assert.Equal(t, 200, first.Code)
+		assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+		retry := send(http.MethodPost, "http://localhost:8080/api/user/balance/withdraw", withdrawal, key, cookies)
+		assert.Equal(t, 200, retry.Code)
+		assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, first.Body.String(), retry.Body.String())
+
+		var balance struct {
+			Current float32 `json:"current"`
+		}
+		assert.NoError(t, json.Unmarshal(send(http.MethodGet, "http://localhost:8080/api/user/balance/", "", "", cookies).Body.Bytes(), &balance))
+		assert.Equal(t, float32(70), balance.Current)
+
+		other := send(http.MethodPost, "http://localhost:8080/api/user/balance/withdraw", `{"order": "`+orderGenerator()+`", "sum": 30}`, key, cookies)
+		assert.Equal(t, 422, other.Code)
+	})
+
+	t.Run("#2 retried order upload is replayed", func(t *testing.T) {
+		number := orderGenerator()
+		key := loginGenerator(16)
+
+		assert.Equal(t, 202, send(http.MethodPost, "http://localhost:8080/api/user/orders/", number, key, cookies).Code)
+		retry := send(http.MethodPost, "http://localhost:8080/api/user/orders/", number, key, cookies)
+		assert.Equal(t, 202, retry.Code)
+		assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+
+		assert.Equal(t, 200, send(http.MethodPost, "http://localhost:8080/api/user/orders/", number, "", cookies).Code)
+	})
+
+	t.Run("#3 keys are separate for every user", func(t *testing.T) {
+		key := loginGenerator(16)
+		assert.Equal(t, 202, send(http.MethodPost, "http://localhost:8080/api/user/orders/", orderGenerator(), key, cookies).Code)
+
+		otherCookies := send(http.MethodPost, "http://localhost:8080/api/user/register", `{"login": "`+strings.ToLower(loginGenerator(10))+`","password": "gopher-12345"}`, "", nil).Result().Cookies()
+		rr := send(http.MethodPost, "http://localhost:8080/api/user/orders/", orderGenerator(), key, otherCookies)
+		assert.Equal(t, 202, rr.Code)
+		assert.Empty(t, rr.Header().Get("Idempotent-Replayed"))
+	})
+}
+
+func TestWithdrawalReversal(t *testing.T) {
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+
+	send := func(method string, url string, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBuffer([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		addCookies(req, cookies)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	getBalance := func(cookies []*http.Cookie) (float32, float32) {
+		var balance struct {
+			Current   float32 `json:"current"`
+			Withdrawn float32 `json:"withdrawn"`
+		}
+		assert.NoError(t, json.Unmarshal(send(http.MethodGet, "http://localhost:8080/api/user/balance/", "", cookies).Body.Bytes(), &balance))
+		return balance.Current, balance.Withdrawn
+	}
+
+	login := strings.ToLower(loginGenerator(10))
+	cookies := send(http.MethodPost, "http://localhost:8080/api/user/register", `{"login": "`+login+`","password": "gopher-12345"}`, nil).Result().Cookies()
+
+	_, err = bun.NewDB(db, pgdialect.New()).NewUpdate().
+		TableExpr("users").
+		Set("balance = 100").
+		Where("login = ?", login).
+		Exec(context.Background())
+	assert.NoError(t, err)
+
+	admin := strings.ToLower(loginGenerator(10))
+	adminCredentials := `{"login": "` + admin + `","password": "gopher-12345"}`
+	send(http.MethodPost, "http://localhost:8080/api/user/register", adminCredentials, nil)
+	err = storage.GrantRole(context.Background(), admin, common.RoleAdmin)
+	assert.NoError(t, err)
+	adminCookies := send(http.MethodPost, "http://localhost:8080/api/user/login", adminCredentials, nil).Result().Cookies()
+
+	first := orderGenerator()
+	second := orderGenerator()
+	assert.Equal(t, 200, send(http.MethodPost, "http://localhost:8080/api/user/balance/withdraw", `{"order": "`+first+`", "sum": 30}`, cookies).Code)
+	assert.Equal(t, 200, send(http.MethodPost, "http://localhost:8080/api/user/balance/withdraw", `{"order": "`+second+`", "sum": 20}`, cookies).Code)
+
+	t.Run("#1 user reverses a withdrawal twice", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			rr := send(http.MethodPost, "http://localhost:8080/api/user/withdrawals/"+first+"/reversal", "", cookies)
+			assert.Equal(t, 200, rr.Code)
+
+			var withdrawal common.OrdersWithSpentBonuses
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &withdrawal))
+			assert.Equal(t, first, withdrawal.Order)
+			assert.NotNil(t, withdrawal.ReversedAt)
+		}
+
+		current, withdrawn := getBalance(cookies)
+		assert.Equal(t, float32(80), current)
+		assert.Equal(t, float32(20), withdrawn)
+	})
+
+	t.Run("#2 reversal is shown in the history", func(t *testing.T) {
+		rr := send(http.MethodGet, "http://localhost:8080/api/user/withdrawals", "", cookies)
+		assert.Equal(t, 200, rr.Code)
+		assert.Equal(t, "20", rr.Header().Get("X-Total-Sum"))
+
+		var withdrawals []common.OrdersWithSpentBonuses
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &withdrawals))
+		assert.Len(t, withdrawals, 2)
+		for _, withdrawal := range withdrawals {
+			assert.Equal(t, withdrawal.Order == first, withdrawal.ReversedAt != nil)
+		}
+	})
+
+	t.Run("#3 withdrawal of another user", func(t *testing.T) {
+		otherCookies := send(http.MethodPost, "http://localhost:8080/api/user/register", `{"login": "`+strings.ToLower(loginGenerator(10))+`","password": "gopher-12345"}`, nil).Result().Cookies()
+		rr := send(http.MethodPost, "http://localhost:8080/api/user/withdrawals/"+second+"/reversal", "", otherCookies)
+		assert.Equal(t, 404, rr.Code)
+	})
+
+	t.Run("#4 admin reverses a withdrawal", func(t *testing.T) {
+		assert.Equal(t, 403, send(http.MethodPost, "http://localhost:8080/api/admin/withdrawals/"+second+"/reversal", "", cookies).Code)
+		assert.Equal(t, 200, send(http.MethodPost, "http://localhost:8080/api/admin/withdrawals/"+second+"/reversal", "", adminCookies).Code)
+
+		current, withdrawn := getBalance(cookies)
+		assert.Equal(t, float32(100), current)
+		assert.Equal(t, float32(0), withdrawn)
+	})
+
+	t.Run("#5 concurrent withdrawals don't overdraw the balance", func(t *testing.T) {
+		codes := make([]int, 5)
+		var wg sync.WaitGroup
+		for i := range codes {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				codes[i] = send(http.MethodPost, "http://localhost:8080/api/user/balance/withdraw", `{"order": "`+orderGenerator()+`", "sum": 30}`, cookies).Code
+			}(i)
+		}
+		wg.Wait()
+
+		accepted := 0
+		for _, code := range codes {
+			if code == 200 {
+				accepted++
+				continue
+			}
+			assert.Equal(t, 402, code)
+		}
+		assert.Equal(t, 3, accepted)
+
+		current, withdrawn := getBalance(cookies)
+		assert.Equal(t, float32(10), current)
+		assert.Equal(t, float32(90), withdrawn)
+	})
+}
+
+func TestStatement(t *testing.T) {
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+
+	send := func(method string, url string, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBuffer([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		addCookies(req, cookies)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	login := strings.ToLower(loginGenerator(10))
+	cookies := send(http.MethodPost, "http://localhost:8080/api/user/register", `{"login": "`+login+`","password": "gopher-12345"}`, nil).Result().Cookies()
+
+	accrued := orderGenerator()
+	assert.Equal(t, 202, send(http.MethodPost, "http://localhost:8080/api/user/orders/", accrued, cookies).Code)
+
+	bunDB := bun.NewDB(db, pgdialect.New())
+	_, err = bunDB.NewUpdate().
+		TableExpr("orders").
+		Set("status = 'PROCESSED', accrual = 100, uploaded_at = '2024-01-15 10:00:00'").
+		Where(`"order" = ?`, accrued).
+		Exec(context.Background())
+	assert.NoError(t, err)
+	_, err = bunDB.NewUpdate().
+		TableExpr("users").
+		Set("balance = 100").
+		Where("login = ?", login).
+		Exec(context.Background())
+	assert.NoError(t, err)
+
+	spent := orderGenerator()
+	assert.Equal(t, 200, send(http.MethodPost, "http://localhost:8080/api/user/balance/withdraw", `{"order": "`+spent+`", "sum": 30}`, cookies).Code)
+
+	tests := []struct {
+		name       string
+		query      string
+		statusCode int
+		opening    float32
+		closing    float32
+		lines      []common.BalanceChange
+	}{
+		{
+			name:       "#1 whole history",
+			statusCode: 200,
+			closing:    70,
+			lines: []common.BalanceChange{
+				{Order: accrued, Type: common.BalanceAccrual, Amount: 100, Balance: 100},
+				{Order: spent, Type: common.BalanceWithdrawal, Amount: -30, Balance: 70},
+			},
+		},
+		{
+			name:       "#2 period before the withdrawal",
+			query:      "?from=2024-01-01T00:00:00Z&to=2024-02-01T00:00:00Z",
+			statusCode: 200,
+			closing:    100,
+			lines: []common.BalanceChange{
+				{Order: accrued, Type: common.BalanceAccrual, Amount: 100, Balance: 100},
+			},
+		},
+		{
+			name:       "#3 period after the accrual",
+			query:      "?from=2024-02-01T00:00:00Z",
+			statusCode: 200,
+			opening:    100,
+			closing:    70,
+			lines: []common.BalanceChange{
+				{Order: spent, Type: common.BalanceWithdrawal, Amount: -30, Balance: 70},
+			},
+		},
+		{
+			name:       "#4 wrong period",
+			query:      "?from=2024-02-01T00:00:00Z&to=2024-01-01T00:00:00Z",
+			statusCode: 400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := send(http.MethodGet, "http://localhost:8080/api/user/statement"+tt.query, "", cookies)
+			assert.Equal(t, tt.statusCode, rr.Code)
+			if tt.statusCode != 200 {
+				return
+			}
+
+			var statement common.Statement
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &statement))
+			assert.Equal(t, tt.opening, statement.OpeningBalance)
+			assert.Equal(t, tt.closing, statement.ClosingBalance)
+			for i := range statement.Lines {
+				statement.Lines[i].Time = ""
+			}
+			assert.Equal(t, tt.lines, statement.Lines)
+		})
+	}
+}
+
+func TestHistoryExport(t *testing.T) {
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+
+	send := func(method string, url string, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBuffer([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		addCookies(req, cookies)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	login := strings.ToLower(loginGenerator(10))
+	cookies := send(http.MethodPost, "http://localhost:8080/api/user/register", `{"login": "`+login+`","password": "gopher-12345"}`, nil).Result().Cookies()
+
+	_, err = bun.NewDB(db, pgdialect.New()).NewUpdate().
+		TableExpr("users").
+		Set("balance = 100").
+		Where("login = ?", login).
+		Exec(context.Background())
+	assert.NoError(t, err)
+
+	uploaded := orderGenerator()
+	spent := orderGenerator()
+	assert.Equal(t, 202, send(http.MethodPost, "http://localhost:8080/api/user/orders/", uploaded, cookies).Code)
+	assert.Equal(t, 200, send(http.MethodPost, "http://localhost:8080/api/user/balance/withdraw", `{"order": "`+spent+`", "sum": 30}`, cookies).Code)
+
+	t.Run("#1 orders in CSV", func(t *testing.T) {
+		rr := send(http.MethodGet, "http://localhost:8080/api/user/orders/export", "", cookies)
+		assert.Equal(t, 200, rr.Code)
+		assert.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+		assert.Contains(t, rr.Header().Get("Content-Disposition"), `filename="gophermart-orders-`)
+
+		records, err := csv.NewReader(rr.Body).ReadAll()
+		assert.NoError(t, err)
+		assert.Len(t, records, 3)
+		assert.Equal(t, []string{"number", "status", "accrual", "uploaded_at"}, records[0])
+	})
+
+	t.Run("#2 withdrawals in JSON Lines", func(t *testing.T) {
+		rr := send(http.MethodGet, "http://localhost:8080/api/user/withdrawals/export?format=ndjson", "", cookies)
+		assert.Equal(t, 200, rr.Code)
+		assert.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+
+		var withdrawals []common.OrdersWithSpentBonuses
+		scanner := bufio.NewScanner(rr.Body)
+		for scanner.Scan() {
+			var withdrawal common.OrdersWithSpentBonuses
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &withdrawal))
+			withdrawals = append(withdrawals, withdrawal)
+		}
+		assert.Len(t, withdrawals, 1)
+		assert.Equal(t, spent, withdrawals[0].Order)
+		assert.Equal(t, float32(30), withdrawals[0].BonusesWithdrawn)
+	})
+
+	t.Run("#3 filters of the list are applied", func(t *testing.T) {
+		rr := send(http.MethodGet, "http://localhost:8080/api/user/orders/export?status=PROCESSED", "", cookies)
+		assert.Equal(t, 200, rr.Code)
+
+		records, err := csv.NewReader(rr.Body).ReadAll()
+		assert.NoError(t, err)
+		assert.Len(t, records, 1)
+	})
+
+	t.Run("#4 statement in CSV", func(t *testing.T) {
+		rr := send(http.MethodGet, "http://localhost:8080/api/user/statement/export", "", cookies)
+		assert.Equal(t, 200, rr.Code)
+
+		records, err := csv.NewReader(rr.Body).ReadAll()
+		assert.NoError(t, err)
+		assert.Len(t, records, 2)
+		assert.Equal(t, []string{"time", "order", "type", "amount", "balance"}, records[0])
+		assert.Equal(t, []string{spent, common.BalanceWithdrawal, "-30", "-30"}, records[1][1:])
+	})
+
+	t.Run("#5 unknown format", func(t *testing.T) {
+		assert.Equal(t, 400, send(http.MethodGet, "http://localhost:8080/api/user/orders/export?format=xlsx", "", cookies).Code)
+	})
+}
+
+func TestAPIV2(t *testing.T) {
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		logger.ErrorLogger("Can't open connection: ", err)
+	}
+	storage := psql.NewPsqlStorage(db)
+
+	h, err := handler.NewHandler(storage)
+	require.NoError(t, err)
+
+	router := router.RequestsRouter(h, storage)
+
+	send := func(method string, url string, contentType string, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBuffer([]byte(body)))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		addCookies(req, cookies)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	login := strings.ToLower(loginGenerator(10))
+	cookies := send(http.MethodPost, "http://localhost:8080/api/v2/user/register", "application/json", `{"login": "`+login+`","password": "gopher-12345"}`, nil).Result().Cookies()
+	assert.NotEmpty(t, cookies)
+
+	_, err = bun.NewDB(db, pgdialect.New()).NewUpdate().
+		TableExpr("users").
+		Set("balance = 100").
+		Where("login = ?", login).
+		Exec(context.Background())
+	assert.NoError(t, err)
+
+	t.Run("#1 order number in JSON is trimmed", func(t *testing.T) {
+		order := orderGenerator()
+		rr := send(http.MethodPost, "http://localhost:8080/api/v2/user/orders/", "application/json", `{"order": " `+order+`\n"}`, cookies)
+		assert.Equal(t, 202, rr.Code)
+
+		rr = send(http.MethodPost, "http://localhost:8080/api/user/orders/", "text/plain", order, cookies)
+		assert.Equal(t, 200, rr.Code)
+	})
+
+	t.Run("#2 wrong order uploads", func(t *testing.T) {
+		tests := []struct {
+			contentType string
+			body        string
+			wantCode    int
+			wantError   string
+		}{
+			{contentType: "text/plain", body: orderGenerator(), wantCode: 415, wantError: "unsupported_media_type"},
+			{contentType: "application/json", body: `{"order": "` + orderGenerator() + `", "sum": 1}`, wantCode: 400, wantError: "bad_request"},
+			{contentType: "application/json", body: `{"order": "  "}`, wantCode: 400, wantError: "validation_failed"},
+			{contentType: "application/json", body: `{"order": "12a45"}`, wantCode: 422, wantError: "order_invalid"},
+		}
+		for _, tt := range tests {
+			rr := send(http.MethodPost, "http://localhost:8080/api/v2/user/orders/", tt.contentType, tt.body, cookies)
+			assert.Equal(t, tt.wantCode, rr.Code)
+			assert.Contains(t, rr.Body.String(), `"code":"`+tt.wantError+`"`)
+		}
+	})
+
+	t.Run("#3 withdrawal", func(t *testing.T) {
+		assert.Equal(t, 400, send(http.MethodPost, "http://localhost:8080/api/v2/user/balance/withdraw", "application/json", `{"order": "`+orderGenerator()+`", "sum": 10, "comment": "gift"}`, cookies).Code)
+		assert.Equal(t, 400, send(http.MethodPost, "http://localhost:8080/api/v2/user/balance/withdraw", "application/json", `{"order": "`+orderGenerator()+`", "sum": -10}`, cookies).Code)
+		assert.Equal(t, 415, send(http.MethodPost, "http://localhost:8080/api/v2/user/balance/withdraw", "", `{"order": "`+orderGenerator()+`", "sum": 10}`, cookies).Code)
+
+		order := orderGenerator()
+		assert.Equal(t, 200, send(http.MethodPost, "http://localhost:8080/api/v2/user/balance/withdraw", "application/json; charset=utf-8", `{"order": " `+order+` ", "sum": 10}`, cookies).Code)
+
+		rr := send(http.MethodGet, "http://localhost:8080/api/v2/user/withdrawals", "", "", cookies)
+		assert.Equal(t, 200, rr.Code)
+		var withdrawals []common.OrdersWithSpentBonuses
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &withdrawals))
+		assert.Len(t, withdrawals, 1)
+		assert.Equal(t, order, withdrawals[0].Order)
+	})
+
+	t.Run("#4 JSON endpoints require the content type in v2 only", func(t *testing.T) {
+		credentials := `{"login": "` + strings.ToLower(loginGenerator(10)) + `","password": "gopher-12345"}`
+		assert.Equal(t, 415, send(http.MethodPost, "http://localhost:8080/api/v2/user/register", "text/plain", credentials, nil).Code)
+		assert.Equal(t, 200, send(http.MethodPost, "http://localhost:8080/api/user/register", "text/plain", credentials, nil).Code)
+	})
+
+	t.Run("#5 CSRF token is required in v2 only", func(t *testing.T) {
+		sendWithoutToken := func(url string, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, url, bytes.NewBuffer([]byte(body)))
+			req.Header.Set("Content-Type", "application/json")
+			for _, c := range cookies {
+				req.AddCookie(c)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		rr := sendWithoutToken("http://localhost:8080/api/v2/user/orders/", `{"order": "`+orderGenerator()+`"}`)
+		assert.Equal(t, 403, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"code":"csrf_token_invalid"`)
+		assert.Equal(t, 202, sendWithoutToken("http://localhost:8080/api/user/orders/", orderGenerator()).Code)
+	})
 }
@@ -0,0 +1,66 @@
+// Command gophermartctl runs administrative tasks against the gophermart database.
+//
+// Usage:
+//
+//	gophermartctl [flags] grant-admin <login>
+//
+// It accepts the same flags and environmental variables as the server.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/knstch/gophermart/cmd/config"
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/knstch/gophermart/internal/app/storage/psql"
+)
+
+func main() {
+	config.ParseConfig()
+
+	if flag.NArg() != 2 || flag.Arg(0) != "grant-admin" {
+		fmt.Fprintln(os.Stderr, "usage: gophermartctl [flags] grant-admin <login>")
+		os.Exit(2)
+	}
+	login := flag.Arg(1)
+
+	err := grantAdmin(login)
+	if errors.Is(err, psql.ErrNoRows) {
+		fmt.Fprintf(os.Stderr, "user %q not found\n", login)
+		os.Exit(1)
+	}
+	if err != nil {
+		logger.ErrorLogger("Can't grant admin role: ", err)
+		os.Exit(1)
+	}
+
+	logger.SecurityLogger("Role granted", "admin role granted to "+login+" from the command line")
+	fmt.Printf("admin role granted to %q, the user has to log in again\n", login)
+}
+
+// grantAdmin adds the admin role to a user in the configured database.
+func grantAdmin(login string) error {
+	db, err := sql.Open("pgx", config.ReadyConfig.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = psql.InitDB(db)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return psql.NewPsqlStorage(db).GrantRole(ctx, login, common.RoleAdmin)
+}
@@ -15,35 +15,56 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/user/balance": {
+        "/admin/api-keys": {
             "get": {
-                "description": "Retrieves the user's balance and withdrawn amount",
+                "description": "Retrieves API keys of a user or of all users. Requires the admin role",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Balance"
+                    "Admin"
+                ],
+                "summary": "Get API keys",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Owner login",
+                        "name": "login",
+                        "in": "query"
+                    }
                 ],
-                "summary": "Get user's balance",
                 "responses": {
                     "200": {
-                        "description": "User's balance",
+                        "description": "A list of API keys",
                         "schema": {
-                            "$ref": "#/definitions/handler.balanceInfo"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/common.APIKey"
+                            }
+                        }
+                    },
+                    "204": {
+                        "description": "There are no API keys",
+                        "schema": {
+                            "$ref": "#/definitions/handler.Message"
+                        }
+                    },
+                    "403": {
+                        "description": "You don't have access (forbidden)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     },
                     "500": {
-                        "description": "Internal Server Error",
+                        "description": "Internal Server Error (internal_error)",
                         "schema": {
-                            "$ref": "#/definitions/handler.ErrorMessage"
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     }
                 }
-            }
-        },
-        "/user/balance/withdraw": {
+            },
             "post": {
-                "description": "Allows users to spend their bonuses",
+                "description": "Creates an API key a partner calls the API with on behalf of a user. The key is returned only once. Requires the admin role",
                 "consumes": [
                     "application/json"
                 ],
@@ -51,206 +72,415 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Balance"
+                    "Admin"
                 ],
-                "summary": "Withdraw user's bonuses",
+                "summary": "Create API key",
                 "parameters": [
                     {
-                        "description": "Order number and withdraw amount",
-                        "name": "orderData",
+                        "description": "Owner, name, scopes and rate limit per minute",
+                        "name": "keyData",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/handler.getSpendBonusRequest"
+                            "$ref": "#/definitions/handler.createAPIKeyRequest"
                         }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "Bonuses successfully spent",
+                    "201": {
+                        "description": "Created API key",
                         "schema": {
-                            "$ref": "#/definitions/handler.Message"
+                            "$ref": "#/definitions/handler.createdAPIKey"
                         }
                     },
                     "400": {
-                        "description": "Wrong request",
+                        "description": "Wrong request or unknown scope (bad_request, unknown_scope)",
                         "schema": {
-                            "$ref": "#/definitions/handler.ErrorMessage"
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     },
-                    "402": {
-                        "description": "Not enough balance",
+                    "403": {
+                        "description": "You don't have access (forbidden)",
                         "schema": {
-                            "$ref": "#/definitions/handler.ErrorMessage"
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     },
-                    "409": {
-                        "description": "Order is already loaded",
+                    "404": {
+                        "description": "User not found or the account is deleted (user_not_found)",
                         "schema": {
-                            "$ref": "#/definitions/handler.ErrorMessage"
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     },
-                    "422": {
-                        "description": "Wrong order number",
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/api-keys/{id}": {
+            "delete": {
+                "description": "Revokes an API key. Requires the admin role",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Revoke API key",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "API key ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "API key revoked",
+                        "schema": {
+                            "$ref": "#/definitions/handler.Message"
+                        }
+                    },
+                    "400": {
+                        "description": "Wrong request (bad_request)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "403": {
+                        "description": "You don't have access (forbidden)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "404": {
+                        "description": "API key not found (api_key_not_found)",
                         "schema": {
-                            "$ref": "#/definitions/handler.ErrorMessage"
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     },
                     "500": {
-                        "description": "Internal Server Error",
+                        "description": "Internal Server Error (internal_error)",
                         "schema": {
-                            "$ref": "#/definitions/handler.ErrorMessage"
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     }
                 }
             }
         },
-        "/user/login": {
-            "post": {
-                "description": "API for user authentication and setting an auth cookie",
-                "consumes": [
+        "/admin/orders/{number}": {
+            "get": {
+                "description": "Retrieves an order of any user with the timeline of its status changes. Requires the admin role",
+                "produces": [
                     "application/json"
                 ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Get order",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order number",
+                        "name": "number",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The order with its timeline",
+                        "schema": {
+                            "$ref": "#/definitions/common.OrderDetails"
+                        }
+                    },
+                    "403": {
+                        "description": "You don't have access (forbidden)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "404": {
+                        "description": "Order not found (order_not_found)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/users/{login}": {
+            "get": {
+                "description": "Retrieves roles, balance and state of any user. Requires the admin role",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Auth"
+                    "Admin"
                 ],
-                "summary": "Auth",
+                "summary": "Get user",
                 "parameters": [
                     {
-                        "description": "Login and password",
-                        "name": "userData",
-                        "in": "body",
-                        "required": true,
+                        "type": "string",
+                        "description": "User login",
+                        "name": "login",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "User's account",
+                        "schema": {
+                            "$ref": "#/definitions/common.AccountInfo"
+                        }
+                    },
+                    "403": {
+                        "description": "You don't have access (forbidden)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "404": {
+                        "description": "User not found (user_not_found)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
                         "schema": {
-                            "$ref": "#/definitions/handler.credentials"
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     }
+                }
+            },
+            "delete": {
+                "description": "Anonymises any user's account and revokes all sessions and API keys. Requires the admin role",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Delete user account",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User login",
+                        "name": "login",
+                        "in": "path",
+                        "required": true
+                    }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Successfully signed in",
+                        "description": "Account deleted",
                         "schema": {
                             "$ref": "#/definitions/handler.Message"
                         }
                     },
-                    "400": {
-                        "description": "Wrong request",
+                    "403": {
+                        "description": "You don't have access (forbidden)",
                         "schema": {
-                            "$ref": "#/definitions/handler.ErrorMessage"
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     },
-                    "401": {
-                        "description": "Wrong email or password",
+                    "404": {
+                        "description": "User not found (user_not_found)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "409": {
+                        "description": "Account is already deleted (account_deleted)",
                         "schema": {
-                            "$ref": "#/definitions/handler.ErrorMessage"
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     },
                     "500": {
-                        "description": "Internal Server Error",
+                        "description": "Internal Server Error (internal_error)",
                         "schema": {
-                            "$ref": "#/definitions/handler.ErrorMessage"
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     }
                 }
             }
         },
-        "/user/orders": {
+        "/admin/users/{login}/export": {
             "get": {
-                "description": "Retrieves the orders associated with the user",
+                "description": "Returns a JSON archive of any user's account data. Requires the admin role",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Order"
+                    "Admin"
+                ],
+                "summary": "Export user account",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User login",
+                        "name": "login",
+                        "in": "path",
+                        "required": true
+                    }
                 ],
-                "summary": "Get user's orders",
                 "responses": {
                     "200": {
-                        "description": "A list of user's orders",
+                        "description": "Account data",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/common.Order"
-                            }
+                            "$ref": "#/definitions/common.AccountExport"
                         }
                     },
-                    "204": {
-                        "description": "A user has no orders",
+                    "403": {
+                        "description": "You don't have access (forbidden)",
                         "schema": {
-                            "$ref": "#/definitions/handler.Message"
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "404": {
+                        "description": "User not found (user_not_found)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     },
                     "500": {
-                        "description": "Internal server error",
+                        "description": "Internal Server Error (internal_error)",
                         "schema": {
-                            "$ref": "#/definitions/handler.ErrorMessage"
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     }
                 }
-            },
-            "post": {
-                "description": "Uploads an order to the server",
+            }
+        },
+        "/admin/users/{login}/roles": {
+            "put": {
+                "description": "Replaces roles of a user and revokes the user's sessions. Requires the admin role",
                 "consumes": [
-                    "text/plain"
+                    "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Order"
+                    "Admin"
                 ],
-                "summary": "Upload order",
+                "summary": "Set user's roles",
                 "parameters": [
                     {
-                        "description": "Order number",
-                        "name": "orderNum",
+                        "type": "string",
+                        "description": "User login",
+                        "name": "login",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "New roles",
+                        "name": "rolesData",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/handler.setRolesRequest"
                         }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Order was successfully loaded before",
+                        "description": "Roles successfully set",
                         "schema": {
                             "$ref": "#/definitions/handler.Message"
                         }
                     },
-                    "202": {
-                        "description": "Order was successfully accepted",
+                    "400": {
+                        "description": "Wrong request or unknown role (bad_request, unknown_role)",
                         "schema": {
-                            "$ref": "#/definitions/handler.Message"
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     },
-                    "409": {
-                        "description": "Order is already loaded",
+                    "403": {
+                        "description": "You don't have access (forbidden)",
                         "schema": {
-                            "$ref": "#/definitions/handler.ErrorMessage"
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     },
-                    "422": {
-                        "description": "Wrong order number",
+                    "404": {
+                        "description": "User not found (user_not_found)",
                         "schema": {
-                            "$ref": "#/definitions/handler.ErrorMessage"
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     },
                     "500": {
-                        "description": "Internal Server Error",
+                        "description": "Internal Server Error (internal_error)",
                         "schema": {
-                            "$ref": "#/definitions/handler.ErrorMessage"
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     }
                 }
             }
         },
-        "/user/register": {
-            "post": {
-                "description": "API for user registration and setting an auth cookie",
+        "/admin/users/{login}/state": {
+            "get": {
+                "description": "Retrieves changes of an account state from new to old ones. Requires the admin role",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Get account state history",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User login",
+                        "name": "login",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "State changes",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/common.AccountStateChange"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "You don't have access (forbidden)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "404": {
+                        "description": "User not found (user_not_found)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Puts an account in the active, frozen or suspended state. Frozen accounts can log in\nand view data but can't withdraw bonuses. Suspended accounts can't log in or call the API.\nEvery change is recorded in the account state history. Requires the admin role",
                 "consumes": [
                     "application/json"
                 ],
@@ -258,100 +488,2321 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Auth"
+                    "Admin"
                 ],
-                "summary": "SignUp",
+                "summary": "Set account state",
                 "parameters": [
                     {
-                        "description": "Login and password",
-                        "name": "userData",
+                        "type": "string",
+                        "description": "User login",
+                        "name": "login",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "New state and reason",
+                        "name": "stateData",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/handler.credentials"
+                            "$ref": "#/definitions/handler.setStateRequest"
                         }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Successfully registered",
+                        "description": "Recorded state change",
                         "schema": {
-                            "$ref": "#/definitions/handler.Message"
+                            "$ref": "#/definitions/common.AccountStateChange"
                         }
                     },
                     "400": {
-                        "description": "Wrong request",
+                        "description": "Wrong request, no reason or unknown state (bad_request, reason_required, unknown_state)",
                         "schema": {
-                            "$ref": "#/definitions/handler.ErrorMessage"
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     },
-                    "409": {
-                        "description": "Login is already taken",
+                    "403": {
+                        "description": "You don't have access (forbidden)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "404": {
+                        "description": "User not found (user_not_found)",
                         "schema": {
-                            "$ref": "#/definitions/handler.ErrorMessage"
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     },
                     "500": {
-                        "description": "Internal Server Error",
+                        "description": "Internal Server Error (internal_error)",
                         "schema": {
-                            "$ref": "#/definitions/handler.ErrorMessage"
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     }
                 }
             }
         },
-        "/user/withdrawals": {
-            "get": {
-                "description": "Retrieves the orders with bonuses spent by the user",
+        "/admin/withdrawals/{number}/reversal": {
+            "post": {
+                "description": "Gives the bonuses spent on an order of any user back to the user's balance at any time\nand marks the withdrawal as reversed. Reversing a reversed withdrawal returns it without changing the balance.\nRequires the admin role",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Order"
+                    "Admin"
+                ],
+                "summary": "Reverse a withdrawal",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order number",
+                        "name": "number",
+                        "in": "path",
+                        "required": true
+                    }
                 ],
-                "summary": "Get orders with spent bonuses",
                 "responses": {
                     "200": {
-                        "description": "A list of orders with spent bonuses",
+                        "description": "The reversed withdrawal",
                         "schema": {
                             "$ref": "#/definitions/common.OrdersWithSpentBonuses"
                         }
                     },
-                    "204": {
-                        "description": "You have not spent any bonuses",
+                    "403": {
+                        "description": "You don't have access (forbidden)",
                         "schema": {
-                            "$ref": "#/definitions/handler.Message"
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "404": {
+                        "description": "Withdrawal not found (withdrawal_not_found)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     },
                     "500": {
-                        "description": "Internal Server Error",
+                        "description": "Internal Server Error (internal_error)",
                         "schema": {
-                            "$ref": "#/definitions/handler.ErrorMessage"
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "common.Order": {
-            "type": "object",
-            "properties": {
-                "accrual": {
-                    "type": "number"
-                },
-                "bonusesWithdrawn": {
-                    "type": "number"
-                },
-                "number": {
-                    "type": "string"
-                },
-                "status": {
-                    "type": "string"
-                },
-                "uploaded_at": {
-                    "type": "string"
-                }
+        },
+        "/user": {
+            "delete": {
+                "description": "Anonymises the authenticated user's account and revokes all sessions and API keys. Orders, withdrawals and the balance are kept for accounting.\nIt requires the password and, if two-factor authentication is enabled, a code from an authenticator app or a recovery code.\nAccounts without a password, created through OpenID Connect, have to log in within 10 minutes before instead.\nWrong passwords and codes count as failed logins of the account",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Account"
+                ],
+                "summary": "Delete account",
+                "parameters": [
+                    {
+                        "description": "Current password and code",
+                        "name": "confirmation",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handler.deleteAccountRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Account deleted",
+                        "schema": {
+                            "$ref": "#/definitions/handler.Message"
+                        }
+                    },
+                    "400": {
+                        "description": "Wrong request (bad_request)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "401": {
+                        "description": "Wrong password or code (wrong_password, wrong_code)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "403": {
+                        "description": "Two-factor authentication is enabled and the code is missing, or an account without a password didn't log in recently (second_factor_required, reauthentication_required)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many attempts (too_many_attempts)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        },
+                        "headers": {
+                            "Retry-After": {
+                                "type": "integer",
+                                "description": "Seconds until the next attempt is allowed"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/2fa": {
+            "post": {
+                "description": "Generates a TOTP secret for the authenticated user. Two-factor authentication\nis enabled after a code from an authenticator app is confirmed at /user/2fa/confirm",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Two-factor authentication"
+                ],
+                "summary": "Start two-factor enrolment",
+                "responses": {
+                    "200": {
+                        "description": "TOTP secret and otpauth URI",
+                        "schema": {
+                            "$ref": "#/definitions/handler.twoFactorEnrolment"
+                        }
+                    },
+                    "401": {
+                        "description": "You are not authenticated (unauthenticated)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "409": {
+                        "description": "Two-factor authentication is already enabled (two_factor_enabled)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Disables two-factor authentication of the authenticated user. It requires the password\nand a code from an authenticator app or a recovery code. Wrong passwords and codes\ncount as failed logins of the account",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Two-factor authentication"
+                ],
+                "summary": "Disable two-factor authentication",
+                "parameters": [
+                    {
+                        "description": "Current password and code",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handler.disableTwoFactorRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Two-factor authentication disabled",
+                        "schema": {
+                            "$ref": "#/definitions/handler.Message"
+                        }
+                    },
+                    "400": {
+                        "description": "Wrong request (bad_request)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "401": {
+                        "description": "Wrong password or code (wrong_password, wrong_code)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "409": {
+                        "description": "Two-factor authentication is not enabled (two_factor_not_enabled)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many attempts (too_many_attempts)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        },
+                        "headers": {
+                            "Retry-After": {
+                                "type": "integer",
+                                "description": "Seconds until the next attempt is allowed"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/2fa/confirm": {
+            "post": {
+                "description": "Enables two-factor authentication if the code matches the secret of the started enrolment\nand returns recovery codes. The recovery codes are shown only once",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Two-factor authentication"
+                ],
+                "summary": "Confirm two-factor enrolment",
+                "parameters": [
+                    {
+                        "description": "Code from an authenticator app",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handler.twoFactorCodeRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Recovery codes",
+                        "schema": {
+                            "$ref": "#/definitions/handler.recoveryCodes"
+                        }
+                    },
+                    "400": {
+                        "description": "Wrong request, wrong code or the enrolment is not started (bad_request, invalid_code, two_factor_not_started)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "401": {
+                        "description": "You are not authenticated (unauthenticated)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "409": {
+                        "description": "Two-factor authentication is already enabled (two_factor_enabled)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/balance": {
+            "get": {
+                "description": "Retrieves the user's balance and withdrawn amount",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Balance"
+                ],
+                "summary": "Get user's balance",
+                "responses": {
+                    "200": {
+                        "description": "User's balance",
+                        "schema": {
+                            "$ref": "#/definitions/handler.balanceInfo"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/balance/withdraw": {
+            "post": {
+                "description": "Allows users to spend their bonuses",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Balance"
+                ],
+                "summary": "Withdraw user's bonuses",
+                "parameters": [
+                    {
+                        "description": "Order number and withdraw amount",
+                        "name": "orderData",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handler.getSpendBonusRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Key making a retry get the response to the first request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Bonuses successfully spent",
+                        "schema": {
+                            "$ref": "#/definitions/handler.Message"
+                        }
+                    },
+                    "400": {
+                        "description": "Wrong request (bad_request)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "402": {
+                        "description": "Not enough balance (not_enough_balance)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "403": {
+                        "description": "Account is frozen (account_frozen)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "409": {
+                        "description": "Order is already loaded or a request with the idempotency key is in progress (order_already_loaded, idempotency_key_in_progress)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "422": {
+                        "description": "Wrong order number or the idempotency key is used with a different request (order_invalid, idempotency_key_mismatch)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/events": {
+            "get": {
+                "description": "Opens a Server-Sent Events stream of the user's order status changes (order events)\nand balance updates (balance events). A comment is sent every 15 seconds to keep the stream open.\nA client reconnecting with the Last-Event-ID header gets the events it missed in the last 5 minutes.\nThe stream is closed when the client falls behind, the client has to reconnect",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "Order"
+                ],
+                "summary": "Stream order and balance events",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ID of the last event received",
+                        "name": "Last-Event-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Event stream",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/export": {
+            "get": {
+                "description": "Returns a JSON archive of the authenticated user's account, orders, withdrawals, balance history, sessions, API keys and linked identities",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Account"
+                ],
+                "summary": "Export account",
+                "responses": {
+                    "200": {
+                        "description": "Account data",
+                        "schema": {
+                            "$ref": "#/definitions/common.AccountExport"
+                        }
+                    },
+                    "401": {
+                        "description": "You are not authenticated (unauthenticated)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/login": {
+            "post": {
+                "description": "API for user authentication and setting an auth cookie.\nAccounts with two-factor authentication get a challenge to finish the login at /user/login/2fa instead.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Auth",
+                "parameters": [
+                    {
+                        "description": "Login and password",
+                        "name": "userData",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handler.Credentials"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successfully signed in",
+                        "schema": {
+                            "$ref": "#/definitions/handler.Message"
+                        }
+                    },
+                    "202": {
+                        "description": "A second factor is required",
+                        "schema": {
+                            "$ref": "#/definitions/handler.loginChallenge"
+                        }
+                    },
+                    "400": {
+                        "description": "Wrong request (bad_request)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "401": {
+                        "description": "Wrong email or password (wrong_credentials)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "403": {
+                        "description": "Account is suspended (account_suspended)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many login attempts (too_many_attempts)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        },
+                        "headers": {
+                            "Retry-After": {
+                                "type": "integer",
+                                "description": "Seconds until the next attempt is allowed"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/login/2fa": {
+            "post": {
+                "description": "Finishes a login of an account with two-factor authentication and sets an auth cookie.\nThe code is either a code from an authenticator app or one of the recovery codes.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Finish login with a second factor",
+                "parameters": [
+                    {
+                        "description": "Login challenge and code",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handler.secondFactorRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successfully signed in",
+                        "schema": {
+                            "$ref": "#/definitions/handler.Message"
+                        }
+                    },
+                    "400": {
+                        "description": "Wrong request (bad_request)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "401": {
+                        "description": "Challenge is invalid or expired, or wrong code (challenge_invalid, wrong_code)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "403": {
+                        "description": "Account is suspended (account_suspended)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many login attempts (too_many_attempts)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        },
+                        "headers": {
+                            "Retry-After": {
+                                "type": "integer",
+                                "description": "Seconds until the next attempt is allowed"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/oidc/callback": {
+            "get": {
+                "description": "Finishes a login with the OpenID Connect provider and sets an auth cookie.\nA user logged in already gets the identity linked to the account instead.\nAn unknown identity gets a new account if automatic creation is configured.\nAccounts with two-factor authentication get a challenge to finish the login at /user/login/2fa",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Identity provider callback",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Authorization code",
+                        "name": "code",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Login state",
+                        "name": "state",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successfully signed in or identity linked",
+                        "schema": {
+                            "$ref": "#/definitions/handler.Message"
+                        }
+                    },
+                    "202": {
+                        "description": "A second factor is required",
+                        "schema": {
+                            "$ref": "#/definitions/handler.loginChallenge"
+                        }
+                    },
+                    "400": {
+                        "description": "Login flow is missing, expired or doesn't match, or the login from the provider breaks the login policy (oidc_flow_invalid, validation_failed)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "401": {
+                        "description": "Identity provider denied the login (oidc_denied)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "403": {
+                        "description": "No account is linked to this identity or the account is suspended (identity_not_linked, account_suspended)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "409": {
+                        "description": "Login is already taken or the identity is linked to another account (login_taken, identity_linked)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "502": {
+                        "description": "Identity provider is unavailable (oidc_unavailable)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/oidc/login": {
+            "get": {
+                "description": "Redirects to the login page of the configured OpenID Connect provider.\nThe provider redirects back to /user/oidc/callback",
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Login with an identity provider",
+                "responses": {
+                    "302": {
+                        "description": "Redirect to the identity provider"
+                    },
+                    "502": {
+                        "description": "Identity provider is unavailable (oidc_unavailable)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/orders": {
+            "get": {
+                "description": "Retrieves a page of the orders associated with the user ordered by the upload time.\nIf there are more orders, the X-Next-Cursor header has the cursor of the next page.\nAll orders are returned if neither limit nor cursor is set",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Order"
+                ],
+                "summary": "Get user's orders",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Orders per page, from 1 to 100, 50 if only the cursor is set",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor of the page from the X-Next-Cursor header",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "enum": [
+                                "NEW",
+                                "PROCESSING",
+                                "INVALID",
+                                "PROCESSED"
+                            ],
+                            "type": "string"
+                        },
+                        "collectionFormat": "csv",
+                        "description": "Statuses of orders",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "Orders uploaded at or after the time, RFC 3339",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "Orders uploaded before the time, RFC 3339",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "asc",
+                            "desc"
+                        ],
+                        "type": "string",
+                        "default": "asc",
+                        "description": "Sort direction",
+                        "name": "sort",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "A list of user's orders",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/common.Order"
+                            }
+                        },
+                        "headers": {
+                            "X-Next-Cursor": {
+                                "type": "string",
+                                "description": "Cursor of the next page"
+                            }
+                        }
+                    },
+                    "204": {
+                        "description": "A user has no orders",
+                        "schema": {
+                            "$ref": "#/definitions/handler.Message"
+                        }
+                    },
+                    "400": {
+                        "description": "Validation failed (validation_failed)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Uploads an order to the server",
+                "consumes": [
+                    "text/plain"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Order"
+                ],
+                "summary": "Upload order",
+                "parameters": [
+                    {
+                        "description": "Order number",
+                        "name": "orderNum",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Key making a retry get the response to the first request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Order was successfully loaded before",
+                        "schema": {
+                            "$ref": "#/definitions/handler.Message"
+                        }
+                    },
+                    "202": {
+                        "description": "Order was successfully accepted",
+                        "schema": {
+                            "$ref": "#/definitions/handler.Message"
+                        }
+                    },
+                    "409": {
+                        "description": "Order is loaded by another user or a request with the idempotency key is in progress (order_owned_by_another_user, idempotency_key_in_progress)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "422": {
+                        "description": "Wrong order number or the idempotency key is used with a different request (order_invalid, idempotency_key_mismatch)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/orders/batch": {
+            "post": {
+                "description": "Uploads several orders at once from a JSON array of numbers, a text/csv body or a CSV file\nin the file field of a multipart form. CSV numbers are read from the first column, a header row is skipped.\nEvery number gets a result: accepted, already_yours, owned_by_another_user or invalid",
+                "consumes": [
+                    "application/json",
+                    "text/csv",
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Order"
+                ],
+                "summary": "Upload orders in a batch",
+                "parameters": [
+                    {
+                        "description": "Order numbers",
+                        "name": "orders",
+                        "in": "body",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    {
+                        "type": "file",
+                        "description": "CSV file with order numbers",
+                        "name": "file",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Key making a retry get the response to the first request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Results in the order of the numbers",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/common.OrderUploadResult"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Wrong request or too many numbers (bad_request, validation_failed)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "409": {
+                        "description": "A request with the idempotency key is in progress (idempotency_key_in_progress)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "413": {
+                        "description": "Request is too large (request_too_large)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported content type (unsupported_media_type)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "422": {
+                        "description": "The idempotency key is used with a different request (idempotency_key_mismatch)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/orders/export": {
+            "get": {
+                "description": "Streams all orders of the user matching the filters as a CSV or JSON Lines attachment.\nThe filters are the same as in the list of orders, limit and cursor are ignored",
+                "produces": [
+                    "text/csv",
+                    "application/x-ndjson"
+                ],
+                "tags": [
+                    "Order"
+                ],
+                "summary": "Export user's orders",
+                "parameters": [
+                    {
+                        "enum": [
+                            "csv",
+                            "ndjson"
+                        ],
+                        "type": "string",
+                        "default": "csv",
+                        "description": "Export format",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "enum": [
+                                "NEW",
+                                "PROCESSING",
+                                "INVALID",
+                                "PROCESSED"
+                            ],
+                            "type": "string"
+                        },
+                        "collectionFormat": "csv",
+                        "description": "Statuses of orders",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "Orders uploaded at or after the time, RFC 3339",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "Orders uploaded before the time, RFC 3339",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "asc",
+                            "desc"
+                        ],
+                        "type": "string",
+                        "default": "asc",
+                        "description": "Sort direction",
+                        "name": "sort",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Orders",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Validation failed (validation_failed)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/orders/{number}": {
+            "get": {
+                "description": "Retrieves an order of the user with the timeline of its status changes.\nChanges made by the accrual system have the accrual and the response of the accrual system",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Order"
+                ],
+                "summary": "Get user's order",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order number",
+                        "name": "number",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The order with its timeline",
+                        "schema": {
+                            "$ref": "#/definitions/common.OrderDetails"
+                        }
+                    },
+                    "404": {
+                        "description": "Order not found (order_not_found)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "422": {
+                        "description": "Wrong order number (order_invalid)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/password": {
+            "post": {
+                "description": "Changes the password of the authenticated user, revokes all sessions and sets a new auth cookie.\nWrong current passwords count as failed logins of the account",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Change password",
+                "parameters": [
+                    {
+                        "description": "Current and new passwords",
+                        "name": "passwords",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handler.changePasswordRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Password successfully changed",
+                        "schema": {
+                            "$ref": "#/definitions/handler.Message"
+                        }
+                    },
+                    "400": {
+                        "description": "Wrong request or a new password breaking the validation policy (bad_request, validation_failed)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "401": {
+                        "description": "Wrong current password (wrong_password)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many attempts (too_many_attempts)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        },
+                        "headers": {
+                            "Retry-After": {
+                                "type": "integer",
+                                "description": "Seconds until the next attempt is allowed"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/password/reset": {
+            "post": {
+                "description": "Sends a single-use password reset token to the user if the account exists",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Request password reset",
+                "parameters": [
+                    {
+                        "description": "Login",
+                        "name": "resetData",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handler.passwordResetRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Reset token is sent if the account exists",
+                        "schema": {
+                            "$ref": "#/definitions/handler.Message"
+                        }
+                    },
+                    "400": {
+                        "description": "Wrong request (bad_request)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/password/reset/confirm": {
+            "post": {
+                "description": "Sets a new password using a single-use reset token and revokes all sessions",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Confirm password reset",
+                "parameters": [
+                    {
+                        "description": "Reset token and new password",
+                        "name": "resetData",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handler.passwordResetConfirmRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Password successfully reset",
+                        "schema": {
+                            "$ref": "#/definitions/handler.Message"
+                        }
+                    },
+                    "400": {
+                        "description": "Wrong request or a new password breaking the validation policy (bad_request, validation_failed)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "401": {
+                        "description": "Reset token is invalid or expired (reset_token_invalid)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/profile": {
+            "patch": {
+                "description": "Changes the login of the authenticated user. Orders, balance and sessions are kept",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Update profile",
+                "parameters": [
+                    {
+                        "description": "New login",
+                        "name": "profile",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handler.updateProfileRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Profile successfully updated",
+                        "schema": {
+                            "$ref": "#/definitions/handler.Message"
+                        }
+                    },
+                    "400": {
+                        "description": "Wrong request or a login breaking the validation policy (bad_request, validation_failed)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "401": {
+                        "description": "You are not authenticated (unauthenticated)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "409": {
+                        "description": "Login is already taken (login_taken)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/register": {
+            "post": {
+                "description": "API for user registration and setting an auth cookie",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "SignUp",
+                "parameters": [
+                    {
+                        "description": "Login and password",
+                        "name": "userData",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handler.Credentials"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successfully registered",
+                        "schema": {
+                            "$ref": "#/definitions/handler.Message"
+                        }
+                    },
+                    "400": {
+                        "description": "Wrong request or credentials breaking the validation policy (bad_request, validation_failed)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "409": {
+                        "description": "Login is already taken (login_taken)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/sessions": {
+            "get": {
+                "description": "Retrieves active sessions of the authenticated user, the session of the request is marked as current",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Get sessions",
+                "responses": {
+                    "200": {
+                        "description": "A list of sessions",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/common.Session"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "You are not authenticated (unauthenticated)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/sessions/{id}": {
+            "delete": {
+                "description": "Ends a session of the authenticated user, other sessions stay active",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Revoke session",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Session ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Session revoked",
+                        "schema": {
+                            "$ref": "#/definitions/handler.Message"
+                        }
+                    },
+                    "401": {
+                        "description": "You are not authenticated (unauthenticated)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "404": {
+                        "description": "Session not found (session_not_found)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/statement": {
+            "get": {
+                "description": "Retrieves the changes of the user's balance ordered by time: accruals, withdrawals and reversals\nof withdrawals, each with the balance after it. The opening balance is the balance before the period\nand the closing balance is the balance at its end",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Balance"
+                ],
+                "summary": "Get user's statement",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "Changes made at or after the time, RFC 3339",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "Changes made before the time, RFC 3339",
+                        "name": "to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "User's statement",
+                        "schema": {
+                            "$ref": "#/definitions/common.Statement"
+                        }
+                    },
+                    "400": {
+                        "description": "Validation failed (validation_failed)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/statement/export": {
+            "get": {
+                "description": "Streams the changes of the user's balance made in the period as a CSV or JSON Lines attachment,\neach with the balance after it",
+                "produces": [
+                    "text/csv",
+                    "application/x-ndjson"
+                ],
+                "tags": [
+                    "Balance"
+                ],
+                "summary": "Export user's statement",
+                "parameters": [
+                    {
+                        "enum": [
+                            "csv",
+                            "ndjson"
+                        ],
+                        "type": "string",
+                        "default": "csv",
+                        "description": "Export format",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "Changes made at or after the time, RFC 3339",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "Changes made before the time, RFC 3339",
+                        "name": "to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Statement",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Validation failed (validation_failed)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/withdrawals": {
+            "get": {
+                "description": "Retrieves a page of the orders with bonuses spent by the user ordered by time.\nIf there are more withdrawals, the X-Next-Cursor header has the cursor of the next page.\nReversed withdrawals have the time of the reversal in reversed_at.\nThe X-Total-Sum header has the sum of all withdrawals matching the filters except reversed ones.\nAll withdrawals are returned if neither limit nor cursor is set",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Order"
+                ],
+                "summary": "Get orders with spent bonuses",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Withdrawals per page, from 1 to 100, 50 if only the cursor is set",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor of the page from the X-Next-Cursor header",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "Withdrawals made at or after the time, RFC 3339",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "Withdrawals made before the time, RFC 3339",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Smallest sum of a withdrawal",
+                        "name": "min_sum",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Largest sum of a withdrawal",
+                        "name": "max_sum",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "asc",
+                            "desc"
+                        ],
+                        "type": "string",
+                        "default": "asc",
+                        "description": "Sort direction",
+                        "name": "sort",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "A list of orders with spent bonuses",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/common.OrdersWithSpentBonuses"
+                            }
+                        },
+                        "headers": {
+                            "X-Next-Cursor": {
+                                "type": "string",
+                                "description": "Cursor of the next page"
+                            },
+                            "X-Total-Sum": {
+                                "type": "number",
+                                "description": "Sum of all withdrawals matching the filters except reversed ones"
+                            }
+                        }
+                    },
+                    "204": {
+                        "description": "You have not spent any bonuses",
+                        "schema": {
+                            "$ref": "#/definitions/handler.Message"
+                        }
+                    },
+                    "400": {
+                        "description": "Validation failed (validation_failed)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/withdrawals/export": {
+            "get": {
+                "description": "Streams all withdrawals of the user matching the filters as a CSV or JSON Lines attachment.\nThe filters are the same as in the list of withdrawals, limit and cursor are ignored",
+                "produces": [
+                    "text/csv",
+                    "application/x-ndjson"
+                ],
+                "tags": [
+                    "Order"
+                ],
+                "summary": "Export user's withdrawals",
+                "parameters": [
+                    {
+                        "enum": [
+                            "csv",
+                            "ndjson"
+                        ],
+                        "type": "string",
+                        "default": "csv",
+                        "description": "Export format",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "Withdrawals made at or after the time, RFC 3339",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "Withdrawals made before the time, RFC 3339",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Smallest sum of a withdrawal",
+                        "name": "min_sum",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Largest sum of a withdrawal",
+                        "name": "max_sum",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "asc",
+                            "desc"
+                        ],
+                        "type": "string",
+                        "default": "asc",
+                        "description": "Sort direction",
+                        "name": "sort",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Withdrawals",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Validation failed (validation_failed)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/withdrawals/{number}/reversal": {
+            "post": {
+                "description": "Gives the bonuses spent on an order back to the user's balance and marks the withdrawal as reversed.\nA withdrawal can be reversed within the time set by the -withdrawal-reversal-window flag after it was made.\nReversing a reversed withdrawal returns it without changing the balance",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Balance"
+                ],
+                "summary": "Reverse a withdrawal",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order number",
+                        "name": "number",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The reversed withdrawal",
+                        "schema": {
+                            "$ref": "#/definitions/common.OrdersWithSpentBonuses"
+                        }
+                    },
+                    "403": {
+                        "description": "Reversals are allowed only to admins or the withdrawal can't be reversed anymore (reversal_not_allowed, reversal_window_closed)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "404": {
+                        "description": "Withdrawal not found (withdrawal_not_found)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/user/balance/withdraw": {
+            "post": {
+                "description": "Allows users to spend their bonuses. Unlike v1, unknown fields are rejected,\nspaces around the order number are trimmed and the sum must be positive",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Balance"
+                ],
+                "summary": "Withdraw user's bonuses (v2)",
+                "parameters": [
+                    {
+                        "description": "Order number and withdraw amount",
+                        "name": "orderData",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handler.getSpendBonusRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Key making a retry get the response to the first request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Bonuses successfully spent",
+                        "schema": {
+                            "$ref": "#/definitions/handler.Message"
+                        }
+                    },
+                    "400": {
+                        "description": "Wrong request, no order number or a sum that isn't positive (bad_request, validation_failed)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "402": {
+                        "description": "Not enough balance (not_enough_balance)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "403": {
+                        "description": "Account is frozen (account_frozen)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "409": {
+                        "description": "Order is already loaded or a request with the idempotency key is in progress (order_already_loaded, idempotency_key_in_progress)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "415": {
+                        "description": "Content type is not application/json (unsupported_media_type)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "422": {
+                        "description": "Wrong order number or the idempotency key is used with a different request (order_invalid, idempotency_key_mismatch)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/user/orders": {
+            "post": {
+                "description": "Uploads an order to the server. Unlike v1, the number is sent in a JSON body\nand spaces around it are trimmed",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Order"
+                ],
+                "summary": "Upload order (v2)",
+                "parameters": [
+                    {
+                        "description": "Order number",
+                        "name": "order",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handler.uploadOrderRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Key making a retry get the response to the first request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Order was successfully loaded before",
+                        "schema": {
+                            "$ref": "#/definitions/handler.Message"
+                        }
+                    },
+                    "202": {
+                        "description": "Order was successfully accepted",
+                        "schema": {
+                            "$ref": "#/definitions/handler.Message"
+                        }
+                    },
+                    "400": {
+                        "description": "Wrong request or no order number (bad_request, validation_failed)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "409": {
+                        "description": "Order is loaded by another user or a request with the idempotency key is in progress (order_owned_by_another_user, idempotency_key_in_progress)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "415": {
+                        "description": "Content type is not application/json (unsupported_media_type)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "422": {
+                        "description": "Wrong order number or the idempotency key is used with a different request (order_invalid, idempotency_key_mismatch)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error (internal_error)",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "apierror.Error": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string",
+                    "enum": [
+                        "internal_error",
+                        "not_found",
+                        "method_not_allowed",
+                        "bad_request",
+                        "validation_failed",
+                        "request_too_large",
+                        "unsupported_media_type",
+                        "unauthenticated",
+                        "forbidden",
+                        "wrong_credentials",
+                        "wrong_password",
+                        "wrong_code",
+                        "invalid_code",
+                        "too_many_attempts",
+                        "challenge_invalid",
+                        "reset_token_invalid",
+                        "second_factor_required",
+                        "reauthentication_required",
+                        "csrf_token_invalid",
+                        "account_suspended",
+                        "account_frozen",
+                        "account_deleted",
+                        "login_taken",
+                        "user_not_found",
+                        "unknown_role",
+                        "unknown_state",
+                        "reason_required",
+                        "session_not_found",
+                        "two_factor_enabled",
+                        "two_factor_not_enabled",
+                        "two_factor_not_started",
+                        "oidc_flow_invalid",
+                        "oidc_denied",
+                        "oidc_unavailable",
+                        "identity_linked",
+                        "identity_not_linked",
+                        "api_key_invalid",
+                        "api_key_scope_missing",
+                        "api_key_rate_limited",
+                        "api_key_not_found",
+                        "unknown_scope",
+                        "order_invalid",
+                        "order_already_loaded",
+                        "order_owned_by_another_user",
+                        "order_not_found",
+                        "not_enough_balance",
+                        "withdrawal_not_found",
+                        "reversal_not_allowed",
+                        "reversal_window_closed",
+                        "idempotency_key_too_long",
+                        "idempotency_key_mismatch",
+                        "idempotency_key_in_progress"
+                    ],
+                    "example": "order_not_found"
+                },
+                "error": {
+                    "type": "string",
+                    "example": "Order not found"
+                },
+                "fields": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/validitycheck.FieldError"
+                    }
+                },
+                "request_id": {
+                    "type": "string",
+                    "example": "6f1c2a9e0b7d4c3a8e5f1b2d3c4a5e6f"
+                }
+            }
+        },
+        "common.APIKey": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "last_used_at": {
+                    "type": "string"
+                },
+                "login": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "prefix": {
+                    "type": "string"
+                },
+                "rate_limit": {
+                    "type": "integer"
+                },
+                "revoked_at": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "common.AccountExport": {
+            "type": "object",
+            "properties": {
+                "account": {
+                    "$ref": "#/definitions/common.AccountInfo"
+                },
+                "api_keys": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/common.APIKey"
+                    }
+                },
+                "balance_history": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/common.BalanceChange"
+                    }
+                },
+                "exported_at": {
+                    "type": "string"
+                },
+                "identities": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/common.OIDCIdentity"
+                    }
+                },
+                "orders": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/common.Order"
+                    }
+                },
+                "sessions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/common.Session"
+                    }
+                },
+                "withdrawals": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/common.OrdersWithSpentBonuses"
+                    }
+                }
+            }
+        },
+        "common.AccountInfo": {
+            "type": "object",
+            "properties": {
+                "current": {
+                    "type": "number"
+                },
+                "deleted_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "login": {
+                    "type": "string"
+                },
+                "roles": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "state": {
+                    "type": "string"
+                },
+                "state_changed_at": {
+                    "type": "string"
+                },
+                "state_reason": {
+                    "type": "string"
+                },
+                "two_factor": {
+                    "type": "boolean"
+                },
+                "withdrawn": {
+                    "type": "number"
+                }
+            }
+        },
+        "common.AccountStateChange": {
+            "type": "object",
+            "properties": {
+                "changed_at": {
+                    "type": "string"
+                },
+                "changed_by": {
+                    "type": "string"
+                },
+                "from": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "string"
+                }
+            }
+        },
+        "common.BalanceChange": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "number"
+                },
+                "balance": {
+                    "type": "number"
+                },
+                "order": {
+                    "type": "string"
+                },
+                "time": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "common.OIDCIdentity": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "issuer": {
+                    "type": "string"
+                },
+                "subject": {
+                    "type": "string"
+                }
+            }
+        },
+        "common.Order": {
+            "type": "object",
+            "properties": {
+                "accrual": {
+                    "type": "number"
+                },
+                "bonusesWithdrawn": {
+                    "type": "number"
+                },
+                "number": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "uploaded_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "common.OrderDetails": {
+            "type": "object",
+            "properties": {
+                "accrual": {
+                    "type": "number"
+                },
+                "number": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "timeline": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/common.OrderStatusChange"
+                    }
+                },
+                "uploaded_at": {
+                    "type": "string"
+                },
+                "withdrawn": {
+                    "type": "number"
+                }
+            }
+        },
+        "common.OrderStatusChange": {
+            "type": "object",
+            "properties": {
+                "accrual": {
+                    "type": "number"
+                },
+                "changed_at": {
+                    "type": "string"
+                },
+                "from": {
+                    "type": "string"
+                },
+                "payload": {
+                    "type": "object"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "common.OrderUploadResult": {
+            "type": "object",
+            "properties": {
+                "number": {
+                    "type": "string"
+                },
+                "result": {
+                    "type": "string",
+                    "enum": [
+                        "accepted",
+                        "already_yours",
+                        "owned_by_another_user",
+                        "invalid"
+                    ]
+                }
             }
         },
         "common.OrdersWithSpentBonuses": {
@@ -363,15 +2814,73 @@ const docTemplate = `{
                 "processed_at": {
                     "type": "string"
                 },
+                "reversed_at": {
+                    "type": "string"
+                },
                 "sum": {
                     "type": "number"
                 }
             }
         },
-        "handler.ErrorMessage": {
+        "common.Session": {
             "type": "object",
             "properties": {
-                "error": {
+                "client_ip": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "current": {
+                    "type": "boolean"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "last_used_at": {
+                    "type": "string"
+                },
+                "second_factor": {
+                    "type": "boolean"
+                },
+                "user_agent": {
+                    "type": "string"
+                }
+            }
+        },
+        "common.Statement": {
+            "type": "object",
+            "properties": {
+                "closing_balance": {
+                    "type": "number"
+                },
+                "from": {
+                    "type": "string"
+                },
+                "lines": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/common.BalanceChange"
+                    }
+                },
+                "opening_balance": {
+                    "type": "number"
+                },
+                "to": {
+                    "type": "string"
+                }
+            }
+        },
+        "handler.Credentials": {
+            "type": "object",
+            "properties": {
+                "login": {
+                    "type": "string"
+                },
+                "password": {
                     "type": "string"
                 }
             }
@@ -395,12 +2904,65 @@ const docTemplate = `{
                 }
             }
         },
-        "handler.credentials": {
+        "handler.changePasswordRequest": {
+            "type": "object",
+            "properties": {
+                "current_password": {
+                    "type": "string"
+                },
+                "new_password": {
+                    "type": "string"
+                }
+            }
+        },
+        "handler.createAPIKeyRequest": {
             "type": "object",
             "properties": {
                 "login": {
                     "type": "string"
                 },
+                "name": {
+                    "type": "string"
+                },
+                "rate_limit": {
+                    "type": "integer"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "handler.createdAPIKey": {
+            "type": "object",
+            "properties": {
+                "api_key": {
+                    "$ref": "#/definitions/common.APIKey"
+                },
+                "key": {
+                    "type": "string"
+                }
+            }
+        },
+        "handler.deleteAccountRequest": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
+                "password": {
+                    "type": "string"
+                }
+            }
+        },
+        "handler.disableTwoFactorRequest": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
                 "password": {
                     "type": "string"
                 }
@@ -416,6 +2978,128 @@ const docTemplate = `{
                     "type": "number"
                 }
             }
+        },
+        "handler.loginChallenge": {
+            "type": "object",
+            "properties": {
+                "challenge": {
+                    "type": "string"
+                },
+                "expires_in": {
+                    "type": "integer"
+                }
+            }
+        },
+        "handler.passwordResetConfirmRequest": {
+            "type": "object",
+            "properties": {
+                "new_password": {
+                    "type": "string"
+                },
+                "token": {
+                    "type": "string"
+                }
+            }
+        },
+        "handler.passwordResetRequest": {
+            "type": "object",
+            "properties": {
+                "login": {
+                    "type": "string"
+                }
+            }
+        },
+        "handler.recoveryCodes": {
+            "type": "object",
+            "properties": {
+                "recovery_codes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "handler.secondFactorRequest": {
+            "type": "object",
+            "properties": {
+                "challenge": {
+                    "type": "string"
+                },
+                "code": {
+                    "type": "string"
+                }
+            }
+        },
+        "handler.setRolesRequest": {
+            "type": "object",
+            "properties": {
+                "roles": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "handler.setStateRequest": {
+            "type": "object",
+            "properties": {
+                "reason": {
+                    "type": "string",
+                    "example": "Chargeback under investigation"
+                },
+                "state": {
+                    "type": "string",
+                    "example": "frozen"
+                }
+            }
+        },
+        "handler.twoFactorCodeRequest": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                }
+            }
+        },
+        "handler.twoFactorEnrolment": {
+            "type": "object",
+            "properties": {
+                "otpauth_uri": {
+                    "type": "string"
+                },
+                "secret": {
+                    "type": "string"
+                }
+            }
+        },
+        "handler.updateProfileRequest": {
+            "type": "object",
+            "properties": {
+                "login": {
+                    "type": "string"
+                }
+            }
+        },
+        "handler.uploadOrderRequest": {
+            "type": "object",
+            "properties": {
+                "order": {
+                    "type": "string"
+                }
+            }
+        },
+        "validitycheck.FieldError": {
+            "type": "object",
+            "properties": {
+                "field": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                }
+            }
         }
     },
     "securityDefinitions": {
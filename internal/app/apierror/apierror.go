@@ -0,0 +1,142 @@
+// Package apierror provides the catalogue of errors returned by the API. Every error has
+// a stable code clients can match, a message for people and the HTTP status code it is returned with.
+package apierror
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/knstch/gophermart/internal/app/logger"
+	requestid "github.com/knstch/gophermart/internal/app/middleware/requestID"
+	validitycheck "github.com/knstch/gophermart/internal/app/validityCheck"
+)
+
+// A struct describing an error of the catalogue. The code and the status code
+// of an error don't change, the message may.
+type Kind struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+// A struct returned to a client when a request fails. The message is kept in the error
+// field, as it was before codes were added. Fields list invalid fields of a request
+// failed validation, the request ID is the one from the X-Request-ID header.
+type Error struct {
+	Code      string                     `json:"code" example:"order_not_found" enums:"internal_error,not_found,method_not_allowed,bad_request,validation_failed,request_too_large,unsupported_media_type,unauthenticated,forbidden,wrong_credentials,wrong_password,wrong_code,invalid_code,too_many_attempts,challenge_invalid,reset_token_invalid,second_factor_required,reauthentication_required,csrf_token_invalid,account_suspended,account_frozen,account_deleted,login_taken,user_not_found,unknown_role,unknown_state,reason_required,session_not_found,two_factor_enabled,two_factor_not_enabled,two_factor_not_started,oidc_flow_invalid,oidc_denied,oidc_unavailable,identity_linked,identity_not_linked,api_key_invalid,api_key_scope_missing,api_key_rate_limited,api_key_not_found,unknown_scope,order_invalid,order_already_loaded,order_owned_by_another_user,order_not_found,not_enough_balance,withdrawal_not_found,reversal_not_allowed,reversal_window_closed,idempotency_key_too_long,idempotency_key_mismatch,idempotency_key_in_progress"`
+	Message   string                     `json:"error" example:"Order not found"`
+	Fields    []validitycheck.FieldError `json:"fields,omitempty"`
+	RequestID string                     `json:"request_id,omitempty" example:"6f1c2a9e0b7d4c3a8e5f1b2d3c4a5e6f"`
+}
+
+// General errors.
+var (
+	Internal             = Kind{http.StatusInternalServerError, "internal_error", "Internal Server Error"}
+	NotFound             = Kind{http.StatusNotFound, "not_found", "Not found"}
+	MethodNotAllowed     = Kind{http.StatusMethodNotAllowed, "method_not_allowed", "Method is not allowed"}
+	BadRequest           = Kind{http.StatusBadRequest, "bad_request", "Wrong request"}
+	ValidationFailed     = Kind{http.StatusBadRequest, "validation_failed", "Validation failed"}
+	RequestTooLarge      = Kind{http.StatusRequestEntityTooLarge, "request_too_large", "Request is too large"}
+	UnsupportedMediaType = Kind{http.StatusUnsupportedMediaType, "unsupported_media_type", "Content type is not supported"}
+)
+
+// Authentication and access errors.
+var (
+	Unauthenticated      = Kind{http.StatusUnauthorized, "unauthenticated", "You are not authenticated"}
+	Forbidden            = Kind{http.StatusForbidden, "forbidden", "You don't have access"}
+	WrongCredentials     = Kind{http.StatusUnauthorized, "wrong_credentials", "Wrong email or password"}
+	WrongPassword        = Kind{http.StatusUnauthorized, "wrong_password", "Wrong password"}
+	WrongCode            = Kind{http.StatusUnauthorized, "wrong_code", "Wrong code"}
+	InvalidCode          = Kind{http.StatusBadRequest, "invalid_code", "Wrong code"}
+	TooManyAttempts      = Kind{http.StatusTooManyRequests, "too_many_attempts", "Too many login attempts"}
+	ChallengeInvalid     = Kind{http.StatusUnauthorized, "challenge_invalid", "Challenge is invalid or expired"}
+	ResetTokenInvalid    = Kind{http.StatusUnauthorized, "reset_token_invalid", "Reset token is invalid or expired"}
+	SecondFactorRequired = Kind{http.StatusForbidden, "second_factor_required", "Two-factor authentication is required"}
+	ReauthRequired       = Kind{http.StatusForbidden, "reauthentication_required", "Log in again to confirm"}
+	CSRFTokenInvalid     = Kind{http.StatusForbidden, "csrf_token_invalid", "CSRF token is missing or wrong"}
+)
+
+// Account errors.
+var (
+	AccountSuspended    = Kind{http.StatusForbidden, "account_suspended", "Account is suspended"}
+	AccountFrozen       = Kind{http.StatusForbidden, "account_frozen", "Account is frozen"}
+	AccountDeleted      = Kind{http.StatusConflict, "account_deleted", "Account is already deleted"}
+	LoginTaken          = Kind{http.StatusConflict, "login_taken", "Login is already taken"}
+	UserNotFound        = Kind{http.StatusNotFound, "user_not_found", "User not found"}
+	UnknownRole         = Kind{http.StatusBadRequest, "unknown_role", "Unknown role"}
+	UnknownState        = Kind{http.StatusBadRequest, "unknown_state", "Unknown state"}
+	ReasonRequired      = Kind{http.StatusBadRequest, "reason_required", "Reason is required"}
+	SessionNotFound     = Kind{http.StatusNotFound, "session_not_found", "Session not found"}
+	TwoFactorEnabled    = Kind{http.StatusConflict, "two_factor_enabled", "Two-factor authentication is already enabled"}
+	TwoFactorNotEnabled = Kind{http.StatusConflict, "two_factor_not_enabled", "Two-factor authentication is not enabled"}
+	TwoFactorNotStarted = Kind{http.StatusBadRequest, "two_factor_not_started", "Two-factor enrolment is not started"}
+)
+
+// OpenID Connect errors.
+var (
+	OIDCFlowInvalid   = Kind{http.StatusBadRequest, "oidc_flow_invalid", "Login flow is missing or expired"}
+	OIDCDenied        = Kind{http.StatusUnauthorized, "oidc_denied", "Identity provider denied the login"}
+	OIDCUnavailable   = Kind{http.StatusBadGateway, "oidc_unavailable", "Identity provider is unavailable"}
+	IdentityLinked    = Kind{http.StatusConflict, "identity_linked", "Identity is linked to another account"}
+	IdentityNotLinked = Kind{http.StatusForbidden, "identity_not_linked", "No account is linked to this identity"}
+)
+
+// API key errors.
+var (
+	APIKeyInvalid      = Kind{http.StatusUnauthorized, "api_key_invalid", "Invalid API key"}
+	APIKeyScopeMissing = Kind{http.StatusForbidden, "api_key_scope_missing", "API key doesn't have the scope"}
+	APIKeyRateLimited  = Kind{http.StatusTooManyRequests, "api_key_rate_limited", "API key rate limit exceeded"}
+	APIKeyNotFound     = Kind{http.StatusNotFound, "api_key_not_found", "API key not found"}
+	UnknownScope       = Kind{http.StatusBadRequest, "unknown_scope", "Unknown scope"}
+)
+
+// Order and balance errors.
+var (
+	OrderInvalid            = Kind{http.StatusUnprocessableEntity, "order_invalid", "Wrong order number"}
+	OrderAlreadyLoaded      = Kind{http.StatusConflict, "order_already_loaded", "Order is already loaded"}
+	OrderOwnedByAnotherUser = Kind{http.StatusConflict, "order_owned_by_another_user", "Order is already loaded by another user"}
+	OrderNotFound           = Kind{http.StatusNotFound, "order_not_found", "Order not found"}
+	NotEnoughBalance        = Kind{http.StatusPaymentRequired, "not_enough_balance", "Not enough balance"}
+	WithdrawalNotFound      = Kind{http.StatusNotFound, "withdrawal_not_found", "Withdrawal not found"}
+	ReversalNotAllowed      = Kind{http.StatusForbidden, "reversal_not_allowed", "Withdrawals can be reversed only by an admin"}
+	ReversalWindowClosed    = Kind{http.StatusForbidden, "reversal_window_closed", "The withdrawal can't be reversed anymore"}
+)
+
+// Idempotency key errors.
+var (
+	IdempotencyKeyTooLong    = Kind{http.StatusBadRequest, "idempotency_key_too_long", "Idempotency key is too long"}
+	IdempotencyKeyMismatch   = Kind{http.StatusUnprocessableEntity, "idempotency_key_mismatch", "Idempotency key is used with a different request"}
+	IdempotencyKeyInProgress = Kind{http.StatusConflict, "idempotency_key_in_progress", "A request with this idempotency key is in progress"}
+)
+
+// WithMessage returns the error with another message, the code and the status code stay the same.
+func (k Kind) WithMessage(message string) Kind {
+	k.Message = message
+	return k
+}
+
+// Abort stops a request with the status code of the error and the error in the body.
+// Server errors are logged with the request ID, so they can be found by the ID a client got.
+func Abort(ctx *gin.Context, kind Kind) {
+	abort(ctx, kind, nil)
+}
+
+// AbortValidation stops a request with 400 status code and the list of invalid fields.
+func AbortValidation(ctx *gin.Context, fields []validitycheck.FieldError) {
+	abort(ctx, ValidationFailed, fields)
+}
+
+// abort stops a request with an error and a list of invalid fields.
+func abort(ctx *gin.Context, kind Kind, fields []validitycheck.FieldError) {
+	requestID := requestid.From(ctx)
+	if kind.Status >= http.StatusInternalServerError {
+		logger.ErrorLogger("Request "+requestID+" failed: ", fmt.Errorf("%s %s: %s", ctx.Request.Method, ctx.Request.URL.Path, kind.Code))
+	}
+	ctx.AbortWithStatusJSON(kind.Status, Error{
+		Code:      kind.Code,
+		Message:   kind.Message,
+		Fields:    fields,
+		RequestID: requestID,
+	})
+}
@@ -0,0 +1,95 @@
+package apierror
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	requestid "github.com/knstch/gophermart/internal/app/middleware/requestID"
+	validitycheck "github.com/knstch/gophermart/internal/app/validityCheck"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAbort(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(requestid.WithRequestID())
+	router.GET("/order", func(ctx *gin.Context) {
+		Abort(ctx, OrderNotFound)
+	})
+	router.GET("/scope", func(ctx *gin.Context) {
+		Abort(ctx, APIKeyScopeMissing.WithMessage("API key doesn't have the orders:read scope"))
+	})
+	router.GET("/validation", func(ctx *gin.Context) {
+		AbortValidation(ctx, []validitycheck.FieldError{{Field: "limit", Message: "must be a number from 1 to 100"}})
+	})
+
+	tests := []struct {
+		name       string
+		path       string
+		statusCode int
+		body       string
+	}{
+		{
+			name:       "#1 error of the catalogue",
+			path:       "/order",
+			statusCode: http.StatusNotFound,
+			body:       `{"code":"order_not_found","error":"Order not found","request_id":"req-1"}`,
+		},
+		{
+			name:       "#2 error with another message",
+			path:       "/scope",
+			statusCode: http.StatusForbidden,
+			body:       `{"code":"api_key_scope_missing","error":"API key doesn't have the orders:read scope","request_id":"req-1"}`,
+		},
+		{
+			name:       "#3 validation error",
+			path:       "/validation",
+			statusCode: http.StatusBadRequest,
+			body:       `{"code":"validation_failed","error":"Validation failed","fields":[{"field":"limit","message":"must be a number from 1 to 100"}],"request_id":"req-1"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set(requestid.Header, "req-1")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.statusCode, rr.Code)
+			assert.JSONEq(t, tt.body, rr.Body.String())
+		})
+	}
+}
+
+func TestCatalogue(t *testing.T) {
+	kinds := []Kind{
+		Internal, NotFound, MethodNotAllowed, BadRequest, ValidationFailed, RequestTooLarge, UnsupportedMediaType,
+		Unauthenticated, Forbidden, WrongCredentials, WrongPassword, WrongCode, InvalidCode, TooManyAttempts,
+		ChallengeInvalid, ResetTokenInvalid, SecondFactorRequired, ReauthRequired, CSRFTokenInvalid,
+		AccountSuspended, AccountFrozen, AccountDeleted, LoginTaken, UserNotFound, UnknownRole, UnknownState,
+		ReasonRequired, SessionNotFound, TwoFactorEnabled, TwoFactorNotEnabled, TwoFactorNotStarted,
+		OIDCFlowInvalid, OIDCDenied, OIDCUnavailable, IdentityLinked, IdentityNotLinked,
+		APIKeyInvalid, APIKeyScopeMissing, APIKeyRateLimited, APIKeyNotFound, UnknownScope,
+		OrderInvalid, OrderAlreadyLoaded, OrderOwnedByAnotherUser, OrderNotFound, NotEnoughBalance,
+		WithdrawalNotFound, ReversalNotAllowed, ReversalWindowClosed,
+		IdempotencyKeyTooLong, IdempotencyKeyMismatch, IdempotencyKeyInProgress,
+	}
+
+	field, _ := reflect.TypeOf(Error{}).FieldByName("Code")
+	documented := strings.Split(field.Tag.Get("enums"), ",")
+
+	codes := make(map[string]bool, len(kinds))
+	for _, kind := range kinds {
+		assert.False(t, codes[kind.Code], "code %s is used twice", kind.Code)
+		codes[kind.Code] = true
+		assert.Contains(t, documented, kind.Code, "code %s is not documented", kind.Code)
+		assert.GreaterOrEqual(t, kind.Status, http.StatusBadRequest)
+	}
+	assert.Len(t, documented, len(kinds))
+}
@@ -22,6 +22,9 @@ func GetStatusFromAccrual(order Order) OrderUpdateFromAccural {
 			logger.ErrorLogger("Got error trying to send a get request to accrual: ", err)
 			break
 		}
+		if resp.StatusCode() == 200 {
+			orderUpdate.Payload = resp.Body()
+		}
 		switch resp.StatusCode() {
 		case 429:
 			time.Sleep(3 * time.Second)
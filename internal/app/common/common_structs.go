@@ -1,8 +1,15 @@
 package common
 
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/knstch/gophermart/internal/app/pagination"
+)
+
 // A struct designed to insert data to order table
 type Order struct {
-	Login            string   `bun:"login" json:"-"`
+	UserID           int64    `bun:"user_id" json:"-"`
 	Order            string   `bun:"order" json:"number"`
 	Status           string   `bun:"status" json:"status"`
 	UploadedAt       string   `bun:"uploaded_at" json:"uploaded_at"`
@@ -10,16 +17,274 @@ type Order struct {
 	Accrual          *float32 `bun:"accrual" json:"accrual"`
 }
 
+// A status of an order waiting to be sent to the accrual system.
+const OrderNew = "NEW"
+
+// A status of an order being processed by the accrual system.
+const OrderProcessing = "PROCESSING"
+
+// A status of an order rejected by the accrual system.
+const OrderInvalid = "INVALID"
+
+// A status of an order with accrued bonuses.
+const OrderProcessed = "PROCESSED"
+
+// Statuses an order can have.
+var KnownOrderStatuses = []string{OrderNew, OrderProcessing, OrderInvalid, OrderProcessed}
+
+// A struct describing a page of orders requested by a user.
+// Orders are filtered by Statuses and by the upload time from From to To if they are set.
+type OrdersQuery struct {
+	pagination.Page
+	Statuses []string
+	From     *time.Time
+	To       *time.Time
+}
+
+// A struct describing a page of withdrawals requested by a user. Withdrawals are filtered
+// by the time from From to To and by the sum from MinSum to MaxSum if they are set.
+type WithdrawalsQuery struct {
+	pagination.Page
+	From   *time.Time
+	To     *time.Time
+	MinSum *float32
+	MaxSum *float32
+}
+
 // A struct designed to return data to a client about orders with withdrawn bonuses
 type OrdersWithSpentBonuses struct {
-	Order            string  `json:"order"`
-	Time             string  `json:"processed_at"`
-	BonusesWithdrawn float32 `json:"sum"`
+	Order            string     `json:"order"`
+	Time             string     `json:"processed_at"`
+	BonusesWithdrawn float32    `json:"sum"`
+	ReversedAt       *time.Time `json:"reversed_at,omitempty"`
 }
 
-// A struct designed to receive data from accrual system
+// A struct designed to receive data from accrual system.
+// Payload keeps the response body as it was received.
 type OrderUpdateFromAccural struct {
+	Order   string          `json:"order"`
+	Status  string          `json:"status"`
+	Accrual float32         `json:"accrual"`
+	Payload json.RawMessage `json:"-"`
+}
+
+// A struct describing a change of an order status. Changes made by the accrual system
+// keep the accrual and the response of the accrual system that caused them.
+type OrderStatusChange struct {
+	From      string          `json:"from,omitempty"`
+	Status    string          `json:"status"`
+	Accrual   *float32        `json:"accrual,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty" swaggertype:"object"`
+	ChangedAt time.Time       `json:"changed_at"`
+}
+
+// A result of a batch upload for an order number which was accepted.
+const UploadAccepted = "accepted"
+
+// A result of a batch upload for an order number the user has already uploaded.
+const UploadAlreadyYours = "already_yours"
+
+// A result of a batch upload for an order number uploaded by another user.
+const UploadOwnedByOther = "owned_by_another_user"
+
+// A result of a batch upload for an order number failing the Luhn check.
+const UploadInvalid = "invalid"
+
+// A struct describing what happened to an order number of a batch upload
+type OrderUploadResult struct {
+	Number string `json:"number"`
+	Result string `json:"result" enums:"accepted,already_yours,owned_by_another_user,invalid"`
+}
+
+// A struct designed to return an order with the timeline of its status changes
+type OrderDetails struct {
+	UserID           int64               `json:"-"`
+	Order            string              `json:"number"`
+	Status           string              `json:"status"`
+	UploadedAt       string              `json:"uploaded_at"`
+	Accrual          *float32            `json:"accrual,omitempty"`
+	BonusesWithdrawn *float32            `json:"withdrawn,omitempty"`
+	Timeline         []OrderStatusChange `json:"timeline"`
+}
+
+// A struct describing an account a session is issued for.
+// Accounts with TOTPEnabled are logged in with a second factor.
+type Account struct {
+	ID             int64
+	Login          string
+	SessionVersion int
+	Roles          []string
+	TOTPEnabled    bool
+	State          string
+}
+
+// A state of an account allowed to do everything.
+const AccountActive = "active"
+
+// A state of an account allowed to log in and view data but not to spend bonuses.
+const AccountFrozen = "frozen"
+
+// A state of an account refused to log in or call the API.
+const AccountSuspended = "suspended"
+
+// States an account can be put in.
+var KnownAccountStates = []string{AccountActive, AccountFrozen, AccountSuspended}
+
+// A struct describing a change of an account state made by support staff.
+type AccountStateChange struct {
+	From      string    `json:"from"`
+	State     string    `json:"state"`
+	Reason    string    `json:"reason"`
+	ChangedBy string    `json:"changed_by"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// A struct describing two-factor authentication settings of an account.
+// A secret of an account in the middle of enrolment is set but not enabled.
+type TwoFactor struct {
+	Secret  string
+	Enabled bool
+}
+
+// A role every registered user has.
+const RoleUser = "user"
+
+// A role of support staff allowed to use the admin API.
+const RoleAdmin = "admin"
+
+// Roles that can be granted to users.
+var KnownRoles = []string{RoleUser, RoleAdmin}
+
+// Contains reports if a list of roles or scopes contains the value.
+func Contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// A struct designed to return data about an account to support staff
+type AccountInfo struct {
+	ID             int64      `json:"id"`
+	Login          string     `json:"login"`
+	Roles          []string   `json:"roles"`
+	Balance        float32    `json:"current"`
+	Withdrawn      float32    `json:"withdrawn"`
+	TwoFactor      bool       `json:"two_factor"`
+	State          string     `json:"state"`
+	StateReason    string     `json:"state_reason,omitempty"`
+	StateChangedAt *time.Time `json:"state_changed_at,omitempty"`
+	DeletedAt      *time.Time `json:"deleted_at,omitempty"`
+}
+
+// A scope allowing an API key to upload orders.
+const ScopeOrdersSubmit = "orders:submit"
+
+// A scope allowing an API key to read orders.
+const ScopeOrdersRead = "orders:read"
+
+// A scope allowing an API key to read the balance.
+const ScopeBalanceRead = "balance:read"
+
+// A scope allowing an API key to spend bonuses.
+const ScopeBalanceWithdraw = "balance:withdraw"
+
+// A scope allowing an API key to read withdrawals.
+const ScopeWithdrawalsRead = "withdrawals:read"
+
+// Scopes that can be given to API keys.
+var KnownScopes = []string{ScopeOrdersSubmit, ScopeOrdersRead, ScopeBalanceRead, ScopeBalanceWithdraw, ScopeWithdrawalsRead}
+
+// A struct describing an API key a partner calls the API with on behalf of a user.
+// The key itself is never stored, only its hash and a prefix to recognize it.
+// AccountState is the state of the owner's account.
+type APIKey struct {
+	ID           int64      `json:"id"`
+	UserID       int64      `json:"-"`
+	Login        string     `json:"login"`
+	Name         string     `json:"name"`
+	Prefix       string     `json:"prefix"`
+	Scopes       []string   `json:"scopes"`
+	RateLimit    int        `json:"rate_limit"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+	AccountState string     `json:"-"`
+}
+
+// A struct describing a session a user is logged in with.
+// The ID of a session is the ID of its auth token, or the opaque auth token itself.
+// SecondFactor is set for sessions logged in with two-factor authentication.
+type Session struct {
+	ID             string     `json:"id"`
+	UserID         int64      `json:"-"`
+	SessionVersion int        `json:"-"`
+	UserAgent      string     `json:"user_agent"`
+	ClientIP       string     `json:"client_ip"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastUsedAt     time.Time  `json:"last_used_at"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	SecondFactor   bool       `json:"second_factor"`
+	Current        bool       `json:"current"`
+}
+
+// A type of a balance change made by accrued bonuses.
+const BalanceAccrual = "accrual"
+
+// A type of a balance change made by spent bonuses.
+const BalanceWithdrawal = "withdrawal"
+
+// A type of a balance change made by a reversed withdrawal.
+const BalanceReversal = "reversal"
+
+// A struct describing a single change of a balance and the balance after it
+type BalanceChange struct {
+	Time    string  `json:"time"`
 	Order   string  `json:"order"`
-	Status  string  `json:"status"`
-	Accrual float32 `json:"accrual"`
+	Type    string  `json:"type"`
+	Amount  float32 `json:"amount"`
+	Balance float32 `json:"balance"`
+}
+
+// A struct designed to return changes of a balance for a period with the balance
+// at the start and at the end of it. From and To are empty if the period is open.
+type Statement struct {
+	From           *time.Time      `json:"from,omitempty"`
+	To             *time.Time      `json:"to,omitempty"`
+	OpeningBalance float32         `json:"opening_balance"`
+	ClosingBalance float32         `json:"closing_balance"`
+	Lines          []BalanceChange `json:"lines"`
+}
+
+// A struct describing an external identity linked to an account.
+type OIDCIdentity struct {
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// A struct designed to return all data kept about an account
+type AccountExport struct {
+	ExportedAt     time.Time                `json:"exported_at"`
+	Account        AccountInfo              `json:"account"`
+	Orders         []Order                  `json:"orders"`
+	Withdrawals    []OrdersWithSpentBonuses `json:"withdrawals"`
+	BalanceHistory []BalanceChange          `json:"balance_history"`
+	Sessions       []Session                `json:"sessions"`
+	APIKeys        []APIKey                 `json:"api_keys"`
+	Identities     []OIDCIdentity           `json:"identities"`
+}
+
+// A struct describing a request made with an idempotency key and the response recorded for it.
+// StatusCode is 0 while the request is being handled.
+type IdempotencyKey struct {
+	UserID      int64
+	Key         string
+	Fingerprint string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	ExpiresAt   time.Time
 }
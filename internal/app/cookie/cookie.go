@@ -2,27 +2,77 @@
 package cookie
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/knstch/gophermart/cmd/config"
+	"github.com/knstch/gophermart/internal/app/common"
 	"github.com/knstch/gophermart/internal/app/logger"
 )
 
-// A claim struct containing jwt.RegisteredClaims and Login
+// A claim struct containing jwt.RegisteredClaims, a user ID,
+// a session version and roles of an account the token was issued for,
+// and if the session is logged in with a second factor.
+// The login is not included, as a user can change it.
 type Claims struct {
 	jwt.RegisteredClaims
-	Login string
+	UserID         int64    `json:"uid"`
+	SessionVersion int      `json:"ver"`
+	Roles          []string `json:"roles"`
+	SecondFactor   bool     `json:"mfa,omitempty"`
 }
 
+// A claim struct of a login challenge issued after the password step
+// of two-factor authentication.
+type ChallengeClaims struct {
+	jwt.RegisteredClaims
+	UserID int64 `json:"uid"`
+}
+
+// An audience of login challenges, so they can't be used as auth tokens.
+const challengeAudience = "2fa"
+
+// How long a login challenge is valid.
+const ChallengeTTL = 5 * time.Minute
+
+// A name of the auth cookie.
+const AuthCookie = "Auth"
+
+// A name of the cookie keeping a CSRF token readable by scripts of the frontend.
+const CSRFCookie = "CSRF-Token"
+
+// A header a CSRF token is sent back in with state-changing requests.
+const CSRFHeader = "X-CSRF-Token"
+
 // An error indication that a users is not authenticated.
 var ErrAuth = errors.New("you are not authenticated")
 
 // A function building a JWT token and retrning this token and error.
-func buildJWTString(login string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"login": login,
+// The token gets the session ID and expires after config.ReadyConfig.SessionTTL.
+func buildJWTString(account common.Account, session common.Session) (string, error) {
+	now := time.Now()
+
+	registeredClaims := jwt.RegisteredClaims{
+		ID:       session.ID,
+		IssuedAt: jwt.NewNumericDate(now),
+	}
+	if config.ReadyConfig.SessionTTL > 0 {
+		registeredClaims.ExpiresAt = jwt.NewNumericDate(now.Add(config.ReadyConfig.SessionTTL))
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		RegisteredClaims: registeredClaims,
+		UserID:           account.ID,
+		SessionVersion:   account.SessionVersion,
+		Roles:            account.Roles,
+		SecondFactor:     session.SecondFactor,
 	})
 
 	tokenString, err := token.SignedString([]byte(config.ReadyConfig.SecretKey))
@@ -34,57 +84,160 @@ func buildJWTString(login string) (string, error) {
 	return tokenString, nil
 }
 
-// A functing setting an auth JWT token in cookies. It accepts http.ResponseWriter and login and returns an error.
-func SetAuth(res http.ResponseWriter, login string) error {
-	jwt, err := buildJWTString(login)
-	if err != nil {
-		logger.ErrorLogger("Error making cookie: ", err)
-		return err
+// A functing setting an auth token and the CSRF token of the session in cookies.
+// It accepts http.ResponseWriter, the token and an ID of the session the token belongs to.
+func setAuth(res http.ResponseWriter, token string, sessionID string) {
+	http.SetCookie(res, newCookie(AuthCookie, token, config.ReadyConfig.CookieHTTPOnly))
+	http.SetCookie(res, newCookie(CSRFCookie, CSRFToken(sessionID), false))
+}
+
+// A function removing the auth and CSRF cookies. It accepts http.ResponseWriter.
+func ClearAuth(res http.ResponseWriter) {
+	for _, name := range []string{AuthCookie, CSRFCookie} {
+		cookie := newCookie(name, "", false)
+		cookie.MaxAge = -1
+		http.SetCookie(res, cookie)
+	}
+}
+
+// newCookie returns a cookie with the attributes set in config.ReadyConfig.
+// Max-Age follows config.ReadyConfig.CookieMaxAge, or the session TTL if it is zero.
+// A negative value makes a cookie removed when the browser is closed.
+func newCookie(name string, value string, httpOnly bool) *http.Cookie {
+	maxAge := config.ReadyConfig.CookieMaxAge
+	if maxAge == 0 {
+		maxAge = config.ReadyConfig.SessionTTL
 	}
 
-	cookie := http.Cookie{
-		Name:  "Auth",
-		Value: jwt,
-		Path:  "/",
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   config.ReadyConfig.CookieDomain,
+		HttpOnly: httpOnly,
+		Secure:   config.ReadyConfig.CookieSecure,
+		SameSite: sameSite(config.ReadyConfig.CookieSameSite),
 	}
-	http.SetCookie(res, &cookie)
+	if maxAge > 0 {
+		cookie.MaxAge = int(maxAge.Seconds())
+	}
+	return cookie
+}
+
+// sameSite converts a SameSite mode name to http.SameSite.
+// An empty or unknown name leaves the attribute out.
+func sameSite(mode string) http.SameSite {
+	switch strings.ToLower(mode) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteDefaultMode
+	}
+}
 
-	return nil
+// CSRFToken returns the CSRF token of a session. The token is an HMAC of the session ID,
+// so it can't be made up by another site and doesn't have to be stored.
+func CSRFToken(sessionID string) string {
+	return sign("csrf:" + sessionID)
 }
 
-// A function used to get a user's login using a JWT. It accepts a JWT and returns a login and error.
-func getLogin(tokenString string) (string, error) {
+// CheckCSRF reports if a request carries the CSRF token of the session in the X-CSRF-Token header.
+// A site making a browser send the auth cookie can't read the CSRF cookie to copy it to the header.
+func CheckCSRF(req *http.Request, sessionID string) bool {
+	token := req.Header.Get(CSRFHeader)
+	if token == "" {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(CSRFToken(sessionID)))
+}
+
+// A function used to get a user's claims using a JWT. It accepts a JWT and returns claims and error.
+// Malformed, expired and badly signed tokens, as well as tokens without a user ID
+// and login challenges, are rejected.
+func getClaims(tokenString string) (*Claims, error) {
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			logger.ErrorLogger("unexpected signing method", nil)
-			return nil, nil
-		}
-		return []byte(config.ReadyConfig.SecretKey), nil
+	token, err := jwt.ParseWithClaims(tokenString, claims, secretKey)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid || claims.UserID == 0 || len(claims.Audience) > 0 {
+		return nil, errors.New("token is not valid")
+	}
+	return claims, nil
+}
+
+// secretKey returns the key tokens signed by the server are checked with,
+// rejecting tokens signed with another method.
+func secretKey(t *jwt.Token) (interface{}, error) {
+	if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+	}
+	return []byte(config.ReadyConfig.SecretKey), nil
+}
+
+// A function building a login challenge for an account which has passed the password step.
+// It returns the signed challenge and error.
+func NewChallenge(account common.Account) (string, error) {
+	now := time.Now()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, ChallengeClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{challengeAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ChallengeTTL)),
+		},
+		UserID: account.ID,
 	})
+
+	challenge, err := token.SignedString([]byte(config.ReadyConfig.SecretKey))
 	if err != nil {
+		logger.ErrorLogger("Error signing challenge: ", err)
 		return "", err
 	}
-	if !token.Valid {
-		logger.ErrorLogger("Token is not valid", nil)
-		return "", err
+
+	return challenge, nil
+}
+
+// A function checking a login challenge. It returns the user ID the challenge
+// was issued for, or an error wrapping ErrAuth if the challenge is not valid or expired.
+func ParseChallenge(challenge string) (int64, error) {
+	claims := &ChallengeClaims{}
+	token, err := jwt.ParseWithClaims(challenge, claims, secretKey)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrAuth, err)
+	}
+	if !token.Valid || claims.UserID == 0 || !claims.VerifyAudience(challengeAudience, true) {
+		return 0, fmt.Errorf("%w: challenge is not valid", ErrAuth)
 	}
-	return claims.Login, nil
+	return claims.UserID, nil
 }
 
-// A function used to get a cookie and return a login and error.
-func GetCookie(req *http.Request) (string, error) {
-	signedLogin, err := req.Cookie("Auth")
+// A function used to get a cookie and return claims of the auth token and error.
+// If there is no cookie or the token is not valid, it returns an error wrapping ErrAuth.
+func GetCookie(req *http.Request) (*Claims, error) {
+	signedLogin, err := req.Cookie(AuthCookie)
 	if err != nil {
-		logger.ErrorLogger("Error getting cookie", err)
-		return "", ErrAuth
+		return nil, ErrAuth
 	}
 
-	login, err := getLogin(signedLogin.Value)
+	claims, err := getClaims(signedLogin.Value)
 	if err != nil {
 		logger.ErrorLogger("Error reading cookie", err)
-		return "", err
+		return nil, fmt.Errorf("%w: %v", ErrAuth, err)
 	}
 
-	return login, nil
+	return claims, nil
+}
+
+// NewSessionID returns a random hex encoded session ID used as an auth token ID.
+func NewSessionID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
 }
@@ -0,0 +1,96 @@
+package cookie
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/knstch/gophermart/cmd/config"
+	"github.com/knstch/gophermart/internal/app/logger"
+)
+
+// A name of the cookie keeping the state of an OpenID Connect login.
+const oidcFlowCookie = "OIDC-Flow"
+
+// A path the OpenID Connect flow cookie is sent to.
+const oidcFlowPath = "/api/user/oidc"
+
+// An audience of OpenID Connect flow tokens, so they can't be used as auth tokens or challenges.
+const oidcFlowAudience = "oidc"
+
+// How long a user has to finish a login at the identity provider.
+const OIDCFlowTTL = 10 * time.Minute
+
+// A claim struct keeping the state of an OpenID Connect login between the redirect
+// to the identity provider and the callback.
+type OIDCFlowClaims struct {
+	jwt.RegisteredClaims
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// A function setting a signed cookie with the state, the nonce and the PKCE code verifier
+// of an OpenID Connect login. The cookie is SameSite=Lax whatever the config is,
+// so the browser sends it with the redirect back from the identity provider.
+func SetOIDCFlow(res http.ResponseWriter, state string, nonce string, verifier string) error {
+	now := time.Now()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, OIDCFlowClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{oidcFlowAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(OIDCFlowTTL)),
+		},
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+	})
+
+	signed, err := token.SignedString([]byte(config.ReadyConfig.SecretKey))
+	if err != nil {
+		logger.ErrorLogger("Error signing OIDC flow: ", err)
+		return err
+	}
+
+	http.SetCookie(res, &http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    signed,
+		Path:     oidcFlowPath,
+		MaxAge:   int(OIDCFlowTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   config.ReadyConfig.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// A function returning the state of an OpenID Connect login from its cookie,
+// or an error wrapping ErrAuth if there is no cookie or it is not valid or expired.
+func GetOIDCFlow(req *http.Request) (*OIDCFlowClaims, error) {
+	flowCookie, err := req.Cookie(oidcFlowCookie)
+	if err != nil {
+		return nil, ErrAuth
+	}
+
+	claims := &OIDCFlowClaims{}
+	token, err := jwt.ParseWithClaims(flowCookie.Value, claims, secretKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAuth, err)
+	}
+	if !token.Valid || claims.State == "" || !claims.VerifyAudience(oidcFlowAudience, true) {
+		return nil, fmt.Errorf("%w: OIDC flow is not valid", ErrAuth)
+	}
+	return claims, nil
+}
+
+// A function removing the OpenID Connect flow cookie, so a login state is used once.
+func ClearOIDCFlow(res http.ResponseWriter) {
+	http.SetCookie(res, &http.Cookie{
+		Name:   oidcFlowCookie,
+		Value:  "",
+		Path:   oidcFlowPath,
+		MaxAge: -1,
+	})
+}
@@ -0,0 +1,188 @@
+package cookie
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/knstch/gophermart/cmd/config"
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/knstch/gophermart/internal/app/storage/psql"
+)
+
+// A session store keeping everything about a session in a signed JWT.
+const SessionStoreJWT = "jwt"
+
+// A session store keeping sessions in PostgreSQL behind opaque auth tokens.
+const SessionStorePostgres = "postgres"
+
+// How often the last use of a session is written to the database.
+const touchInterval = time.Minute
+
+// An error indicating that the auth token belongs to a revoked, expired or unknown session.
+var ErrRevoked = fmt.Errorf("%w: session is revoked", ErrAuth)
+
+// An interface of a storage keeping sessions.
+type SessionStore interface {
+	GetSession(ctx context.Context, id string) (common.Account, common.Session, error)
+	TouchSession(ctx context.Context, id string, usedAt time.Time, expiresAt *time.Time) error
+}
+
+// An interface hiding how a session is kept in the auth cookie,
+// so handlers and middlewares don't depend on the session store in use.
+type SessionManager interface {
+	// Start sets the auth and CSRF cookies of a new recorded session of the account.
+	Start(res http.ResponseWriter, account common.Account, session common.Session) error
+	// Authenticate returns the account and the active session the auth cookie of a request
+	// belongs to. The account roles are the ones the session is allowed to use.
+	// It returns an error wrapping ErrAuth if the caller is not authenticated
+	// and ErrRevoked if the session is revoked.
+	Authenticate(res http.ResponseWriter, req *http.Request) (common.Account, common.Session, error)
+}
+
+// A builder function returning a SessionManager of config.ReadyConfig.SessionStore.
+func NewSessionManager(store SessionStore) SessionManager {
+	if config.ReadyConfig.SessionStore == SessionStorePostgres {
+		return &opaqueSessions{store: store}
+	}
+	return &jwtSessions{store: store}
+}
+
+// A struct implementing SessionManager with JWTs carrying the user ID, the session version,
+// roles and the second factor. The session ID is the ID of the token, it is checked
+// against the store on every request.
+type jwtSessions struct {
+	store SessionStore
+}
+
+// Start sets a JWT of the session in the auth cookie.
+func (m *jwtSessions) Start(res http.ResponseWriter, account common.Account, session common.Session) error {
+	token, err := buildJWTString(account, session)
+	if err != nil {
+		logger.ErrorLogger("Error making cookie: ", err)
+		return err
+	}
+
+	setAuth(res, token, session.ID)
+	return nil
+}
+
+// Authenticate checks the JWT from the auth cookie and the session it belongs to.
+// Roles and the second factor are taken from the token.
+func (m *jwtSessions) Authenticate(res http.ResponseWriter, req *http.Request) (common.Account, common.Session, error) {
+	claims, err := GetCookie(req)
+	if err != nil {
+		return common.Account{}, common.Session{}, err
+	}
+
+	account, session, err := getSession(req.Context(), m.store, claims.ID)
+	if err != nil {
+		return common.Account{}, common.Session{}, err
+	}
+	if account.ID != claims.UserID || account.SessionVersion != claims.SessionVersion {
+		return common.Account{}, common.Session{}, fmt.Errorf("%w: token of user %d", ErrRevoked, claims.UserID)
+	}
+
+	if now := time.Now(); now.Sub(session.LastUsedAt) >= touchInterval {
+		touchSession(req.Context(), m.store, session.ID, now, nil)
+	}
+
+	account.Roles = claims.Roles
+	session.SecondFactor = claims.SecondFactor
+	return account, session, nil
+}
+
+// A struct implementing SessionManager with opaque auth tokens. A token carries only
+// the session ID signed with the secret key, everything else is read from the store,
+// so a change of roles or a revoked session takes effect on the next request.
+// Sessions expire after config.ReadyConfig.SessionTTL without use.
+type opaqueSessions struct {
+	store SessionStore
+}
+
+// Start sets an opaque token of the session in the auth cookie.
+func (m *opaqueSessions) Start(res http.ResponseWriter, account common.Account, session common.Session) error {
+	setAuth(res, opaqueToken(session.ID), session.ID)
+	return nil
+}
+
+// Authenticate finds the session of the opaque token from the auth cookie.
+// The expiration of the session and the cookies is moved forward when the session is used.
+func (m *opaqueSessions) Authenticate(res http.ResponseWriter, req *http.Request) (common.Account, common.Session, error) {
+	authCookie, err := req.Cookie(AuthCookie)
+	if err != nil {
+		return common.Account{}, common.Session{}, ErrAuth
+	}
+
+	id, ok := parseOpaqueToken(authCookie.Value)
+	if !ok {
+		return common.Account{}, common.Session{}, fmt.Errorf("%w: token is not valid", ErrAuth)
+	}
+
+	account, session, err := getSession(req.Context(), m.store, id)
+	if err != nil {
+		return common.Account{}, common.Session{}, err
+	}
+	if account.SessionVersion != session.SessionVersion {
+		return common.Account{}, common.Session{}, fmt.Errorf("%w: session of user %d", ErrRevoked, account.ID)
+	}
+
+	if now := time.Now(); now.Sub(session.LastUsedAt) >= touchInterval {
+		var expiresAt *time.Time
+		if config.ReadyConfig.SessionTTL > 0 {
+			expires := now.Add(config.ReadyConfig.SessionTTL)
+			expiresAt = &expires
+			session.ExpiresAt = expiresAt
+		}
+		touchSession(req.Context(), m.store, session.ID, now, expiresAt)
+		setAuth(res, authCookie.Value, session.ID)
+	}
+
+	return account, session, nil
+}
+
+// opaqueToken returns an opaque auth token of a session.
+func opaqueToken(sessionID string) string {
+	return sessionID + "." + sign("session:"+sessionID)
+}
+
+// parseOpaqueToken returns the session ID of an opaque auth token
+// and false if the token is not signed with the secret key.
+func parseOpaqueToken(token string) (string, bool) {
+	id, signature, ok := strings.Cut(token, ".")
+	if !ok || id == "" {
+		return "", false
+	}
+	return id, hmac.Equal([]byte(signature), []byte(sign("session:"+id)))
+}
+
+// sign returns a hex encoded HMAC of a message made with the secret key.
+func sign(message string) string {
+	mac := hmac.New(sha256.New, []byte(config.ReadyConfig.SecretKey))
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// getSession reads a session and its account from the store.
+// It returns ErrRevoked if there is no such active session.
+func getSession(ctx context.Context, store SessionStore, id string) (common.Account, common.Session, error) {
+	account, session, err := store.GetSession(ctx, id)
+	if errors.Is(err, psql.ErrNoRows) {
+		return common.Account{}, common.Session{}, fmt.Errorf("%w: unknown or revoked session", ErrRevoked)
+	}
+	return account, session, err
+}
+
+// touchSession records the last use of a session, failing to do so doesn't stop the request.
+func touchSession(ctx context.Context, store SessionStore, id string, usedAt time.Time, expiresAt *time.Time) {
+	if err := store.TouchSession(ctx, id, usedAt, expiresAt); err != nil {
+		logger.ErrorLogger("Can't record session usage: ", err)
+	}
+}
@@ -0,0 +1,178 @@
+// Package events delivers order status changes and balance updates to open streams of their users.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// A type of an event sent when an order status changes.
+const OrderStatus = "order"
+
+// A type of an event sent when a balance changes.
+const Balance = "balance"
+
+// How many recent events of a user are kept to be replayed after a reconnect.
+const historySize = 50
+
+// How long recent events are kept to be replayed after a reconnect.
+const retention = 5 * time.Minute
+
+// How many events a subscriber can fall behind before it is dropped.
+const bufferSize = 16
+
+// A struct describing an event. IDs grow with every event, so a client reconnecting
+// with the ID of the last event it got is sent the events it missed.
+type Event struct {
+	ID   int64
+	Type string
+	Data any
+	at   time.Time
+}
+
+// A struct describing the data of an order status event.
+type OrderStatusData struct {
+	Number    string    `json:"number"`
+	From      string    `json:"from,omitempty"`
+	Status    string    `json:"status"`
+	Accrual   float32   `json:"accrual"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// A struct describing the data of a balance event.
+type BalanceData struct {
+	Current   float32 `json:"current"`
+	Withdrawn float32 `json:"withdrawn"`
+}
+
+// A struct delivering events published for a user to the user's subscriptions.
+// Events live in memory, so a stream gets events of the instance it is connected to.
+type Broker struct {
+	mu        sync.Mutex
+	lastID    int64
+	users     map[int64]*userEvents
+	lastPrune time.Time
+}
+
+// A struct keeping recent events and subscriptions of a user.
+type userEvents struct {
+	recent []Event
+	subs   map[*Subscription]struct{}
+}
+
+// A struct describing an open stream of a user. Missed has the events published
+// after the ID passed to Subscribe. Events is closed when the subscriber falls behind
+// and has to reconnect.
+type Subscription struct {
+	Missed []Event
+	Events <-chan Event
+
+	events chan Event
+	userID int64
+	broker *Broker
+}
+
+// A builder function returning a Broker. Event IDs start from the current time
+// in microseconds, so they keep growing after a restart.
+func NewBroker() *Broker {
+	return &Broker{
+		lastID:    time.Now().UnixMicro(),
+		users:     make(map[int64]*userEvents),
+		lastPrune: time.Now(),
+	}
+}
+
+// Publish sends an event of the type with the data to subscriptions of a user
+// and keeps it to be replayed. It returns the event ID.
+func (b *Broker) Publish(userID int64, eventType string, data any) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.prune(now)
+
+	b.lastID++
+	event := Event{ID: b.lastID, Type: eventType, Data: data, at: now}
+
+	user := b.user(userID)
+	user.recent = append(user.recent, event)
+	if len(user.recent) > historySize {
+		user.recent = user.recent[len(user.recent)-historySize:]
+	}
+
+	for sub := range user.subs {
+		select {
+		case sub.events <- event:
+		default:
+			delete(user.subs, sub)
+			close(sub.events)
+		}
+	}
+	return event.ID
+}
+
+// Subscribe opens a subscription to events of a user. Recent events with IDs greater
+// than lastID are put to Missed, none are if lastID is 0. The subscription has to be closed.
+func (b *Broker) Subscribe(userID int64, lastID int64) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	events := make(chan Event, bufferSize)
+	sub := &Subscription{Events: events, events: events, userID: userID, broker: b}
+
+	user := b.user(userID)
+	if lastID > 0 {
+		for _, event := range user.recent {
+			if event.ID > lastID {
+				sub.Missed = append(sub.Missed, event)
+			}
+		}
+	}
+	user.subs[sub] = struct{}{}
+	return sub
+}
+
+// Close stops the subscription. It is safe to call it more than once.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+
+	user, ok := s.broker.users[s.userID]
+	if !ok {
+		return
+	}
+	if _, ok := user.subs[s]; ok {
+		delete(user.subs, s)
+		close(s.events)
+	}
+}
+
+// user returns events of a user, creating them if there are none.
+func (b *Broker) user(userID int64) *userEvents {
+	user, ok := b.users[userID]
+	if !ok {
+		user = &userEvents{subs: make(map[*Subscription]struct{})}
+		b.users[userID] = user
+	}
+	return user
+}
+
+// prune drops events older than retention and users with neither events nor subscriptions.
+// It runs at most once per minute.
+func (b *Broker) prune(now time.Time) {
+	if now.Sub(b.lastPrune) < time.Minute {
+		return
+	}
+	b.lastPrune = now
+
+	for userID, user := range b.users {
+		i := 0
+		for i < len(user.recent) && now.Sub(user.recent[i].at) > retention {
+			i++
+		}
+		user.recent = user.recent[i:]
+		if len(user.recent) == 0 && len(user.subs) == 0 {
+			delete(b.users, userID)
+		}
+	}
+}
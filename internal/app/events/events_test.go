@@ -0,0 +1,73 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublish(t *testing.T) {
+	broker := NewBroker()
+
+	sub := broker.Subscribe(1, 0)
+	defer sub.Close()
+	other := broker.Subscribe(2, 0)
+	defer other.Close()
+
+	id := broker.Publish(1, Balance, BalanceData{Current: 500})
+
+	event := <-sub.Events
+	assert.Equal(t, id, event.ID)
+	assert.Equal(t, Balance, event.Type)
+	assert.Equal(t, BalanceData{Current: 500}, event.Data)
+	assert.Empty(t, other.Events)
+}
+
+func TestReplay(t *testing.T) {
+	broker := NewBroker()
+
+	first := broker.Publish(1, OrderStatus, OrderStatusData{Number: "1", Status: "PROCESSING"})
+	second := broker.Publish(1, OrderStatus, OrderStatusData{Number: "1", Status: "PROCESSED"})
+	broker.Publish(2, Balance, BalanceData{Current: 10})
+
+	tests := []struct {
+		name   string
+		lastID int64
+		want   []int64
+	}{
+		{name: "#1 new stream", lastID: 0, want: nil},
+		{name: "#2 missed one event", lastID: first, want: []int64{second}},
+		{name: "#3 missed all events", lastID: first - 1, want: []int64{first, second}},
+		{name: "#4 up to date", lastID: second, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := broker.Subscribe(1, tt.lastID)
+			defer sub.Close()
+
+			var ids []int64
+			for _, event := range sub.Missed {
+				ids = append(ids, event.ID)
+			}
+			assert.Equal(t, tt.want, ids)
+		})
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	broker := NewBroker()
+
+	sub := broker.Subscribe(1, 0)
+	for i := 0; i <= bufferSize; i++ {
+		broker.Publish(1, Balance, BalanceData{Current: float32(i)})
+	}
+
+	received := 0
+	for range sub.Events {
+		received++
+	}
+	assert.Equal(t, bufferSize, received)
+
+	sub.Close()
+}
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/knstch/gophermart/internal/app/apierror"
+	"github.com/knstch/gophermart/internal/app/cookie"
+	"github.com/knstch/gophermart/internal/app/identity"
+	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/knstch/gophermart/internal/app/storage/psql"
+)
+
+// @Summary Export account
+// @Tags Account
+// @Description Returns a JSON archive of the authenticated user's account, orders, withdrawals, balance history, sessions, API keys and linked identities
+// @Produce json
+// @Success 200 {object} common.AccountExport "Account data"
+// @Failure 401 {object} apierror.Error "You are not authenticated (unauthenticated)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /user/export [get]
+func (h *Handler) ExportAccount(ctx *gin.Context) {
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+
+	h.writeExport(ctx, user.UserID)
+}
+
+// How recently an account without a password has to log in to delete the account.
+const recentLoginWindow = 10 * time.Minute
+
+// @Summary Delete account
+// @Tags Account
+// @Description Anonymises the authenticated user's account and revokes all sessions and API keys. Orders, withdrawals and the balance are kept for accounting.
+// @Description It requires the password and, if two-factor authentication is enabled, a code from an authenticator app or a recovery code.
+// @Description Accounts without a password, created through OpenID Connect, have to log in within 10 minutes before instead.
+// @Description Wrong passwords and codes count as failed logins of the account
+// @Accept json
+// @Produce json
+// @Param confirmation body deleteAccountRequest true "Current password and code"
+// @Success 200 {object} Message "Account deleted"
+// @Failure 400 {object} apierror.Error "Wrong request (bad_request)"
+// @Failure 401 {object} apierror.Error "Wrong password or code (wrong_password, wrong_code)"
+// @Failure 403 {object} apierror.Error "Two-factor authentication is enabled and the code is missing, or an account without a password didn't log in recently (second_factor_required, reauthentication_required)"
+// @Failure 429 {object} apierror.Error "Too many attempts (too_many_attempts)"
+// @Header 429 {integer} Retry-After "Seconds until the next attempt is allowed"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /user [delete]
+func (h *Handler) DeleteAccount(ctx *gin.Context) {
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+
+	var confirmation deleteAccountRequest
+
+	decoder := json.NewDecoder(ctx.Request.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&confirmation)
+	if err != nil {
+		apierror.Abort(ctx, apierror.BadRequest)
+		return
+	}
+
+	clientIP := ctx.ClientIP()
+
+	if retryAfter := h.guard.Check(user.Login, clientIP); retryAfter > 0 {
+		abortTooManyAttempts(ctx, retryAfter)
+		return
+	}
+
+	hasPassword, err := h.s.HasPassword(ctx, user.UserID)
+	if err != nil {
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+
+	switch {
+	case hasPassword && confirmation.Password == "":
+		apierror.Abort(ctx, apierror.BadRequest)
+		return
+	case hasPassword:
+		_, err = h.s.CheckCredentials(ctx, user.Login, confirmation.Password)
+		switch {
+		case errors.Is(err, psql.ErrWrongCredentials):
+			h.rejectLogin(ctx, user.Login, clientIP, apierror.WrongPassword)
+			return
+		case err != nil:
+			apierror.Abort(ctx, apierror.Internal)
+			return
+		}
+	case time.Since(user.LoggedInAt) > recentLoginWindow:
+		// An account created through OpenID Connect has no password to confirm,
+		// so it has to prove the session isn't stolen with a fresh login.
+		apierror.Abort(ctx, apierror.ReauthRequired.WithMessage("Log in with the identity provider again to delete the account"))
+		return
+	}
+
+	_, twoFactor, err := h.s.GetTwoFactor(ctx, user.UserID)
+	if err != nil {
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+	if twoFactor.Enabled {
+		if confirmation.Code == "" {
+			apierror.Abort(ctx, apierror.SecondFactorRequired.WithMessage("A code from an authenticator app or a recovery code is required"))
+			return
+		}
+		err = h.checkSecondFactor(ctx, user.UserID, twoFactor.Secret, confirmation.Code)
+		switch {
+		case errors.Is(err, psql.ErrInvalidCode):
+			logger.SecurityLogger("Wrong second factor", fmt.Sprintf("user %d (%s) entered a wrong code to delete the account from %s", user.UserID, user.Login, clientIP))
+			h.rejectLogin(ctx, user.Login, clientIP, apierror.WrongCode)
+			return
+		case err != nil:
+			apierror.Abort(ctx, apierror.Internal)
+			return
+		}
+	}
+	h.guard.Succeed(user.Login)
+
+	err = h.s.DeleteAccount(ctx, user.UserID)
+	if err != nil {
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+	logger.SecurityLogger("Account deleted", fmt.Sprintf("user %d (%s) deleted the account from %s", user.UserID, user.Login, clientIP))
+
+	cookie.ClearAuth(ctx.Writer)
+	ctx.JSON(http.StatusOK, newMessage("Account deleted"))
+}
+
+// @Summary Export user account
+// @Tags Admin
+// @Description Returns a JSON archive of any user's account data. Requires the admin role
+// @Produce json
+// @Param login path string true "User login"
+// @Success 200 {object} common.AccountExport "Account data"
+// @Failure 403 {object} apierror.Error "You don't have access (forbidden)"
+// @Failure 404 {object} apierror.Error "User not found (user_not_found)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /admin/users/{login}/export [get]
+func (h *Handler) AdminExportAccount(ctx *gin.Context) {
+	userID, ok := h.findUser(ctx)
+	if !ok {
+		return
+	}
+
+	admin, _ := identity.From(ctx)
+	logger.SecurityLogger("Account exported", fmt.Sprintf("%s exported the account of user %d", admin.Login, userID))
+
+	h.writeExport(ctx, userID)
+}
+
+// @Summary Delete user account
+// @Tags Admin
+// @Description Anonymises any user's account and revokes all sessions and API keys. Requires the admin role
+// @Produce json
+// @Param login path string true "User login"
+// @Success 200 {object} Message "Account deleted"
+// @Failure 403 {object} apierror.Error "You don't have access (forbidden)"
+// @Failure 404 {object} apierror.Error "User not found (user_not_found)"
+// @Failure 409 {object} apierror.Error "Account is already deleted (account_deleted)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /admin/users/{login} [delete]
+func (h *Handler) AdminDeleteAccount(ctx *gin.Context) {
+	userID, ok := h.findUser(ctx)
+	if !ok {
+		return
+	}
+
+	err := h.s.DeleteAccount(ctx, userID)
+	switch {
+	case errors.Is(err, psql.ErrNoRows):
+		apierror.Abort(ctx, apierror.AccountDeleted)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+	admin, _ := identity.From(ctx)
+	logger.SecurityLogger("Account deleted", fmt.Sprintf("%s deleted the account of user %d (%s)", admin.Login, userID, ctx.Param("login")))
+
+	ctx.JSON(http.StatusOK, newMessage("Account deleted"))
+}
+
+// findUser returns an ID of the user from the login path parameter.
+// If there is no such user, it stops the chain with 404 status code and returns false.
+func (h *Handler) findUser(ctx *gin.Context) (int64, bool) {
+	account, err := h.s.GetAccountInfo(ctx, ctx.Param("login"))
+	switch {
+	case errors.Is(err, psql.ErrNoRows):
+		apierror.Abort(ctx, apierror.UserNotFound)
+		return 0, false
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return 0, false
+	}
+	return account.ID, true
+}
+
+// writeExport responds with an export of the user's account as a JSON attachment.
+func (h *Handler) writeExport(ctx *gin.Context, userID int64) {
+	export, err := h.s.ExportAccount(ctx, userID)
+	switch {
+	case errors.Is(err, psql.ErrNoRows):
+		apierror.Abort(ctx, apierror.UserNotFound)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="gophermart-account-%d.json"`, userID))
+	ctx.JSON(http.StatusOK, export)
+}
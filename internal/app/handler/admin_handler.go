@@ -0,0 +1,348 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/knstch/gophermart/internal/app/apierror"
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/identity"
+	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/knstch/gophermart/internal/app/storage/psql"
+)
+
+// @Summary Get user
+// @Tags Admin
+// @Description Retrieves roles, balance and state of any user. Requires the admin role
+// @Produce json
+// @Param login path string true "User login"
+// @Success 200 {object} common.AccountInfo "User's account"
+// @Failure 403 {object} apierror.Error "You don't have access (forbidden)"
+// @Failure 404 {object} apierror.Error "User not found (user_not_found)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /admin/users/{login} [get]
+func (h *Handler) GetUser(ctx *gin.Context) {
+	account, err := h.s.GetAccountInfo(ctx, ctx.Param("login"))
+	switch {
+	case errors.Is(err, psql.ErrNoRows):
+		apierror.Abort(ctx, apierror.UserNotFound)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, account)
+}
+
+// @Summary Get order
+// @Tags Admin
+// @Description Retrieves an order of any user with the timeline of its status changes. Requires the admin role
+// @Produce json
+// @Param number path string true "Order number"
+// @Success 200 {object} common.OrderDetails "The order with its timeline"
+// @Failure 403 {object} apierror.Error "You don't have access (forbidden)"
+// @Failure 404 {object} apierror.Error "Order not found (order_not_found)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /admin/orders/{number} [get]
+func (h *Handler) AdminGetOrder(ctx *gin.Context) {
+	order, err := h.s.GetOrder(ctx, ctx.Param("number"))
+	switch {
+	case errors.Is(err, psql.ErrNoRows):
+		apierror.Abort(ctx, apierror.OrderNotFound)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, order)
+}
+
+// @Summary Reverse a withdrawal
+// @Tags Admin
+// @Description Gives the bonuses spent on an order of any user back to the user's balance at any time
+// @Description and marks the withdrawal as reversed. Reversing a reversed withdrawal returns it without changing the balance.
+// @Description Requires the admin role
+// @Produce json
+// @Param number path string true "Order number"
+// @Success 200 {object} common.OrdersWithSpentBonuses "The reversed withdrawal"
+// @Failure 403 {object} apierror.Error "You don't have access (forbidden)"
+// @Failure 404 {object} apierror.Error "Withdrawal not found (withdrawal_not_found)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /admin/withdrawals/{number}/reversal [post]
+func (h *Handler) AdminReverseWithdrawal(ctx *gin.Context) {
+	admin, _ := identity.From(ctx)
+
+	number := ctx.Param("number")
+	withdrawal, reversed, err := h.s.ReverseWithdrawal(ctx, number, 0, admin.UserID, 0)
+	switch {
+	case errors.Is(err, psql.ErrNoRows):
+		apierror.Abort(ctx, apierror.WithdrawalNotFound)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+
+	if reversed {
+		logger.SecurityLogger("Withdrawal reversed", fmt.Sprintf("%s reversed the withdrawal of %v bonuses for order %s",
+			admin.Login, withdrawal.BonusesWithdrawn, number))
+	}
+
+	ctx.JSON(http.StatusOK, withdrawal)
+}
+
+// @Summary Set user's roles
+// @Tags Admin
+// @Description Replaces roles of a user and revokes the user's sessions. Requires the admin role
+// @Accept json
+// @Produce json
+// @Param login path string true "User login"
+// @Param rolesData body setRolesRequest true "New roles"
+// @Success 200 {object} Message "Roles successfully set"
+// @Failure 400 {object} apierror.Error "Wrong request or unknown role (bad_request, unknown_role)"
+// @Failure 403 {object} apierror.Error "You don't have access (forbidden)"
+// @Failure 404 {object} apierror.Error "User not found (user_not_found)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /admin/users/{login}/roles [put]
+func (h *Handler) SetRoles(ctx *gin.Context) {
+	var rolesRequest setRolesRequest
+
+	decoder := json.NewDecoder(ctx.Request.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&rolesRequest)
+	if err != nil || len(rolesRequest.Roles) == 0 {
+		apierror.Abort(ctx, apierror.BadRequest)
+		return
+	}
+
+	login := ctx.Param("login")
+
+	err = h.s.SetRoles(ctx, login, rolesRequest.Roles)
+	switch {
+	case errors.Is(err, psql.ErrUnknownRole):
+		apierror.Abort(ctx, apierror.UnknownRole)
+		return
+	case errors.Is(err, psql.ErrNoRows):
+		apierror.Abort(ctx, apierror.UserNotFound)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+	admin, _ := identity.From(ctx)
+	logger.SecurityLogger("Roles changed", admin.Login+" set roles of "+login+" to "+strings.Join(rolesRequest.Roles, ","))
+
+	ctx.JSON(http.StatusOK, newMessage("Roles successfully set"))
+}
+
+// @Summary Set account state
+// @Tags Admin
+// @Description Puts an account in the active, frozen or suspended state. Frozen accounts can log in
+// @Description and view data but can't withdraw bonuses. Suspended accounts can't log in or call the API.
+// @Description Every change is recorded in the account state history. Requires the admin role
+// @Accept json
+// @Produce json
+// @Param login path string true "User login"
+// @Param stateData body setStateRequest true "New state and reason"
+// @Success 200 {object} common.AccountStateChange "Recorded state change"
+// @Failure 400 {object} apierror.Error "Wrong request, no reason or unknown state (bad_request, reason_required, unknown_state)"
+// @Failure 403 {object} apierror.Error "You don't have access (forbidden)"
+// @Failure 404 {object} apierror.Error "User not found (user_not_found)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /admin/users/{login}/state [put]
+func (h *Handler) SetAccountState(ctx *gin.Context) {
+	var stateRequest setStateRequest
+
+	decoder := json.NewDecoder(ctx.Request.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&stateRequest)
+	if err != nil || stateRequest.State == "" {
+		apierror.Abort(ctx, apierror.BadRequest)
+		return
+	}
+	stateRequest.Reason = strings.TrimSpace(stateRequest.Reason)
+	if stateRequest.State != common.AccountActive && stateRequest.Reason == "" {
+		apierror.Abort(ctx, apierror.ReasonRequired)
+		return
+	}
+
+	login := ctx.Param("login")
+	admin, _ := identity.From(ctx)
+
+	change, err := h.s.SetAccountState(ctx, login, stateRequest.State, stateRequest.Reason, admin.UserID)
+	switch {
+	case errors.Is(err, psql.ErrUnknownState):
+		apierror.Abort(ctx, apierror.UnknownState)
+		return
+	case errors.Is(err, psql.ErrNoRows):
+		apierror.Abort(ctx, apierror.UserNotFound)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+	change.ChangedBy = admin.Login
+	logger.SecurityLogger("Account state changed", fmt.Sprintf("%s changed the state of %s from %s to %s: %s",
+		admin.Login, login, change.From, change.State, change.Reason))
+
+	ctx.JSON(http.StatusOK, change)
+}
+
+// @Summary Get account state history
+// @Tags Admin
+// @Description Retrieves changes of an account state from new to old ones. Requires the admin role
+// @Produce json
+// @Param login path string true "User login"
+// @Success 200 {array} common.AccountStateChange "State changes"
+// @Failure 403 {object} apierror.Error "You don't have access (forbidden)"
+// @Failure 404 {object} apierror.Error "User not found (user_not_found)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /admin/users/{login}/state [get]
+func (h *Handler) GetAccountStateChanges(ctx *gin.Context) {
+	userID, ok := h.findUser(ctx)
+	if !ok {
+		return
+	}
+
+	changes, err := h.s.GetAccountStateChanges(ctx, userID)
+	if err != nil {
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, changes)
+}
+
+// @Summary Create API key
+// @Tags Admin
+// @Description Creates an API key a partner calls the API with on behalf of a user. The key is returned only once. Requires the admin role
+// @Accept json
+// @Produce json
+// @Param keyData body createAPIKeyRequest true "Owner, name, scopes and rate limit per minute"
+// @Success 201 {object} createdAPIKey "Created API key"
+// @Failure 400 {object} apierror.Error "Wrong request or unknown scope (bad_request, unknown_scope)"
+// @Failure 403 {object} apierror.Error "You don't have access (forbidden)"
+// @Failure 404 {object} apierror.Error "User not found or the account is deleted (user_not_found)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /admin/api-keys [post]
+func (h *Handler) CreateAPIKey(ctx *gin.Context) {
+	var keyRequest createAPIKeyRequest
+
+	decoder := json.NewDecoder(ctx.Request.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&keyRequest)
+	if err != nil || keyRequest.Login == "" || keyRequest.Name == "" || len(keyRequest.Scopes) == 0 || keyRequest.RateLimit < 0 {
+		apierror.Abort(ctx, apierror.BadRequest)
+		return
+	}
+
+	key, err := newAPIKey()
+	if err != nil {
+		logger.ErrorLogger("Can't generate API key: ", err)
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+
+	apiKey, err := h.s.CreateAPIKey(ctx, common.APIKey{
+		Login:     keyRequest.Login,
+		Name:      keyRequest.Name,
+		Prefix:    key[:apiKeyPrefixLength],
+		Scopes:    keyRequest.Scopes,
+		RateLimit: keyRequest.RateLimit,
+	}, key)
+	switch {
+	case errors.Is(err, psql.ErrUnknownScope):
+		apierror.Abort(ctx, apierror.UnknownScope)
+		return
+	case errors.Is(err, psql.ErrNoRows):
+		apierror.Abort(ctx, apierror.UserNotFound)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+	admin, _ := identity.From(ctx)
+	logger.SecurityLogger("API key created", fmt.Sprintf("%s created API key %d (%s) for %s with scopes %s",
+		admin.Login, apiKey.ID, apiKey.Prefix, apiKey.Login, strings.Join(apiKey.Scopes, ",")))
+
+	ctx.JSON(http.StatusCreated, createdAPIKey{
+		Key:    key,
+		APIKey: apiKey,
+	})
+}
+
+// @Summary Get API keys
+// @Tags Admin
+// @Description Retrieves API keys of a user or of all users. Requires the admin role
+// @Produce json
+// @Param login query string false "Owner login"
+// @Success 200 {array} common.APIKey "A list of API keys"
+// @Failure 204 {object} Message "There are no API keys"
+// @Failure 403 {object} apierror.Error "You don't have access (forbidden)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /admin/api-keys [get]
+func (h *Handler) GetAPIKeys(ctx *gin.Context) {
+	apiKeys, err := h.s.GetAPIKeys(ctx, ctx.Query("login"))
+	if err != nil {
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+
+	if len(apiKeys) == 0 {
+		ctx.AbortWithStatusJSON(http.StatusNoContent, newMessage("There are no API keys"))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, apiKeys)
+}
+
+// @Summary Revoke API key
+// @Tags Admin
+// @Description Revokes an API key. Requires the admin role
+// @Produce json
+// @Param id path int true "API key ID"
+// @Success 200 {object} Message "API key revoked"
+// @Failure 400 {object} apierror.Error "Wrong request (bad_request)"
+// @Failure 403 {object} apierror.Error "You don't have access (forbidden)"
+// @Failure 404 {object} apierror.Error "API key not found (api_key_not_found)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /admin/api-keys/{id} [delete]
+func (h *Handler) RevokeAPIKey(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		apierror.Abort(ctx, apierror.BadRequest)
+		return
+	}
+
+	err = h.s.RevokeAPIKey(ctx, id)
+	switch {
+	case errors.Is(err, psql.ErrNoRows):
+		apierror.Abort(ctx, apierror.APIKeyNotFound)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+	admin, _ := identity.From(ctx)
+	logger.SecurityLogger("API key revoked", fmt.Sprintf("%s revoked API key %d", admin.Login, id))
+
+	ctx.JSON(http.StatusOK, newMessage("API key revoked"))
+}
+
+// newAPIKey returns a random API key.
+func newAPIKey() (string, error) {
+	key := make([]byte, 24)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + hex.EncodeToString(key), nil
+}
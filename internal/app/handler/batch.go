@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The largest body of a batch upload.
+const maxBatchBodySize = 1 << 20
+
+// An error indicating that a batch upload is sent with an unsupported content type.
+var errUnsupportedBatch = errors.New("unsupported content type")
+
+// readOrderNumbers reads order numbers of a batch upload from a JSON array of strings,
+// a text/csv body or a CSV file in the file field of a multipart form. It returns
+// errUnsupportedBatch for other content types.
+func readOrderNumbers(ctx *gin.Context) ([]string, error) {
+	switch ctx.ContentType() {
+	case gin.MIMEJSON:
+		var numbers []string
+		decoder := json.NewDecoder(ctx.Request.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&numbers); err != nil {
+			return nil, err
+		}
+		for i := range numbers {
+			numbers[i] = strings.TrimSpace(numbers[i])
+		}
+		return numbers, nil
+	case "text/csv":
+		return readCSVNumbers(ctx.Request.Body)
+	case gin.MIMEMultipartPOSTForm:
+		header, err := ctx.FormFile("file")
+		if err != nil {
+			return nil, err
+		}
+		file, err := header.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return readCSVNumbers(file)
+	default:
+		return nil, errUnsupportedBatch
+	}
+}
+
+// readCSVNumbers reads order numbers from the first column of a CSV file.
+// Empty rows are skipped, as is the first row if it is a header rather than a number.
+func readCSVNumbers(r io.Reader) ([]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var numbers []string
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return numbers, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		number := strings.TrimSpace(record[0])
+		if number == "" || first && !isDigits(number) {
+			continue
+		}
+		numbers = append(numbers, number)
+	}
+}
+
+// isDigits reports whether s consists of decimal digits only.
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/knstch/gophermart/internal/app/events"
+	"github.com/knstch/gophermart/internal/app/logger"
+)
+
+// How often a comment is sent to an idle event stream to keep the connection open.
+const eventsHeartbeat = 15 * time.Second
+
+// How long a browser waits before reconnecting to a closed event stream, in milliseconds.
+const eventsRetry = 3000
+
+// @Summary Stream order and balance events
+// @Tags Order
+// @Description Opens a Server-Sent Events stream of the user's order status changes (order events)
+// @Description and balance updates (balance events). A comment is sent every 15 seconds to keep the stream open.
+// @Description A client reconnecting with the Last-Event-ID header gets the events it missed in the last 5 minutes.
+// @Description The stream is closed when the client falls behind, the client has to reconnect
+// @Produce text/event-stream
+// @Param Last-Event-ID header string false "ID of the last event received"
+// @Success 200 {string} string "Event stream"
+// @Router /user/events [get]
+func (h *Handler) Events(ctx *gin.Context) {
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+
+	lastID, _ := strconv.ParseInt(ctx.GetHeader("Last-Event-ID"), 10, 64)
+
+	sub := h.s.Events().Subscribe(user.UserID, lastID)
+	defer sub.Close()
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Status(http.StatusOK)
+
+	fmt.Fprintf(ctx.Writer, "retry: %d\n\n", eventsRetry)
+	for _, event := range sub.Missed {
+		if !writeEvent(ctx, event) {
+			return
+		}
+	}
+	ctx.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Request.Context().Done():
+			return
+		case event, ok := <-sub.Events:
+			if !ok || !writeEvent(ctx, event) {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(ctx.Writer, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		ctx.Writer.Flush()
+	}
+}
+
+// writeEvent writes an event to a stream. It returns false if the stream can't be written to.
+func writeEvent(ctx *gin.Context, event events.Event) bool {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		logger.ErrorLogger("Error encoding event: ", err)
+		return true
+	}
+	_, err = fmt.Fprintf(ctx.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err == nil
+}
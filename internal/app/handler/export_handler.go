@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/knstch/gophermart/internal/app/apierror"
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/logger"
+	validitycheck "github.com/knstch/gophermart/internal/app/validityCheck"
+)
+
+// Formats of history exports.
+const (
+	exportCSV    = "csv"
+	exportNDJSON = "ndjson"
+)
+
+// A struct designed to write rows of an export as CSV or JSON Lines while they are read
+// from the storage. The response headers are written with the first row, so an error
+// before it can still be returned with a status code.
+type exportWriter struct {
+	ctx      *gin.Context
+	format   string
+	filename string
+	columns  []string
+	csv      *csv.Writer
+	json     *json.Encoder
+	started  bool
+}
+
+// parseExportFormat reads the format query parameter of an export request, CSV by default.
+func parseExportFormat(ctx *gin.Context) (string, []validitycheck.FieldError) {
+	format := ctx.DefaultQuery("format", exportCSV)
+	if format != exportCSV && format != exportNDJSON {
+		return "", []validitycheck.FieldError{{Field: "format", Message: "must be csv or ndjson"}}
+	}
+	return format, nil
+}
+
+// newExportWriter returns a writer of an export named name with the CSV columns.
+func newExportWriter(ctx *gin.Context, format string, name string, columns []string) *exportWriter {
+	return &exportWriter{
+		ctx:      ctx,
+		format:   format,
+		filename: name + "." + format,
+		columns:  columns,
+	}
+}
+
+// start writes the response headers and the CSV header row if they are not written yet.
+func (w *exportWriter) start() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+
+	w.ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, w.filename))
+	if w.format == exportNDJSON {
+		w.ctx.Header("Content-Type", "application/x-ndjson")
+		w.ctx.Status(http.StatusOK)
+		w.json = json.NewEncoder(w.ctx.Writer)
+		return nil
+	}
+	w.ctx.Header("Content-Type", "text/csv; charset=utf-8")
+	w.ctx.Status(http.StatusOK)
+	w.csv = csv.NewWriter(w.ctx.Writer)
+	return w.csv.Write(w.columns)
+}
+
+// write writes a row, the record in CSV or the value in JSON Lines.
+func (w *exportWriter) write(record []string, value any) error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	if w.json != nil {
+		return w.json.Encode(value)
+	}
+	return w.csv.Write(record)
+}
+
+// finish writes the rows left in the buffer. An export failed after the first row
+// is cut off, as the status code is already sent.
+func (w *exportWriter) finish(err error) {
+	if err != nil && !w.started {
+		apierror.Abort(w.ctx, apierror.Internal)
+		return
+	}
+	if err != nil {
+		logger.ErrorLogger("Export is cut off: ", err)
+		return
+	}
+	if err := w.start(); err != nil {
+		logger.ErrorLogger("Error writing export: ", err)
+		return
+	}
+	if w.csv != nil {
+		w.csv.Flush()
+	}
+}
+
+// formatAmount formats bonuses for a CSV row.
+func formatAmount(amount float32) string {
+	return strconv.FormatFloat(float64(amount), 'f', -1, 32)
+}
+
+// @Summary Export user's orders
+// @Description Streams all orders of the user matching the filters as a CSV or JSON Lines attachment.
+// @Description The filters are the same as in the list of orders, limit and cursor are ignored
+// @Tags Order
+// @Produce text/csv,application/x-ndjson
+// @Param format query string false "Export format" Enums(csv, ndjson) default(csv)
+// @Param status query []string false "Statuses of orders" collectionFormat(csv) Enums(NEW, PROCESSING, INVALID, PROCESSED)
+// @Param from query string false "Orders uploaded at or after the time, RFC 3339" format(date-time)
+// @Param to query string false "Orders uploaded before the time, RFC 3339" format(date-time)
+// @Param sort query string false "Sort direction" Enums(asc, desc) default(asc)
+// @Success 200 {string} string "Orders"
+// @Failure 400 {object} apierror.Error "Validation failed (validation_failed)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /user/orders/export [get]
+func (h *Handler) ExportOrders(ctx *gin.Context) {
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+
+	format, fields := parseExportFormat(ctx)
+	query, queryFields := parseOrdersQuery(ctx)
+	fields = append(fields, queryFields...)
+	if len(fields) > 0 {
+		apierror.AbortValidation(ctx, fields)
+		return
+	}
+
+	w := newExportWriter(ctx, format, fmt.Sprintf("gophermart-orders-%d", user.UserID), []string{"number", "status", "accrual", "uploaded_at"})
+	err := h.s.StreamOrders(ctx, user.UserID, query, func(order common.Order) error {
+		accrual := ""
+		if order.Accrual != nil {
+			accrual = formatAmount(*order.Accrual)
+		}
+		return w.write([]string{order.Order, order.Status, accrual, order.UploadedAt}, order)
+	})
+	w.finish(err)
+}
+
+// @Summary Export user's withdrawals
+// @Description Streams all withdrawals of the user matching the filters as a CSV or JSON Lines attachment.
+// @Description The filters are the same as in the list of withdrawals, limit and cursor are ignored
+// @Tags Order
+// @Produce text/csv,application/x-ndjson
+// @Param format query string false "Export format" Enums(csv, ndjson) default(csv)
+// @Param from query string false "Withdrawals made at or after the time, RFC 3339" format(date-time)
+// @Param to query string false "Withdrawals made before the time, RFC 3339" format(date-time)
+// @Param min_sum query number false "Smallest sum of a withdrawal"
+// @Param max_sum query number false "Largest sum of a withdrawal"
+// @Param sort query string false "Sort direction" Enums(asc, desc) default(asc)
+// @Success 200 {string} string "Withdrawals"
+// @Failure 400 {object} apierror.Error "Validation failed (validation_failed)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /user/withdrawals/export [get]
+func (h *Handler) ExportWithdrawals(ctx *gin.Context) {
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+
+	format, fields := parseExportFormat(ctx)
+	query, queryFields := parseWithdrawalsQuery(ctx)
+	fields = append(fields, queryFields...)
+	if len(fields) > 0 {
+		apierror.AbortValidation(ctx, fields)
+		return
+	}
+
+	w := newExportWriter(ctx, format, fmt.Sprintf("gophermart-withdrawals-%d", user.UserID), []string{"order", "sum", "processed_at", "reversed_at"})
+	err := h.s.StreamWithdrawals(ctx, user.UserID, query, func(withdrawal common.OrdersWithSpentBonuses) error {
+		reversedAt := ""
+		if withdrawal.ReversedAt != nil {
+			reversedAt = withdrawal.ReversedAt.Format(time.RFC3339)
+		}
+		return w.write([]string{withdrawal.Order, formatAmount(withdrawal.BonusesWithdrawn), withdrawal.Time, reversedAt}, withdrawal)
+	})
+	w.finish(err)
+}
+
+// @Summary Export user's statement
+// @Description Streams the changes of the user's balance made in the period as a CSV or JSON Lines attachment,
+// @Description each with the balance after it
+// @Tags Balance
+// @Produce text/csv,application/x-ndjson
+// @Param format query string false "Export format" Enums(csv, ndjson) default(csv)
+// @Param from query string false "Changes made at or after the time, RFC 3339" format(date-time)
+// @Param to query string false "Changes made before the time, RFC 3339" format(date-time)
+// @Success 200 {string} string "Statement"
+// @Failure 400 {object} apierror.Error "Validation failed (validation_failed)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /user/statement/export [get]
+func (h *Handler) ExportStatement(ctx *gin.Context) {
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+
+	format, fields := parseExportFormat(ctx)
+	from, to, rangeFields := parseTimeRange(ctx)
+	fields = append(fields, rangeFields...)
+	if len(fields) > 0 {
+		apierror.AbortValidation(ctx, fields)
+		return
+	}
+
+	w := newExportWriter(ctx, format, fmt.Sprintf("gophermart-statement-%d", user.UserID), []string{"time", "order", "type", "amount", "balance"})
+	err := h.s.StreamStatement(ctx, user.UserID, from, to, func(line common.BalanceChange) error {
+		return w.write([]string{line.Time, line.Order, line.Type, formatAmount(line.Amount), formatAmount(line.Balance)}, line)
+	})
+	w.finish(err)
+}
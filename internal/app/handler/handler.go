@@ -4,13 +4,21 @@ package handler
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgerrcode"
+	"github.com/knstch/gophermart/cmd/config"
+	"github.com/knstch/gophermart/internal/app/apierror"
+	"github.com/knstch/gophermart/internal/app/common"
 	"github.com/knstch/gophermart/internal/app/cookie"
 	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/knstch/gophermart/internal/app/pagination"
 	"github.com/knstch/gophermart/internal/app/storage/psql"
 	validitycheck "github.com/knstch/gophermart/internal/app/validityCheck"
 )
@@ -20,11 +28,11 @@ import (
 // @Description API for user registration and setting an auth cookie
 // @Accept json
 // @Produce json
-// @Param userData body credentials true "Login and password"
+// @Param userData body Credentials true "Login and password"
 // @Success 200 {object} Message "Successfully registered"
-// @Failure 400 {object} ErrorMessage "Wrong request"
-// @Failure 409 {object} ErrorMessage "Login is already taken"
-// @Failure 500 {object} ErrorMessage "Internal Server Error"
+// @Failure 400 {object} apierror.Error "Wrong request or credentials breaking the validation policy (bad_request, validation_failed)"
+// @Failure 409 {object} apierror.Error "Login is already taken (login_taken)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
 // @Router /user/register [post]
 func (h *Handler) SignUp(ctx *gin.Context) {
 	var userData Credentials
@@ -33,28 +41,35 @@ func (h *Handler) SignUp(ctx *gin.Context) {
 	decoder.DisallowUnknownFields()
 	err := decoder.Decode(&userData)
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, newErrorMessage("Wrong request"))
+		apierror.Abort(ctx, apierror.BadRequest)
 		return
 	}
 
 	if userData.Login == "" || userData.Password == "" {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, newErrorMessage("Wrong request"))
+		apierror.Abort(ctx, apierror.BadRequest)
 		return
 	}
 
-	err = h.s.Register(ctx, userData.Login, userData.Password)
+	login := validitycheck.NormalizeLogin(userData.Login)
+
+	if fieldErrors := h.policy.Validate(login, userData.Password); len(fieldErrors) > 0 {
+		apierror.AbortValidation(ctx, fieldErrors)
+		return
+	}
+
+	account, err := h.s.Register(ctx, login, userData.Password)
 	switch {
-	case errors.As(err, &pgErr) && pgerrcode.IsIntegrityConstraintViolation(pgErr.Code):
-		ctx.AbortWithStatusJSON(http.StatusConflict, newErrorMessage("Login is already taken"))
+	case errors.Is(err, psql.ErrLoginTaken),
+		errors.As(err, &pgErr) && pgerrcode.IsIntegrityConstraintViolation(pgErr.Code):
+		apierror.Abort(ctx, apierror.LoginTaken)
 		return
 	case err != nil:
-		ctx.AbortWithStatusJSON(http.StatusInternalServerError, newErrorMessage("Internal Server Error"))
+		apierror.Abort(ctx, apierror.Internal)
 		return
 	}
-	err = cookie.SetAuth(ctx.Writer, userData.Login)
+	err = h.startSession(ctx, account)
 	if err != nil {
-		logger.ErrorLogger("Can't set cookie: ", err)
-		ctx.AbortWithStatusJSON(http.StatusInternalServerError, newErrorMessage("Internal Server Error"))
+		apierror.Abort(ctx, apierror.Internal)
 		return
 	}
 	ctx.JSON(http.StatusOK, newMessage("Successfully registered"))
@@ -62,14 +77,19 @@ func (h *Handler) SignUp(ctx *gin.Context) {
 
 // @Summary Auth
 // @Tags Auth
-// @Description API for user authentication and setting an auth cookie
+// @Description API for user authentication and setting an auth cookie.
+// @Description Accounts with two-factor authentication get a challenge to finish the login at /user/login/2fa instead.
 // @Accept json
 // @Produce json
-// @Param userData body credentials true "Login and password"
+// @Param userData body Credentials true "Login and password"
 // @Success 200 {object} Message "Successfully signed in"
-// @Failure 400 {object} ErrorMessage "Wrong request"
-// @Failure 401 {object} ErrorMessage "Wrong email or password"
-// @Failure 500 {object} ErrorMessage "Internal Server Error"
+// @Success 202 {object} loginChallenge "A second factor is required"
+// @Failure 400 {object} apierror.Error "Wrong request (bad_request)"
+// @Failure 401 {object} apierror.Error "Wrong email or password (wrong_credentials)"
+// @Failure 403 {object} apierror.Error "Account is suspended (account_suspended)"
+// @Failure 429 {object} apierror.Error "Too many login attempts (too_many_attempts)"
+// @Header 429 {integer} Retry-After "Seconds until the next attempt is allowed"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
 // @Router /user/login [post]
 func (h *Handler) Auth(ctx *gin.Context) {
 	var userData Credentials
@@ -78,20 +98,58 @@ func (h *Handler) Auth(ctx *gin.Context) {
 	decoder.DisallowUnknownFields()
 	err := decoder.Decode(&userData)
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, newErrorMessage("Wrong request"))
+		apierror.Abort(ctx, apierror.BadRequest)
 		return
 	}
 
-	err = h.s.CheckCredentials(ctx, userData.Login, userData.Password)
-	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusUnauthorized, newErrorMessage("Wrong email or password"))
+	login := validitycheck.NormalizeLogin(userData.Login)
+	clientIP := ctx.ClientIP()
+
+	if retryAfter := h.guard.Check(login, clientIP); retryAfter > 0 {
+		abortTooManyAttempts(ctx, retryAfter)
+		return
+	}
+
+	account, err := h.s.CheckCredentials(ctx, login, userData.Password)
+	switch {
+	case errors.Is(err, psql.ErrWrongCredentials):
+		h.rejectLogin(ctx, login, clientIP, apierror.WrongCredentials)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+	h.guard.Succeed(login)
+
+	h.logIn(ctx, account)
+}
+
+// logIn finishes a login of an account which has passed the first factor. It starts
+// a session and responds with 200 status code, or responds with 202 status code and
+// a challenge if the account has two-factor authentication. Suspended accounts get 403 status code.
+func (h *Handler) logIn(ctx *gin.Context, account common.Account) {
+	if account.State == common.AccountSuspended {
+		abortSuspended(ctx, account)
+		return
+	}
+
+	if account.TOTPEnabled {
+		challenge, err := cookie.NewChallenge(account)
+		if err != nil {
+			logger.ErrorLogger("Error building login challenge: ", err)
+			apierror.Abort(ctx, apierror.Internal)
+			return
+		}
+		ctx.JSON(http.StatusAccepted, loginChallenge{
+			Challenge: challenge,
+			ExpiresIn: int(cookie.ChallengeTTL.Seconds()),
+		})
 		return
 	}
 
-	err = cookie.SetAuth(ctx.Writer, userData.Login)
+	err := h.startSession(ctx, account)
 	if err != nil {
-		logger.ErrorLogger("Can't set cookie: ", err)
-		ctx.AbortWithStatusJSON(http.StatusInternalServerError, newErrorMessage("Internal Server Error"))
+		apierror.Abort(ctx, apierror.Internal)
 		return
 	}
 
@@ -104,55 +162,140 @@ func (h *Handler) Auth(ctx *gin.Context) {
 // @Accept plain
 // @Produce json
 // @Param orderNum body string true "Order number"
+// @Param Idempotency-Key header string false "Key making a retry get the response to the first request"
 // @Success 200 {object} Message "Order was successfully loaded before"
 // @Success 202 {object} Message "Order was successfully accepted"
-// @Failure 409 {object} ErrorMessage "Order is already loaded"
-// @Failure 422 {object} ErrorMessage "Wrong order number"
-// @Failure 500 {object} ErrorMessage "Internal Server Error"
+// @Failure 409 {object} apierror.Error "Order is loaded by another user or a request with the idempotency key is in progress (order_owned_by_another_user, idempotency_key_in_progress)"
+// @Failure 422 {object} apierror.Error "Wrong order number or the idempotency key is used with a different request (order_invalid, idempotency_key_mismatch)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
 // @Router /user/orders [post]
 func (h *Handler) UploadOrder(ctx *gin.Context) {
 	body, err := io.ReadAll(ctx.Request.Body)
 	if err != nil {
 		logger.ErrorLogger("Error during opening body: ", err)
-		ctx.AbortWithStatusJSON(http.StatusInternalServerError, newErrorMessage("Internal Server Error"))
+		apierror.Abort(ctx, apierror.Internal)
 		return
 	}
 
-	orderNum := string(body)
+	h.uploadOrder(ctx, string(body))
+}
 
-	login := ctx.Value("login").(string)
+// uploadOrder uploads an order of the current user and writes the response.
+func (h *Handler) uploadOrder(ctx *gin.Context, orderNum string) {
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
 
-	err = h.s.InsertOrder(ctx, login, orderNum)
+	err := h.s.InsertOrder(ctx, user.UserID, orderNum)
 	switch {
 	case errors.Is(err, psql.ErrAlreadyLoadedOrder):
-		ctx.AbortWithStatusJSON(http.StatusConflict, newErrorMessage("Order is already loaded by another user"))
+		apierror.Abort(ctx, apierror.OrderOwnedByAnotherUser)
 		return
 	case errors.Is(err, psql.ErrYouAlreadyLoadedOrder):
 		ctx.AbortWithStatusJSON(http.StatusOK, newMessage("Order is already loaded"))
 		return
 	case errors.Is(err, validitycheck.ErrWrongOrderNum):
-		ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, newErrorMessage("Wrong order number"))
+		apierror.Abort(ctx, apierror.OrderInvalid)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
 		return
 	default:
 		ctx.JSON(http.StatusAccepted, newMessage("Successfully loaded order"))
 	}
 }
 
+// @Summary Upload orders in a batch
+// @Tags Order
+// @Description Uploads several orders at once from a JSON array of numbers, a text/csv body or a CSV file
+// @Description in the file field of a multipart form. CSV numbers are read from the first column, a header row is skipped.
+// @Description Every number gets a result: accepted, already_yours, owned_by_another_user or invalid
+// @Accept json,text/csv,mpfd
+// @Produce json
+// @Param orders body []string false "Order numbers"
+// @Param file formData file false "CSV file with order numbers"
+// @Param Idempotency-Key header string false "Key making a retry get the response to the first request"
+// @Success 200 {array} common.OrderUploadResult "Results in the order of the numbers"
+// @Failure 400 {object} apierror.Error "Wrong request or too many numbers (bad_request, validation_failed)"
+// @Failure 409 {object} apierror.Error "A request with the idempotency key is in progress (idempotency_key_in_progress)"
+// @Failure 422 {object} apierror.Error "The idempotency key is used with a different request (idempotency_key_mismatch)"
+// @Failure 413 {object} apierror.Error "Request is too large (request_too_large)"
+// @Failure 415 {object} apierror.Error "Unsupported content type (unsupported_media_type)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /user/orders/batch [post]
+func (h *Handler) UploadOrders(ctx *gin.Context) {
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxBatchBodySize)
+	numbers, err := readOrderNumbers(ctx)
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, errUnsupportedBatch):
+		apierror.Abort(ctx, apierror.UnsupportedMediaType.WithMessage("Send a JSON array, a CSV body or a CSV file"))
+		return
+	case errors.As(err, &maxBytesErr):
+		apierror.Abort(ctx, apierror.RequestTooLarge)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.BadRequest)
+		return
+	}
+
+	limit := config.ReadyConfig.OrdersBatchLimit
+	if len(numbers) == 0 || len(numbers) > limit {
+		apierror.AbortValidation(ctx, []validitycheck.FieldError{
+			{Field: "orders", Message: fmt.Sprintf("must have from 1 to %d order numbers", limit)},
+		})
+		return
+	}
+
+	results, err := h.s.InsertOrders(ctx, user.UserID, numbers)
+	if err != nil {
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, results)
+}
+
 // @Summary Get user's orders
-// @Description Retrieves the orders associated with the user
+// @Description Retrieves a page of the orders associated with the user ordered by the upload time.
+// @Description If there are more orders, the X-Next-Cursor header has the cursor of the next page.
+// @Description All orders are returned if neither limit nor cursor is set
 // @Tags Order
 // @Produce json
+// @Param limit query int false "Orders per page, from 1 to 100, 50 if only the cursor is set"
+// @Param cursor query string false "Cursor of the page from the X-Next-Cursor header"
+// @Param status query []string false "Statuses of orders" collectionFormat(csv) Enums(NEW, PROCESSING, INVALID, PROCESSED)
+// @Param from query string false "Orders uploaded at or after the time, RFC 3339" format(date-time)
+// @Param to query string false "Orders uploaded before the time, RFC 3339" format(date-time)
+// @Param sort query string false "Sort direction" Enums(asc, desc) default(asc)
 // @Success 200 {array} common.Order "A list of user's orders"
+// @Header 200 {string} X-Next-Cursor "Cursor of the next page"
 // @Failure 204 {object} Message "A user has no orders"
-// @Failure 500 {object} ErrorMessage "Internal server error"
+// @Failure 400 {object} apierror.Error "Validation failed (validation_failed)"
+// @Failure 500 {object} apierror.Error "Internal server error (internal_error)"
 // @Router /user/orders [get]
 func (h *Handler) GetOrders(ctx *gin.Context) {
-	login := ctx.Value("login").(string)
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
 
-	orders, err := h.s.GetOrders(ctx, login)
+	query, fields := parseOrdersQuery(ctx)
+	if len(fields) > 0 {
+		apierror.AbortValidation(ctx, fields)
+		return
+	}
+
+	orders, next, err := h.s.GetOrders(ctx, user.UserID, query)
 	if err != nil {
 		logger.ErrorLogger("Error getting orders", err)
-		ctx.AbortWithStatusJSON(http.StatusInternalServerError, newErrorMessage("Internal Server Error"))
+		apierror.Abort(ctx, apierror.Internal)
 		return
 	}
 
@@ -161,23 +304,65 @@ func (h *Handler) GetOrders(ctx *gin.Context) {
 		return
 	}
 
+	if next != nil {
+		ctx.Header(pagination.Header, next.Encode())
+	}
 	ctx.JSON(http.StatusOK, orders)
 }
 
+// @Summary Get user's order
+// @Description Retrieves an order of the user with the timeline of its status changes.
+// @Description Changes made by the accrual system have the accrual and the response of the accrual system
+// @Tags Order
+// @Produce json
+// @Param number path string true "Order number"
+// @Success 200 {object} common.OrderDetails "The order with its timeline"
+// @Failure 404 {object} apierror.Error "Order not found (order_not_found)"
+// @Failure 422 {object} apierror.Error "Wrong order number (order_invalid)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /user/orders/{number} [get]
+func (h *Handler) GetOrder(ctx *gin.Context) {
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+
+	number := ctx.Param("number")
+	if !validitycheck.LuhnAlgorithm(number) {
+		apierror.Abort(ctx, apierror.OrderInvalid)
+		return
+	}
+
+	order, err := h.s.GetOrder(ctx, number)
+	switch {
+	case errors.Is(err, psql.ErrNoRows), err == nil && order.UserID != user.UserID:
+		apierror.Abort(ctx, apierror.OrderNotFound)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, order)
+}
+
 // @Summary Get user's balance
 // @Description Retrieves the user's balance and withdrawn amount
 // @Tags Balance
 // @Produce json
 // @Success 200 {object} balanceInfo "User's balance"
-// @Failure 500 {object} ErrorMessage "Internal Server Error"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
 // @Router /user/balance [get]
 func (h *Handler) Balance(ctx *gin.Context) {
-	login := ctx.Value("login").(string)
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
 
-	balance, withdrawn, err := h.s.GetBalance(ctx, login)
+	balance, withdrawn, err := h.s.GetBalance(ctx, user.UserID)
 	if err != nil {
 		logger.ErrorLogger("Error getting balance", err)
-		ctx.AbortWithStatusJSON(http.StatusInternalServerError, newErrorMessage("Internal Server Error"))
+		apierror.Abort(ctx, apierror.Internal)
 		return
 	}
 
@@ -195,62 +380,210 @@ func (h *Handler) Balance(ctx *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param orderData body getSpendBonusRequest true "Order number and withdraw amount"
+// @Param Idempotency-Key header string false "Key making a retry get the response to the first request"
 // @Success 200 {object} Message "Bonuses successfully spent"
-// @Failure 400 {object} ErrorMessage "Wrong request"
-// @Failure 402 {object} ErrorMessage "Not enough balance"
-// @Failure 422 {object} ErrorMessage "Wrong order number"
-// @Failure 409 {object} ErrorMessage "Order is already loaded"
-// @Failure 500 {object} ErrorMessage "Internal Server Error"
+// @Failure 400 {object} apierror.Error "Wrong request (bad_request)"
+// @Failure 402 {object} apierror.Error "Not enough balance (not_enough_balance)"
+// @Failure 403 {object} apierror.Error "Account is frozen (account_frozen)"
+// @Failure 422 {object} apierror.Error "Wrong order number or the idempotency key is used with a different request (order_invalid, idempotency_key_mismatch)"
+// @Failure 409 {object} apierror.Error "Order is already loaded or a request with the idempotency key is in progress (order_already_loaded, idempotency_key_in_progress)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
 // @Router /user/balance/withdraw [post]
 func (h *Handler) WithdrawBonuses(ctx *gin.Context) {
-	login := ctx.Value("login").(string)
-
 	var spendRequest getSpendBonusRequest
 
 	if err := ctx.ShouldBindJSON(&spendRequest); err != nil {
 		logger.ErrorLogger("Wrong request: ", err)
-		ctx.JSON(http.StatusBadRequest, newErrorMessage("Wrong request"))
+		apierror.Abort(ctx, apierror.BadRequest)
+		return
+	}
+
+	h.withdrawBonuses(ctx, spendRequest)
+}
+
+// withdrawBonuses spends bonuses of the current user on an order and writes the response.
+func (h *Handler) withdrawBonuses(ctx *gin.Context, spendRequest getSpendBonusRequest) {
+	user, ok := currentUser(ctx)
+	if !ok {
 		return
 	}
 
-	err := h.s.SpendBonuses(ctx, login, spendRequest.Order, spendRequest.Sum)
+	err := h.s.SpendBonuses(ctx, user.UserID, spendRequest.Order, spendRequest.Sum)
 	switch {
 	case errors.Is(err, psql.ErrNotEnoughBalance):
-		ctx.AbortWithStatusJSON(http.StatusPaymentRequired, newErrorMessage("Not enough balance"))
+		apierror.Abort(ctx, apierror.NotEnoughBalance)
+		return
+	case errors.Is(err, psql.ErrAccountFrozen):
+		apierror.Abort(ctx, apierror.AccountFrozen)
 		return
 	case errors.Is(err, validitycheck.ErrWrongOrderNum):
-		ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, newErrorMessage("Wrong order number"))
+		apierror.Abort(ctx, apierror.OrderInvalid)
 		return
 	case errors.Is(err, psql.ErrAlreadyLoadedOrder) || errors.Is(err, psql.ErrYouAlreadyLoadedOrder):
-		ctx.AbortWithStatusJSON(http.StatusConflict, newErrorMessage("Order is already loaded"))
+		apierror.Abort(ctx, apierror.OrderAlreadyLoaded)
 		return
 	case err != nil:
-		ctx.AbortWithStatusJSON(http.StatusInternalServerError, newErrorMessage("Internal Server Error"))
+		apierror.Abort(ctx, apierror.Internal)
 		return
 	}
 	ctx.JSON(http.StatusOK, newMessage("Bonuses successfully spent"))
 }
 
+// @Summary Get user's statement
+// @Description Retrieves the changes of the user's balance ordered by time: accruals, withdrawals and reversals
+// @Description of withdrawals, each with the balance after it. The opening balance is the balance before the period
+// @Description and the closing balance is the balance at its end
+// @Tags Balance
+// @Produce json
+// @Param from query string false "Changes made at or after the time, RFC 3339" format(date-time)
+// @Param to query string false "Changes made before the time, RFC 3339" format(date-time)
+// @Success 200 {object} common.Statement "User's statement"
+// @Failure 400 {object} apierror.Error "Validation failed (validation_failed)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /user/statement [get]
+func (h *Handler) GetStatement(ctx *gin.Context) {
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+
+	from, to, fields := parseTimeRange(ctx)
+	if len(fields) > 0 {
+		apierror.AbortValidation(ctx, fields)
+		return
+	}
+
+	statement, err := h.s.GetStatement(ctx, user.UserID, from, to)
+	if err != nil {
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, statement)
+}
+
 // @Summary Get orders with spent bonuses
-// @Description Retrieves the orders with bonuses spent by the user
+// @Description Retrieves a page of the orders with bonuses spent by the user ordered by time.
+// @Description If there are more withdrawals, the X-Next-Cursor header has the cursor of the next page.
+// @Description Reversed withdrawals have the time of the reversal in reversed_at.
+// @Description The X-Total-Sum header has the sum of all withdrawals matching the filters except reversed ones.
+// @Description All withdrawals are returned if neither limit nor cursor is set
 // @Tags Order
 // @Produce json
-// @Success 200 {object} common.OrdersWithSpentBonuses "A list of orders with spent bonuses"
+// @Param limit query int false "Withdrawals per page, from 1 to 100, 50 if only the cursor is set"
+// @Param cursor query string false "Cursor of the page from the X-Next-Cursor header"
+// @Param from query string false "Withdrawals made at or after the time, RFC 3339" format(date-time)
+// @Param to query string false "Withdrawals made before the time, RFC 3339" format(date-time)
+// @Param min_sum query number false "Smallest sum of a withdrawal"
+// @Param max_sum query number false "Largest sum of a withdrawal"
+// @Param sort query string false "Sort direction" Enums(asc, desc) default(asc)
+// @Success 200 {array} common.OrdersWithSpentBonuses "A list of orders with spent bonuses"
+// @Header 200 {string} X-Next-Cursor "Cursor of the next page"
+// @Header 200 {number} X-Total-Sum "Sum of all withdrawals matching the filters except reversed ones"
 // @Failure 204 {object} Message "You have not spent any bonuses"
-// @Failure 500 {object} ErrorMessage "Internal Server Error"
+// @Failure 400 {object} apierror.Error "Validation failed (validation_failed)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
 // @Router /user/withdrawals [get]
 func (h *Handler) GetOrderWithSpentBonuses(ctx *gin.Context) {
-	login := ctx.Value("login").(string)
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+
+	query, fields := parseWithdrawalsQuery(ctx)
+	if len(fields) > 0 {
+		apierror.AbortValidation(ctx, fields)
+		return
+	}
 
-	ordersWithBonuses, err := h.s.GetOrdersWithBonuses(ctx, login)
+	ordersWithBonuses, next, err := h.s.GetOrdersWithBonuses(ctx, user.UserID, query)
 	switch {
 	case errors.Is(err, psql.ErrNoRows):
 		ctx.AbortWithStatusJSON(http.StatusNoContent, newMessage("You have not spent any bonuses"))
 		return
 	case err != nil:
-		ctx.AbortWithStatusJSON(http.StatusInternalServerError, newErrorMessage("Internal Server Error"))
+		apierror.Abort(ctx, apierror.Internal)
 		return
 	}
 
+	total, err := h.s.SumWithdrawals(ctx, user.UserID, query)
+	if err != nil {
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+
+	if next != nil {
+		ctx.Header(pagination.Header, next.Encode())
+	}
+	ctx.Header(totalSumHeader, strconv.FormatFloat(float64(total), 'f', -1, 32))
 	ctx.JSON(http.StatusOK, ordersWithBonuses)
 }
+
+// @Summary Reverse a withdrawal
+// @Description Gives the bonuses spent on an order back to the user's balance and marks the withdrawal as reversed.
+// @Description A withdrawal can be reversed within the time set by the -withdrawal-reversal-window flag after it was made.
+// @Description Reversing a reversed withdrawal returns it without changing the balance
+// @Tags Balance
+// @Produce json
+// @Param number path string true "Order number"
+// @Success 200 {object} common.OrdersWithSpentBonuses "The reversed withdrawal"
+// @Failure 403 {object} apierror.Error "Reversals are allowed only to admins or the withdrawal can't be reversed anymore (reversal_not_allowed, reversal_window_closed)"
+// @Failure 404 {object} apierror.Error "Withdrawal not found (withdrawal_not_found)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /user/withdrawals/{number}/reversal [post]
+func (h *Handler) ReverseWithdrawal(ctx *gin.Context) {
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+
+	window := config.ReadyConfig.WithdrawalReversalWindow
+	if window <= 0 {
+		apierror.Abort(ctx, apierror.ReversalNotAllowed)
+		return
+	}
+
+	withdrawal, _, err := h.s.ReverseWithdrawal(ctx, ctx.Param("number"), user.UserID, user.UserID, window)
+	switch {
+	case errors.Is(err, psql.ErrNoRows):
+		apierror.Abort(ctx, apierror.WithdrawalNotFound)
+		return
+	case errors.Is(err, psql.ErrReversalWindowClosed):
+		apierror.Abort(ctx, apierror.ReversalWindowClosed)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, withdrawal)
+}
+
+// abortTooManyAttempts stops a login request of a locked account or client address
+// with 429 status code and a Retry-After header.
+func abortTooManyAttempts(ctx *gin.Context, retryAfter time.Duration) {
+	ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	apierror.Abort(ctx, apierror.TooManyAttempts)
+}
+
+// abortSuspended stops a login of a suspended account with 403 status code.
+func abortSuspended(ctx *gin.Context, account common.Account) {
+	logger.SecurityLogger("Suspended account login", fmt.Sprintf("user %d (%s) tried to log in from %s", account.ID, account.Login, ctx.ClientIP()))
+	apierror.Abort(ctx, apierror.AccountSuspended)
+}
+
+// rejectLogin records a failed login attempt, waits for the guard delay
+// and stops the request with the error, or with 429 status code
+// if the account or the client address got locked.
+func (h *Handler) rejectLogin(ctx *gin.Context, login string, clientIP string, kind apierror.Kind) {
+	delay, lockout := h.guard.Fail(login, clientIP)
+	if lockout > 0 {
+		abortTooManyAttempts(ctx, lockout)
+		return
+	}
+	select {
+	case <-time.After(delay):
+	case <-ctx.Request.Context().Done():
+	}
+	apierror.Abort(ctx, kind)
+}
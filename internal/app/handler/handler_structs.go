@@ -2,30 +2,132 @@ package handler
 
 import (
 	"context"
+	"strings"
+	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/knstch/gophermart/cmd/config"
+	"github.com/knstch/gophermart/internal/app/apierror"
 	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/cookie"
+	"github.com/knstch/gophermart/internal/app/events"
+	"github.com/knstch/gophermart/internal/app/identity"
+	loginguard "github.com/knstch/gophermart/internal/app/loginGuard"
+	"github.com/knstch/gophermart/internal/app/notifier"
+	"github.com/knstch/gophermart/internal/app/oidc"
+	"github.com/knstch/gophermart/internal/app/pagination"
+	validitycheck "github.com/knstch/gophermart/internal/app/validityCheck"
 )
 
 // An interface responsible for operations with a database.
 type Storage interface {
-	Register(ctx context.Context, email string, password string) error
-	CheckCredentials(ctx context.Context, login string, password string) error
-	InsertOrder(ctx context.Context, login string, order string) error
-	GetOrders(ctx context.Context, login string) ([]common.Order, error)
-	GetBalance(ctx context.Context, login string) (float32, float32, error)
-	SpendBonuses(ctx context.Context, login string, orderNum string, spendBonuses float32) error
-	GetOrdersWithBonuses(ctx context.Context, login string) ([]common.OrdersWithSpentBonuses, error)
+	cookie.SessionStore
+	Register(ctx context.Context, email string, password string) (common.Account, error)
+	CheckCredentials(ctx context.Context, login string, password string) (common.Account, error)
+	ChangeLogin(ctx context.Context, userID int64, login string) error
+	InsertOrder(ctx context.Context, userID int64, order string) error
+	InsertOrders(ctx context.Context, userID int64, numbers []string) ([]common.OrderUploadResult, error)
+	GetOrders(ctx context.Context, userID int64, query common.OrdersQuery) ([]common.Order, *pagination.Cursor, error)
+	GetOrder(ctx context.Context, number string) (common.OrderDetails, error)
+	GetBalance(ctx context.Context, userID int64) (float32, float32, error)
+	GetStatement(ctx context.Context, userID int64, from *time.Time, to *time.Time) (common.Statement, error)
+	SpendBonuses(ctx context.Context, userID int64, orderNum string, spendBonuses float32) error
+	GetOrdersWithBonuses(ctx context.Context, userID int64, query common.WithdrawalsQuery) ([]common.OrdersWithSpentBonuses, *pagination.Cursor, error)
+	SumWithdrawals(ctx context.Context, userID int64, query common.WithdrawalsQuery) (float32, error)
+	StreamOrders(ctx context.Context, userID int64, query common.OrdersQuery, fn func(common.Order) error) error
+	StreamWithdrawals(ctx context.Context, userID int64, query common.WithdrawalsQuery, fn func(common.OrdersWithSpentBonuses) error) error
+	StreamStatement(ctx context.Context, userID int64, from *time.Time, to *time.Time, fn func(common.BalanceChange) error) error
+	ReverseWithdrawal(ctx context.Context, number string, ownerID int64, reversedBy int64, window time.Duration) (common.OrdersWithSpentBonuses, bool, error)
+	ChangePassword(ctx context.Context, userID int64, password string) (int, error)
+	CreateResetToken(ctx context.Context, login string, token string, expiresAt time.Time) (string, error)
+	ResetPassword(ctx context.Context, token string, password string) (string, error)
+	GetAccountInfo(ctx context.Context, login string) (common.AccountInfo, error)
+	SetRoles(ctx context.Context, login string, roles []string) error
+	SetAccountState(ctx context.Context, login string, state string, reason string, changedBy int64) (common.AccountStateChange, error)
+	GetAccountStateChanges(ctx context.Context, userID int64) ([]common.AccountStateChange, error)
+	CreateAPIKey(ctx context.Context, apiKey common.APIKey, key string) (common.APIKey, error)
+	GetAPIKeys(ctx context.Context, login string) ([]common.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int64) error
+	CreateSession(ctx context.Context, session common.Session) error
+	GetSessions(ctx context.Context, userID int64) ([]common.Session, error)
+	RevokeSession(ctx context.Context, userID int64, id string) error
+	ExportAccount(ctx context.Context, userID int64) (common.AccountExport, error)
+	DeleteAccount(ctx context.Context, userID int64) error
+	GetTwoFactor(ctx context.Context, userID int64) (common.Account, common.TwoFactor, error)
+	HasPassword(ctx context.Context, userID int64) (bool, error)
+	StartTwoFactor(ctx context.Context, userID int64, secret string) error
+	EnableTwoFactor(ctx context.Context, userID int64, counter int64, recoveryCodes []string) error
+	DisableTwoFactor(ctx context.Context, userID int64) error
+	UseTOTPCode(ctx context.Context, userID int64, counter int64) error
+	UseRecoveryCode(ctx context.Context, userID int64, code string) error
+	GetOIDCAccount(ctx context.Context, issuer string, subject string) (common.Account, error)
+	LinkOIDCIdentity(ctx context.Context, userID int64, issuer string, subject string) error
+	CreateOIDCAccount(ctx context.Context, login string, issuer string, subject string) (common.Account, error)
+	Events() *events.Broker
 }
 
 // A struct implementing Storage interface.
 type Handler struct {
-	s Storage
+	s        Storage
+	guard    *loginguard.Guard
+	policy   *validitycheck.CredentialsPolicy
+	notifier notifier.Notifier
+	sessions cookie.SessionManager
+	oidc     *oidc.Provider
 }
 
-// A builder function returning a Handler struct with Storage interface.
-func NewHandler(s Storage) *Handler {
-	return &Handler{s: s}
+// A builder function returning a Handler struct with Storage interface,
+// a login guard, a credentials policy, a notifier, a session manager and an OpenID Connect
+// provider configured from config.ReadyConfig. The provider is nil if no issuer is set.
+// It returns an error if the credentials policy can't be built.
+func NewHandler(s Storage) (*Handler, error) {
+	policy, err := validitycheck.NewCredentialsPolicy(config.ReadyConfig.LoginMinLength, config.ReadyConfig.LoginMaxLength,
+		config.ReadyConfig.LoginCharset, config.ReadyConfig.PasswordMinLength, config.ReadyConfig.PasswordMinClasses,
+		config.ReadyConfig.PasswordDenylist)
+	if err != nil {
+		return nil, err
+	}
+
+	var provider *oidc.Provider
+	if config.ReadyConfig.OIDCIssuer != "" {
+		provider = oidc.NewProvider(oidc.Config{
+			Issuer:       config.ReadyConfig.OIDCIssuer,
+			ClientID:     config.ReadyConfig.OIDCClientID,
+			ClientSecret: config.ReadyConfig.OIDCClientSecret,
+			RedirectURL:  config.ReadyConfig.OIDCRedirectURL,
+			Scopes:       strings.Fields(config.ReadyConfig.OIDCScopes),
+		})
+	}
+
+	return &Handler{
+		s: s,
+		guard: loginguard.NewGuard(config.ReadyConfig.LoginMaxAttempts, config.ReadyConfig.LoginMaxIPAttempts,
+			config.ReadyConfig.LoginBaseDelay, config.ReadyConfig.LoginLockout),
+		policy:   policy,
+		notifier: newNotifier(),
+		sessions: cookie.NewSessionManager(s),
+		oidc:     provider,
+	}, nil
+}
+
+// newNotifier returns a notifier writing to config.ReadyConfig.ResetSink
+// or to the log if the sink is not set.
+func newNotifier() notifier.Notifier {
+	if config.ReadyConfig.ResetSink != "" {
+		return notifier.NewFileNotifier(config.ReadyConfig.ResetSink)
+	}
+	return notifier.LogNotifier{}
+}
+
+// currentUser returns the authenticated caller put inside of a context by the auth middleware.
+// If there is none, it stops the request with 401 status code and returns false.
+func currentUser(ctx *gin.Context) (identity.Identity, bool) {
+	user, ok := identity.From(ctx)
+	if !ok {
+		apierror.Abort(ctx, apierror.Unauthenticated)
+	}
+	return user, ok
 }
 
 // A struct used to get and store data from a json requests.
@@ -49,6 +151,113 @@ type getSpendBonusRequest struct {
 	Sum   float32 `json:"sum"`
 }
 
+// A struct used to parse a json request to upload an order in API v2.
+type uploadOrderRequest struct {
+	Order string `json:"order"`
+}
+
+// A struct used to parse a json request to change a password.
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// A struct used to parse a json request to send a password reset token.
+type passwordResetRequest struct {
+	Login string `json:"login"`
+}
+
+// A struct used to parse a json request to reset a password with a token.
+type passwordResetConfirmRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// A struct used to parse a json request to set roles of a user.
+type setRolesRequest struct {
+	Roles []string `json:"roles"`
+}
+
+// A struct used to parse a json request to change a state of an account.
+// A reason is required for every state but active.
+type setStateRequest struct {
+	State  string `json:"state" example:"frozen"`
+	Reason string `json:"reason" example:"Chargeback under investigation"`
+}
+
+// A prefix of every API key, making leaked keys easy to find.
+const apiKeyPrefix = "gm_"
+
+// A number of API key characters stored to recognize the key.
+const apiKeyPrefixLength = 11
+
+// A struct used to parse a json request to update a profile.
+type updateProfileRequest struct {
+	Login string `json:"login"`
+}
+
+// A struct used to parse a json request to delete an account. The code is
+// a TOTP or recovery code required if two-factor authentication is enabled.
+// Accounts without a password send no password.
+type deleteAccountRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code,omitempty"`
+}
+
+// A struct used to parse a json request to create an API key.
+type createAPIKeyRequest struct {
+	Login     string   `json:"login"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	RateLimit int      `json:"rate_limit"`
+}
+
+// A struct used to return a created API key. The key is shown only once.
+type createdAPIKey struct {
+	Key    string        `json:"key"`
+	APIKey common.APIKey `json:"api_key"`
+}
+
+// A struct used to return a login challenge of an account with two-factor authentication.
+type loginChallenge struct {
+	Challenge string `json:"challenge"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+// A struct used to parse a json request to finish a login with a second factor.
+type secondFactorRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+// A struct used to return a TOTP secret of a started two-factor enrolment.
+type twoFactorEnrolment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// A struct used to parse a json request with a one-time code.
+type twoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// A struct used to return recovery codes. The codes are shown only once.
+type recoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// A struct used to parse a json request to disable two-factor authentication.
+type disableTwoFactorRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// An issuer shown in authenticator apps.
+const totpIssuer = "Gophermart"
+
+// A number of recovery codes given when two-factor authentication is enabled.
+const recoveryCodesCount = 10
+
 // A struct used to generate a message for a user
 type Message struct {
 	Line string `json:"message"`
@@ -60,15 +269,3 @@ func newMessage(msg string) *Message {
 		Line: msg,
 	}
 }
-
-// A struct used to generate error message for a user
-type ErrorMessage struct {
-	Line string `json:"error"`
-}
-
-// A builder function returning error message
-func newErrorMessage(msg string) *ErrorMessage {
-	return &ErrorMessage{
-		Line: msg,
-	}
-}
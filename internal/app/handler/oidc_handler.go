@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/knstch/gophermart/cmd/config"
+	"github.com/knstch/gophermart/internal/app/apierror"
+	"github.com/knstch/gophermart/internal/app/cookie"
+	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/knstch/gophermart/internal/app/oidc"
+	"github.com/knstch/gophermart/internal/app/storage/psql"
+	validitycheck "github.com/knstch/gophermart/internal/app/validityCheck"
+)
+
+// @Summary Login with an identity provider
+// @Tags Auth
+// @Description Redirects to the login page of the configured OpenID Connect provider.
+// @Description The provider redirects back to /user/oidc/callback
+// @Success 302 "Redirect to the identity provider"
+// @Failure 502 {object} apierror.Error "Identity provider is unavailable (oidc_unavailable)"
+// @Router /user/oidc/login [get]
+func (h *Handler) OIDCLogin(ctx *gin.Context) {
+	var values [3]string
+	for i := range values {
+		value, err := oidc.RandomString()
+		if err != nil {
+			logger.ErrorLogger("Error generating OIDC state: ", err)
+			apierror.Abort(ctx, apierror.Internal)
+			return
+		}
+		values[i] = value
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	authURL, err := h.oidc.AuthURL(ctx, state, nonce, verifier)
+	if err != nil {
+		logger.ErrorLogger("Error reaching identity provider: ", err)
+		apierror.Abort(ctx, apierror.OIDCUnavailable)
+		return
+	}
+
+	err = cookie.SetOIDCFlow(ctx.Writer, state, nonce, verifier)
+	if err != nil {
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+
+	ctx.Redirect(http.StatusFound, authURL)
+}
+
+// @Summary Identity provider callback
+// @Tags Auth
+// @Description Finishes a login with the OpenID Connect provider and sets an auth cookie.
+// @Description A user logged in already gets the identity linked to the account instead.
+// @Description An unknown identity gets a new account if automatic creation is configured.
+// @Description Accounts with two-factor authentication get a challenge to finish the login at /user/login/2fa
+// @Produce json
+// @Param code query string true "Authorization code"
+// @Param state query string true "Login state"
+// @Success 200 {object} Message "Successfully signed in or identity linked"
+// @Success 202 {object} loginChallenge "A second factor is required"
+// @Failure 400 {object} apierror.Error "Login flow is missing, expired or doesn't match, or the login from the provider breaks the login policy (oidc_flow_invalid, validation_failed)"
+// @Failure 401 {object} apierror.Error "Identity provider denied the login (oidc_denied)"
+// @Failure 403 {object} apierror.Error "No account is linked to this identity or the account is suspended (identity_not_linked, account_suspended)"
+// @Failure 409 {object} apierror.Error "Login is already taken or the identity is linked to another account (login_taken, identity_linked)"
+// @Failure 502 {object} apierror.Error "Identity provider is unavailable (oidc_unavailable)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /user/oidc/callback [get]
+func (h *Handler) OIDCCallback(ctx *gin.Context) {
+	flow, err := cookie.GetOIDCFlow(ctx.Request)
+	if err != nil {
+		apierror.Abort(ctx, apierror.OIDCFlowInvalid)
+		return
+	}
+	cookie.ClearOIDCFlow(ctx.Writer)
+
+	if ctx.Query("error") != "" {
+		apierror.Abort(ctx, apierror.OIDCDenied)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(ctx.Query("state")), []byte(flow.State)) != 1 || ctx.Query("code") == "" {
+		apierror.Abort(ctx, apierror.OIDCFlowInvalid.WithMessage("Login flow doesn't match"))
+		return
+	}
+
+	identity, err := h.oidc.Exchange(ctx, ctx.Query("code"), flow.Verifier, flow.Nonce)
+	switch {
+	case errors.Is(err, oidc.ErrInvalidToken):
+		logger.SecurityLogger("Invalid ID token", fmt.Sprintf("%v, from %s", err, ctx.ClientIP()))
+		apierror.Abort(ctx, apierror.OIDCDenied)
+		return
+	case err != nil:
+		logger.ErrorLogger("Error reaching identity provider: ", err)
+		apierror.Abort(ctx, apierror.OIDCUnavailable)
+		return
+	}
+
+	account, err := h.s.GetOIDCAccount(ctx, identity.Issuer, identity.Subject)
+	switch {
+	case errors.Is(err, psql.ErrNoRows):
+		h.unknownIdentity(ctx, identity)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+
+	h.logIn(ctx, account)
+}
+
+// unknownIdentity handles an identity not linked to any account. If the caller
+// is logged in, the identity is linked to the caller's account. Otherwise, an account
+// is created if config.ReadyConfig.OIDCAutoCreate is set and the login is refused if not.
+func (h *Handler) unknownIdentity(ctx *gin.Context, identity oidc.Identity) {
+	if account, _, err := h.sessions.Authenticate(ctx.Writer, ctx.Request); err == nil {
+		err = h.s.LinkOIDCIdentity(ctx, account.ID, identity.Issuer, identity.Subject)
+		switch {
+		case errors.Is(err, psql.ErrIdentityLinked):
+			apierror.Abort(ctx, apierror.IdentityLinked)
+			return
+		case err != nil:
+			apierror.Abort(ctx, apierror.Internal)
+			return
+		}
+		logger.SecurityLogger("Identity linked", fmt.Sprintf("%s identity %s linked to user %d (%s) from %s", identity.Issuer, identity.Subject, account.ID, account.Login, ctx.ClientIP()))
+		ctx.JSON(http.StatusOK, newMessage("Identity linked"))
+		return
+	}
+
+	if !config.ReadyConfig.OIDCAutoCreate {
+		apierror.Abort(ctx, apierror.IdentityNotLinked)
+		return
+	}
+
+	login := identity.PreferredUsername
+	if login == "" {
+		login = identity.Email
+	}
+	login = validitycheck.NormalizeLogin(login)
+	if fields := h.policy.ValidateLogin("login", login); len(fields) > 0 {
+		apierror.AbortValidation(ctx, fields)
+		return
+	}
+
+	account, err := h.s.CreateOIDCAccount(ctx, login, identity.Issuer, identity.Subject)
+	switch {
+	case errors.Is(err, psql.ErrLoginTaken):
+		apierror.Abort(ctx, apierror.LoginTaken)
+		return
+	case errors.Is(err, psql.ErrIdentityLinked):
+		apierror.Abort(ctx, apierror.IdentityLinked)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+	logger.SecurityLogger("Account created", fmt.Sprintf("user %d (%s) created for %s identity %s from %s", account.ID, account.Login, identity.Issuer, identity.Subject, ctx.ClientIP()))
+
+	h.logIn(ctx, account)
+}
@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/knstch/gophermart/cmd/config"
+	"github.com/knstch/gophermart/internal/app/apierror"
+	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/knstch/gophermart/internal/app/storage/psql"
+	validitycheck "github.com/knstch/gophermart/internal/app/validityCheck"
+)
+
+// @Summary Change password
+// @Tags Auth
+// @Description Changes the password of the authenticated user, revokes all sessions and sets a new auth cookie.
+// @Description Wrong current passwords count as failed logins of the account
+// @Accept json
+// @Produce json
+// @Param passwords body changePasswordRequest true "Current and new passwords"
+// @Success 200 {object} Message "Password successfully changed"
+// @Failure 400 {object} apierror.Error "Wrong request or a new password breaking the validation policy (bad_request, validation_failed)"
+// @Failure 401 {object} apierror.Error "Wrong current password (wrong_password)"
+// @Failure 429 {object} apierror.Error "Too many attempts (too_many_attempts)"
+// @Header 429 {integer} Retry-After "Seconds until the next attempt is allowed"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /user/password [post]
+func (h *Handler) ChangePassword(ctx *gin.Context) {
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+
+	var passwords changePasswordRequest
+
+	decoder := json.NewDecoder(ctx.Request.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&passwords)
+	if err != nil || passwords.CurrentPassword == "" || passwords.NewPassword == "" {
+		apierror.Abort(ctx, apierror.BadRequest)
+		return
+	}
+
+	if fieldErrors := h.policy.ValidatePassword("new_password", passwords.NewPassword); len(fieldErrors) > 0 {
+		apierror.AbortValidation(ctx, fieldErrors)
+		return
+	}
+
+	clientIP := ctx.ClientIP()
+
+	if retryAfter := h.guard.Check(user.Login, clientIP); retryAfter > 0 {
+		abortTooManyAttempts(ctx, retryAfter)
+		return
+	}
+
+	account, err := h.s.CheckCredentials(ctx, user.Login, passwords.CurrentPassword)
+	switch {
+	case errors.Is(err, psql.ErrWrongCredentials):
+		h.rejectLogin(ctx, user.Login, clientIP, apierror.WrongPassword.WithMessage("Wrong current password"))
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+	h.guard.Succeed(user.Login)
+
+	account.SessionVersion, err = h.s.ChangePassword(ctx, user.UserID, passwords.NewPassword)
+	if err != nil {
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+	logger.SecurityLogger("Password changed", "password of "+user.Login+" changed, all sessions revoked")
+
+	err = h.startSession(ctx, account)
+	if err != nil {
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, newMessage("Password successfully changed"))
+}
+
+// @Summary Request password reset
+// @Tags Auth
+// @Description Sends a single-use password reset token to the user if the account exists
+// @Accept json
+// @Produce json
+// @Param resetData body passwordResetRequest true "Login"
+// @Success 202 {object} Message "Reset token is sent if the account exists"
+// @Failure 400 {object} apierror.Error "Wrong request (bad_request)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /user/password/reset [post]
+func (h *Handler) RequestPasswordReset(ctx *gin.Context) {
+	var resetRequest passwordResetRequest
+
+	decoder := json.NewDecoder(ctx.Request.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&resetRequest)
+	if err != nil || resetRequest.Login == "" {
+		apierror.Abort(ctx, apierror.BadRequest)
+		return
+	}
+
+	token, err := newResetToken()
+	if err != nil {
+		logger.ErrorLogger("Can't generate reset token: ", err)
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+
+	expiresAt := time.Now().Add(config.ReadyConfig.ResetTokenTTL)
+
+	login, err := h.s.CreateResetToken(ctx, validitycheck.NormalizeLogin(resetRequest.Login), token, expiresAt)
+	switch {
+	case errors.Is(err, psql.ErrNoRows):
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	default:
+		logger.SecurityLogger("Password reset requested", "reset token issued for "+login+" from "+ctx.ClientIP())
+		if err := h.notifier.SendPasswordReset(ctx, login, token, expiresAt); err != nil {
+			logger.ErrorLogger("Can't send reset token: ", err)
+		}
+	}
+
+	ctx.JSON(http.StatusAccepted, newMessage("If the account exists, a reset token has been sent"))
+}
+
+// @Summary Confirm password reset
+// @Tags Auth
+// @Description Sets a new password using a single-use reset token and revokes all sessions
+// @Accept json
+// @Produce json
+// @Param resetData body passwordResetConfirmRequest true "Reset token and new password"
+// @Success 200 {object} Message "Password successfully reset"
+// @Failure 400 {object} apierror.Error "Wrong request or a new password breaking the validation policy (bad_request, validation_failed)"
+// @Failure 401 {object} apierror.Error "Reset token is invalid or expired (reset_token_invalid)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /user/password/reset/confirm [post]
+func (h *Handler) ConfirmPasswordReset(ctx *gin.Context) {
+	var confirmRequest passwordResetConfirmRequest
+
+	decoder := json.NewDecoder(ctx.Request.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&confirmRequest)
+	if err != nil || confirmRequest.Token == "" || confirmRequest.NewPassword == "" {
+		apierror.Abort(ctx, apierror.BadRequest)
+		return
+	}
+
+	if fieldErrors := h.policy.ValidatePassword("new_password", confirmRequest.NewPassword); len(fieldErrors) > 0 {
+		apierror.AbortValidation(ctx, fieldErrors)
+		return
+	}
+
+	login, err := h.s.ResetPassword(ctx, confirmRequest.Token, confirmRequest.NewPassword)
+	switch {
+	case errors.Is(err, psql.ErrInvalidResetToken):
+		apierror.Abort(ctx, apierror.ResetTokenInvalid)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+	logger.SecurityLogger("Password reset", "password of "+login+" reset from "+ctx.ClientIP()+", all sessions revoked")
+
+	ctx.JSON(http.StatusOK, newMessage("Password successfully reset"))
+}
+
+// newResetToken returns a random hex encoded password reset token.
+func newResetToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/knstch/gophermart/internal/app/apierror"
+	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/knstch/gophermart/internal/app/storage/psql"
+	validitycheck "github.com/knstch/gophermart/internal/app/validityCheck"
+)
+
+// @Summary Update profile
+// @Tags Auth
+// @Description Changes the login of the authenticated user. Orders, balance and sessions are kept
+// @Accept json
+// @Produce json
+// @Param profile body updateProfileRequest true "New login"
+// @Success 200 {object} Message "Profile successfully updated"
+// @Failure 400 {object} apierror.Error "Wrong request or a login breaking the validation policy (bad_request, validation_failed)"
+// @Failure 401 {object} apierror.Error "You are not authenticated (unauthenticated)"
+// @Failure 409 {object} apierror.Error "Login is already taken (login_taken)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /user/profile [patch]
+func (h *Handler) UpdateProfile(ctx *gin.Context) {
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+
+	var profile updateProfileRequest
+
+	decoder := json.NewDecoder(ctx.Request.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&profile)
+	if err != nil || profile.Login == "" {
+		apierror.Abort(ctx, apierror.BadRequest)
+		return
+	}
+
+	login := validitycheck.NormalizeLogin(profile.Login)
+
+	if fieldErrors := h.policy.ValidateLogin("login", login); len(fieldErrors) > 0 {
+		apierror.AbortValidation(ctx, fieldErrors)
+		return
+	}
+
+	var constraintErr *pgconn.PgError
+
+	err = h.s.ChangeLogin(ctx, user.UserID, login)
+	switch {
+	case errors.Is(err, psql.ErrLoginTaken),
+		errors.As(err, &constraintErr) && pgerrcode.IsIntegrityConstraintViolation(constraintErr.Code):
+		apierror.Abort(ctx, apierror.LoginTaken)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+	logger.SecurityLogger("Login changed", "login of "+user.Login+" changed to "+login)
+
+	ctx.JSON(http.StatusOK, newMessage("Profile successfully updated"))
+}
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/pagination"
+	validitycheck "github.com/knstch/gophermart/internal/app/validityCheck"
+)
+
+// A header the sum of all withdrawals matching the filters of a request is returned in.
+const totalSumHeader = "X-Total-Sum"
+
+// parsePage reads the limit, cursor and sort query parameters of a list request.
+// It returns the requested page and the parameters which are not valid. All items
+// are requested if neither the limit nor the cursor is set, so clients written before
+// lists were paged keep getting full lists.
+func parsePage(ctx *gin.Context) (pagination.Page, []validitycheck.FieldError) {
+	var page pagination.Page
+	var fields []validitycheck.FieldError
+
+	if !unpaged(ctx) {
+		limit, err := pagination.ParseLimit(ctx.Query("limit"))
+		if err != nil {
+			fields = append(fields, validitycheck.FieldError{Field: "limit", Message: fmt.Sprintf("must be a number from 1 to %d", pagination.MaxLimit)})
+		}
+		page.Limit = limit
+	}
+
+	if value := ctx.Query("cursor"); value != "" {
+		cursor, err := pagination.ParseCursor(value)
+		if err != nil {
+			fields = append(fields, validitycheck.FieldError{Field: "cursor", Message: "is not valid"})
+		}
+		page.After = &cursor
+	}
+
+	switch ctx.DefaultQuery("sort", pagination.Asc) {
+	case pagination.Asc:
+	case pagination.Desc:
+		page.Desc = true
+	default:
+		fields = append(fields, validitycheck.FieldError{Field: "sort", Message: "must be asc or desc"})
+	}
+
+	return page, fields
+}
+
+// unpaged reports whether a list request has neither the limit nor the cursor.
+func unpaged(ctx *gin.Context) bool {
+	return ctx.Query("limit") == "" && ctx.Query("cursor") == ""
+}
+
+// parseTimeRange reads the from and to query parameters in RFC 3339 format.
+// It returns nil for a parameter which is not set and the parameters which are not valid.
+func parseTimeRange(ctx *gin.Context) (*time.Time, *time.Time, []validitycheck.FieldError) {
+	var fields []validitycheck.FieldError
+
+	parse := func(field string) *time.Time {
+		value := ctx.Query(field)
+		if value == "" {
+			return nil
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			fields = append(fields, validitycheck.FieldError{Field: field, Message: "must be a time in RFC 3339 format"})
+			return nil
+		}
+		return &t
+	}
+
+	from, to := parse("from"), parse("to")
+	if from != nil && to != nil && !from.Before(*to) {
+		fields = append(fields, validitycheck.FieldError{Field: "to", Message: "must be after from"})
+	}
+	return from, to, fields
+}
+
+// parseOrdersQuery reads the page, status and time range query parameters of an orders request.
+// Statuses are passed comma-separated or in several status parameters.
+func parseOrdersQuery(ctx *gin.Context) (common.OrdersQuery, []validitycheck.FieldError) {
+	page, fields := parsePage(ctx)
+	query := common.OrdersQuery{Page: page}
+
+	for _, value := range ctx.QueryArray("status") {
+		for _, status := range strings.Split(value, ",") {
+			query.Statuses = append(query.Statuses, strings.ToUpper(strings.TrimSpace(status)))
+		}
+	}
+	for _, status := range query.Statuses {
+		if !common.Contains(common.KnownOrderStatuses, status) {
+			fields = append(fields, validitycheck.FieldError{Field: "status", Message: "must be one of " + strings.Join(common.KnownOrderStatuses, ", ")})
+			break
+		}
+	}
+
+	from, to, rangeFields := parseTimeRange(ctx)
+	query.From, query.To = from, to
+	fields = append(fields, rangeFields...)
+
+	return query, fields
+}
+
+// parseWithdrawalsQuery reads the page, time range and sum range query parameters
+// of a withdrawals request.
+func parseWithdrawalsQuery(ctx *gin.Context) (common.WithdrawalsQuery, []validitycheck.FieldError) {
+	page, fields := parsePage(ctx)
+	query := common.WithdrawalsQuery{Page: page}
+
+	from, to, rangeFields := parseTimeRange(ctx)
+	query.From, query.To = from, to
+	fields = append(fields, rangeFields...)
+
+	parse := func(field string) *float32 {
+		value := ctx.Query(field)
+		if value == "" {
+			return nil
+		}
+		sum, err := strconv.ParseFloat(value, 32)
+		if err != nil || sum < 0 {
+			fields = append(fields, validitycheck.FieldError{Field: field, Message: "must be a non-negative number"})
+			return nil
+		}
+		result := float32(sum)
+		return &result
+	}
+
+	query.MinSum, query.MaxSum = parse("min_sum"), parse("max_sum")
+	if query.MinSum != nil && query.MaxSum != nil && *query.MinSum > *query.MaxSum {
+		fields = append(fields, validitycheck.FieldError{Field: "max_sum", Message: "must not be less than min_sum"})
+	}
+
+	return query, fields
+}
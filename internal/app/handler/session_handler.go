@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/knstch/gophermart/cmd/config"
+	"github.com/knstch/gophermart/internal/app/apierror"
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/cookie"
+	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/knstch/gophermart/internal/app/storage/psql"
+)
+
+// @Summary Get sessions
+// @Tags Auth
+// @Description Retrieves active sessions of the authenticated user, the session of the request is marked as current
+// @Produce json
+// @Success 200 {array} common.Session "A list of sessions"
+// @Failure 401 {object} apierror.Error "You are not authenticated (unauthenticated)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /user/sessions [get]
+func (h *Handler) GetSessions(ctx *gin.Context) {
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+
+	sessions, err := h.s.GetSessions(ctx, user.UserID)
+	if err != nil {
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == user.TokenID
+	}
+
+	ctx.JSON(http.StatusOK, sessions)
+}
+
+// @Summary Revoke session
+// @Tags Auth
+// @Description Ends a session of the authenticated user, other sessions stay active
+// @Produce json
+// @Param id path string true "Session ID"
+// @Success 200 {object} Message "Session revoked"
+// @Failure 401 {object} apierror.Error "You are not authenticated (unauthenticated)"
+// @Failure 404 {object} apierror.Error "Session not found (session_not_found)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /user/sessions/{id} [delete]
+func (h *Handler) RevokeSession(ctx *gin.Context) {
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+
+	err := h.s.RevokeSession(ctx, user.UserID, ctx.Param("id"))
+	switch {
+	case errors.Is(err, psql.ErrNoRows):
+		apierror.Abort(ctx, apierror.SessionNotFound)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+	logger.SecurityLogger("Session revoked", "session "+ctx.Param("id")+" of "+user.Login+" revoked from "+ctx.ClientIP())
+
+	ctx.JSON(http.StatusOK, newMessage("Session revoked"))
+}
+
+// startSession records a new session of the account and sets an auth cookie
+// belonging to it with the session manager in use. Sessions of accounts with two-factor
+// authentication are marked as logged in with a second factor, as the login can't be
+// finished without one. It returns an error if the session can't be started.
+func (h *Handler) startSession(ctx *gin.Context, account common.Account) error {
+	sessionID, err := cookie.NewSessionID()
+	if err != nil {
+		logger.ErrorLogger("Error generating session ID: ", err)
+		return err
+	}
+
+	session := common.Session{
+		ID:             sessionID,
+		UserID:         account.ID,
+		SessionVersion: account.SessionVersion,
+		UserAgent:      ctx.Request.UserAgent(),
+		ClientIP:       ctx.ClientIP(),
+		CreatedAt:      time.Now(),
+		SecondFactor:   account.TOTPEnabled,
+	}
+	session.LastUsedAt = session.CreatedAt
+	if config.ReadyConfig.SessionTTL > 0 {
+		expiresAt := session.CreatedAt.Add(config.ReadyConfig.SessionTTL)
+		session.ExpiresAt = &expiresAt
+	}
+
+	err = h.s.CreateSession(ctx, session)
+	if err != nil {
+		return err
+	}
+
+	err = h.sessions.Start(ctx.Writer, account, session)
+	if err != nil {
+		logger.ErrorLogger("Can't set cookie: ", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,331 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/knstch/gophermart/internal/app/apierror"
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/cookie"
+	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/knstch/gophermart/internal/app/storage/psql"
+	"github.com/knstch/gophermart/internal/app/totp"
+)
+
+// @Summary Finish login with a second factor
+// @Tags Auth
+// @Description Finishes a login of an account with two-factor authentication and sets an auth cookie.
+// @Description The code is either a code from an authenticator app or one of the recovery codes.
+// @Accept json
+// @Produce json
+// @Param request body secondFactorRequest true "Login challenge and code"
+// @Success 200 {object} Message "Successfully signed in"
+// @Failure 400 {object} apierror.Error "Wrong request (bad_request)"
+// @Failure 401 {object} apierror.Error "Challenge is invalid or expired, or wrong code (challenge_invalid, wrong_code)"
+// @Failure 403 {object} apierror.Error "Account is suspended (account_suspended)"
+// @Failure 429 {object} apierror.Error "Too many login attempts (too_many_attempts)"
+// @Header 429 {integer} Retry-After "Seconds until the next attempt is allowed"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /user/login/2fa [post]
+func (h *Handler) LoginSecondFactor(ctx *gin.Context) {
+	var request secondFactorRequest
+
+	decoder := json.NewDecoder(ctx.Request.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&request)
+	if err != nil || request.Challenge == "" || request.Code == "" {
+		apierror.Abort(ctx, apierror.BadRequest)
+		return
+	}
+
+	userID, err := cookie.ParseChallenge(request.Challenge)
+	if err != nil {
+		apierror.Abort(ctx, apierror.ChallengeInvalid)
+		return
+	}
+
+	account, twoFactor, err := h.s.GetTwoFactor(ctx, userID)
+	switch {
+	case errors.Is(err, psql.ErrNoRows):
+		apierror.Abort(ctx, apierror.ChallengeInvalid)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+	if !twoFactor.Enabled {
+		apierror.Abort(ctx, apierror.ChallengeInvalid)
+		return
+	}
+	if account.State == common.AccountSuspended {
+		abortSuspended(ctx, account)
+		return
+	}
+
+	clientIP := ctx.ClientIP()
+
+	if retryAfter := h.guard.Check(account.Login, clientIP); retryAfter > 0 {
+		abortTooManyAttempts(ctx, retryAfter)
+		return
+	}
+
+	err = h.checkSecondFactor(ctx, account.ID, twoFactor.Secret, request.Code)
+	switch {
+	case errors.Is(err, psql.ErrInvalidCode):
+		logger.SecurityLogger("Wrong second factor", fmt.Sprintf("user %d (%s) entered a wrong code from %s", account.ID, account.Login, clientIP))
+		h.rejectLogin(ctx, account.Login, clientIP, apierror.WrongCode)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+	h.guard.Succeed(account.Login)
+
+	err = h.startSession(ctx, account)
+	if err != nil {
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, newMessage("Successfully signed in"))
+}
+
+// @Summary Start two-factor enrolment
+// @Tags Two-factor authentication
+// @Description Generates a TOTP secret for the authenticated user. Two-factor authentication
+// @Description is enabled after a code from an authenticator app is confirmed at /user/2fa/confirm
+// @Produce json
+// @Success 200 {object} twoFactorEnrolment "TOTP secret and otpauth URI"
+// @Failure 401 {object} apierror.Error "You are not authenticated (unauthenticated)"
+// @Failure 409 {object} apierror.Error "Two-factor authentication is already enabled (two_factor_enabled)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /user/2fa [post]
+func (h *Handler) StartTwoFactor(ctx *gin.Context) {
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		logger.ErrorLogger("Error generating TOTP secret: ", err)
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+
+	err = h.s.StartTwoFactor(ctx, user.UserID, secret)
+	switch {
+	case errors.Is(err, psql.ErrNoRows):
+		apierror.Abort(ctx, apierror.TwoFactorEnabled)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, twoFactorEnrolment{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, user.Login, secret),
+	})
+}
+
+// @Summary Confirm two-factor enrolment
+// @Tags Two-factor authentication
+// @Description Enables two-factor authentication if the code matches the secret of the started enrolment
+// @Description and returns recovery codes. The recovery codes are shown only once
+// @Accept json
+// @Produce json
+// @Param request body twoFactorCodeRequest true "Code from an authenticator app"
+// @Success 200 {object} recoveryCodes "Recovery codes"
+// @Failure 400 {object} apierror.Error "Wrong request, wrong code or the enrolment is not started (bad_request, invalid_code, two_factor_not_started)"
+// @Failure 401 {object} apierror.Error "You are not authenticated (unauthenticated)"
+// @Failure 409 {object} apierror.Error "Two-factor authentication is already enabled (two_factor_enabled)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /user/2fa/confirm [post]
+func (h *Handler) ConfirmTwoFactor(ctx *gin.Context) {
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+
+	var request twoFactorCodeRequest
+
+	decoder := json.NewDecoder(ctx.Request.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&request)
+	if err != nil || request.Code == "" {
+		apierror.Abort(ctx, apierror.BadRequest)
+		return
+	}
+
+	_, twoFactor, err := h.s.GetTwoFactor(ctx, user.UserID)
+	switch {
+	case errors.Is(err, psql.ErrNoRows):
+		apierror.Abort(ctx, apierror.Unauthenticated)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	case twoFactor.Enabled:
+		apierror.Abort(ctx, apierror.TwoFactorEnabled)
+		return
+	case twoFactor.Secret == "":
+		apierror.Abort(ctx, apierror.TwoFactorNotStarted)
+		return
+	}
+
+	counter, ok := totp.Validate(twoFactor.Secret, normalizeCode(request.Code), time.Now(), 1)
+	if !ok {
+		apierror.Abort(ctx, apierror.InvalidCode)
+		return
+	}
+
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		logger.ErrorLogger("Error generating recovery codes: ", err)
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+	stored := make([]string, 0, len(codes))
+	for _, code := range codes {
+		stored = append(stored, normalizeCode(code))
+	}
+
+	err = h.s.EnableTwoFactor(ctx, user.UserID, counter, stored)
+	switch {
+	case errors.Is(err, psql.ErrNoRows):
+		apierror.Abort(ctx, apierror.TwoFactorEnabled)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+	logger.SecurityLogger("Two-factor authentication enabled", fmt.Sprintf("user %d (%s) enabled two-factor authentication from %s", user.UserID, user.Login, ctx.ClientIP()))
+
+	ctx.JSON(http.StatusOK, recoveryCodes{Codes: codes})
+}
+
+// @Summary Disable two-factor authentication
+// @Tags Two-factor authentication
+// @Description Disables two-factor authentication of the authenticated user. It requires the password
+// @Description and a code from an authenticator app or a recovery code. Wrong passwords and codes
+// @Description count as failed logins of the account
+// @Accept json
+// @Produce json
+// @Param request body disableTwoFactorRequest true "Current password and code"
+// @Success 200 {object} Message "Two-factor authentication disabled"
+// @Failure 400 {object} apierror.Error "Wrong request (bad_request)"
+// @Failure 401 {object} apierror.Error "Wrong password or code (wrong_password, wrong_code)"
+// @Failure 409 {object} apierror.Error "Two-factor authentication is not enabled (two_factor_not_enabled)"
+// @Failure 429 {object} apierror.Error "Too many attempts (too_many_attempts)"
+// @Header 429 {integer} Retry-After "Seconds until the next attempt is allowed"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /user/2fa [delete]
+func (h *Handler) DisableTwoFactor(ctx *gin.Context) {
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+
+	var request disableTwoFactorRequest
+
+	decoder := json.NewDecoder(ctx.Request.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&request)
+	if err != nil || request.Password == "" || request.Code == "" {
+		apierror.Abort(ctx, apierror.BadRequest)
+		return
+	}
+
+	clientIP := ctx.ClientIP()
+
+	if retryAfter := h.guard.Check(user.Login, clientIP); retryAfter > 0 {
+		abortTooManyAttempts(ctx, retryAfter)
+		return
+	}
+
+	_, err = h.s.CheckCredentials(ctx, user.Login, request.Password)
+	switch {
+	case errors.Is(err, psql.ErrWrongCredentials):
+		h.rejectLogin(ctx, user.Login, clientIP, apierror.WrongPassword)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+
+	_, twoFactor, err := h.s.GetTwoFactor(ctx, user.UserID)
+	switch {
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	case !twoFactor.Enabled:
+		apierror.Abort(ctx, apierror.TwoFactorNotEnabled)
+		return
+	}
+
+	err = h.checkSecondFactor(ctx, user.UserID, twoFactor.Secret, request.Code)
+	switch {
+	case errors.Is(err, psql.ErrInvalidCode):
+		logger.SecurityLogger("Wrong second factor", fmt.Sprintf("user %d (%s) entered a wrong code to disable two-factor authentication from %s", user.UserID, user.Login, clientIP))
+		h.rejectLogin(ctx, user.Login, clientIP, apierror.WrongCode)
+		return
+	case err != nil:
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+	h.guard.Succeed(user.Login)
+
+	err = h.s.DisableTwoFactor(ctx, user.UserID)
+	if err != nil {
+		apierror.Abort(ctx, apierror.Internal)
+		return
+	}
+	logger.SecurityLogger("Two-factor authentication disabled", fmt.Sprintf("user %d (%s) disabled two-factor authentication from %s", user.UserID, user.Login, clientIP))
+
+	ctx.JSON(http.StatusOK, newMessage("Two-factor authentication disabled"))
+}
+
+// checkSecondFactor accepts user ID, the user's TOTP secret and a code entered by the user.
+// A code of totp.Digits digits is checked as a TOTP code, any other code as a recovery code.
+// The code is used up, so it doesn't work again. It returns psql.ErrInvalidCode
+// if the code is wrong or already used.
+func (h *Handler) checkSecondFactor(ctx *gin.Context, userID int64, secret string, code string) error {
+	code = normalizeCode(code)
+	if len(code) == totp.Digits {
+		counter, ok := totp.Validate(secret, code, time.Now(), 1)
+		if !ok {
+			return psql.ErrInvalidCode
+		}
+		return h.s.UseTOTPCode(ctx, userID, counter)
+	}
+	return h.s.UseRecoveryCode(ctx, userID, code)
+}
+
+// normalizeCode removes spaces and dashes from a code and lowercases it,
+// so codes are accepted the way they are shown or grouped by authenticator apps.
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+// newRecoveryCodes returns recoveryCodesCount random recovery codes
+// formatted as two groups of five hexadecimal characters.
+func newRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+	return codes, nil
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/knstch/gophermart/internal/app/apierror"
+	validitycheck "github.com/knstch/gophermart/internal/app/validityCheck"
+)
+
+// @Summary Upload order (v2)
+// @Tags Order
+// @Description Uploads an order to the server. Unlike v1, the number is sent in a JSON body
+// @Description and spaces around it are trimmed
+// @Accept json
+// @Produce json
+// @Param order body uploadOrderRequest true "Order number"
+// @Param Idempotency-Key header string false "Key making a retry get the response to the first request"
+// @Success 200 {object} Message "Order was successfully loaded before"
+// @Success 202 {object} Message "Order was successfully accepted"
+// @Failure 400 {object} apierror.Error "Wrong request or no order number (bad_request, validation_failed)"
+// @Failure 409 {object} apierror.Error "Order is loaded by another user or a request with the idempotency key is in progress (order_owned_by_another_user, idempotency_key_in_progress)"
+// @Failure 415 {object} apierror.Error "Content type is not application/json (unsupported_media_type)"
+// @Failure 422 {object} apierror.Error "Wrong order number or the idempotency key is used with a different request (order_invalid, idempotency_key_mismatch)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /v2/user/orders [post]
+func (h *Handler) UploadOrderV2(ctx *gin.Context) {
+	var uploadRequest uploadOrderRequest
+
+	decoder := json.NewDecoder(ctx.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&uploadRequest); err != nil {
+		apierror.Abort(ctx, apierror.BadRequest)
+		return
+	}
+
+	orderNum, ok := parseOrderNumber(ctx, uploadRequest.Order)
+	if !ok {
+		return
+	}
+
+	h.uploadOrder(ctx, orderNum)
+}
+
+// @Summary Withdraw user's bonuses (v2)
+// @Description Allows users to spend their bonuses. Unlike v1, unknown fields are rejected,
+// @Description spaces around the order number are trimmed and the sum must be positive
+// @Tags Balance
+// @Accept json
+// @Produce json
+// @Param orderData body getSpendBonusRequest true "Order number and withdraw amount"
+// @Param Idempotency-Key header string false "Key making a retry get the response to the first request"
+// @Success 200 {object} Message "Bonuses successfully spent"
+// @Failure 400 {object} apierror.Error "Wrong request, no order number or a sum that isn't positive (bad_request, validation_failed)"
+// @Failure 402 {object} apierror.Error "Not enough balance (not_enough_balance)"
+// @Failure 403 {object} apierror.Error "Account is frozen (account_frozen)"
+// @Failure 409 {object} apierror.Error "Order is already loaded or a request with the idempotency key is in progress (order_already_loaded, idempotency_key_in_progress)"
+// @Failure 415 {object} apierror.Error "Content type is not application/json (unsupported_media_type)"
+// @Failure 422 {object} apierror.Error "Wrong order number or the idempotency key is used with a different request (order_invalid, idempotency_key_mismatch)"
+// @Failure 500 {object} apierror.Error "Internal Server Error (internal_error)"
+// @Router /v2/user/balance/withdraw [post]
+func (h *Handler) WithdrawBonusesV2(ctx *gin.Context) {
+	var spendRequest getSpendBonusRequest
+
+	decoder := json.NewDecoder(ctx.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&spendRequest); err != nil {
+		apierror.Abort(ctx, apierror.BadRequest)
+		return
+	}
+
+	if spendRequest.Sum <= 0 {
+		apierror.AbortValidation(ctx, []validitycheck.FieldError{{Field: "sum", Message: "must be positive"}})
+		return
+	}
+
+	orderNum, ok := parseOrderNumber(ctx, spendRequest.Order)
+	if !ok {
+		return
+	}
+	spendRequest.Order = orderNum
+
+	h.withdrawBonuses(ctx, spendRequest)
+}
+
+// parseOrderNumber trims spaces around an order number sent in a v2 request. It aborts
+// the request with validation_failed if the number is empty and with order_invalid
+// if it has anything but digits.
+func parseOrderNumber(ctx *gin.Context, orderNum string) (string, bool) {
+	orderNum = strings.TrimSpace(orderNum)
+	if orderNum == "" {
+		apierror.AbortValidation(ctx, []validitycheck.FieldError{{Field: "order", Message: "must not be empty"}})
+		return "", false
+	}
+	if !isDigits(orderNum) {
+		apierror.Abort(ctx, apierror.OrderInvalid)
+		return "", false
+	}
+	return orderNum, true
+}
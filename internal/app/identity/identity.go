@@ -0,0 +1,40 @@
+// Package identity describes an authenticated caller kept inside of a request context.
+package identity
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A key an Identity is stored by in a gin context.
+const contextKey = "identity"
+
+// A struct describing an authenticated caller. Callers using an API key
+// have a non-zero APIKeyID and are limited to the key scopes. SecondFactor
+// is set for sessions logged in with two-factor authentication. LoggedInAt is
+// the time the session was started, it is zero for callers using an API key.
+type Identity struct {
+	UserID       int64
+	Login        string
+	Roles        []string
+	TokenID      string
+	APIKeyID     int64
+	Scopes       []string
+	SecondFactor bool
+	LoggedInAt   time.Time
+}
+
+// Set puts an identity inside of a gin context.
+func Set(ctx *gin.Context, id Identity) {
+	ctx.Set(contextKey, id)
+}
+
+// From returns an identity stored in a context and true,
+// or an empty identity and false if the caller is not authenticated.
+// It accepts *gin.Context as well as any other context.Context.
+func From(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(contextKey).(Identity)
+	return id, ok
+}
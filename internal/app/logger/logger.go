@@ -24,3 +24,14 @@ func InfoLogger(msg string) {
 	defer logger.Sync()
 	sugar.Infof(msg)
 }
+
+// A security logger accepting an event name and its details.
+func SecurityLogger(event string, details string) {
+	var logger, err = zap.NewDevelopment()
+	if err != nil {
+		panic(err)
+	}
+	var sugar = *logger.Sugar()
+	defer logger.Sync()
+	sugar.Warnf("Security event: %v\nDetails: %v\n", event, details)
+}
@@ -0,0 +1,170 @@
+// Package loginguard protects authentication from password guessing
+// by tracking failed attempts per account and per client address.
+package loginguard
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/knstch/gophermart/internal/app/logger"
+)
+
+// The longest delay applied to a failed attempt.
+const maxDelay = 5 * time.Second
+
+// How often stale attempt records are removed.
+const sweepInterval = time.Minute
+
+// A struct keeping failed attempts of a single account or client address.
+type attempt struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// A struct tracking failed logins and deciding when to slow down
+// or lock out an account or a client address.
+type Guard struct {
+	mu          sync.Mutex
+	attempts    map[string]*attempt
+	maxAttempts int
+	maxIPs      int
+	baseDelay   time.Duration
+	lockout     time.Duration
+	lastSweep   time.Time
+	now         func() time.Time
+}
+
+// A builder function returning a Guard. maxAttempts and maxIPAttempts are the
+// numbers of failed logins per account and per client address after which
+// the key is locked for the lockout duration, zero disables the lockout.
+// Every failure is delayed by baseDelay doubled for each previous failure.
+func NewGuard(maxAttempts int, maxIPAttempts int, baseDelay time.Duration, lockout time.Duration) *Guard {
+	return &Guard{
+		attempts:    make(map[string]*attempt),
+		maxAttempts: maxAttempts,
+		maxIPs:      maxIPAttempts,
+		baseDelay:   baseDelay,
+		lockout:     lockout,
+		now:         time.Now,
+	}
+}
+
+// Check accepts a login and a client address and returns how long
+// the caller has to wait before the next attempt. Zero means the attempt is allowed.
+func (g *Guard) Check(login string, clientIP string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	g.sweep(now)
+
+	return max(g.lockedFor(loginKey(login), now), g.lockedFor(ipKey(clientIP), now))
+}
+
+// Fail registers a failed attempt for a login and a client address.
+// It returns a delay to apply before answering and, if this attempt
+// has triggered a lockout, how long the lockout lasts.
+func (g *Guard) Fail(login string, clientIP string) (time.Duration, time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+
+	loginFailures, loginLocked := g.fail(loginKey(login), g.maxAttempts, now)
+	ipFailures, ipLocked := g.fail(ipKey(clientIP), g.maxIPs, now)
+
+	if loginLocked > 0 {
+		logger.SecurityLogger("Account locked out", fmt.Sprintf("login %q locked for %v after %d failed attempts, last from %s",
+			login, loginLocked, loginFailures, clientIP))
+	}
+	if ipLocked > 0 {
+		logger.SecurityLogger("Client address locked out", fmt.Sprintf("address %s locked for %v after %d failed attempts, last for login %q",
+			clientIP, ipLocked, ipFailures, login))
+	}
+
+	return g.delay(max(loginFailures, ipFailures)), max(loginLocked, ipLocked)
+}
+
+// Succeed forgets failed attempts of an account after a successful login.
+// Failures of the client address are kept, so logging into an own account
+// doesn't reset guessing from the same address.
+func (g *Guard) Succeed(login string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.attempts, loginKey(login))
+}
+
+// fail increments failures of a key and locks it when the limit is reached.
+// It returns the number of failures and the lockout duration if the key got locked.
+func (g *Guard) fail(key string, limit int, now time.Time) (int, time.Duration) {
+	a, ok := g.attempts[key]
+	if !ok || g.expired(a, now) {
+		a = &attempt{}
+		g.attempts[key] = a
+	}
+
+	a.failures++
+	a.lastFailure = now
+
+	if limit > 0 && a.failures >= limit {
+		failures := a.failures
+		a.failures = 0
+		a.lockedUntil = now.Add(g.lockout)
+		return failures, g.lockout
+	}
+
+	return a.failures, 0
+}
+
+// lockedFor returns the remaining lockout of a key.
+func (g *Guard) lockedFor(key string, now time.Time) time.Duration {
+	a, ok := g.attempts[key]
+	if !ok || !now.Before(a.lockedUntil) {
+		return 0
+	}
+	return a.lockedUntil.Sub(now)
+}
+
+// delay returns a progressive delay for the given number of failures.
+func (g *Guard) delay(failures int) time.Duration {
+	if failures <= 0 || g.baseDelay <= 0 {
+		return 0
+	}
+	delay := g.baseDelay
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
+
+// expired reports if a record is neither locked nor has recent failures.
+func (g *Guard) expired(a *attempt, now time.Time) bool {
+	return !now.Before(a.lockedUntil) && now.Sub(a.lastFailure) > g.lockout
+}
+
+// sweep removes expired records not more often than once per sweepInterval.
+func (g *Guard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < sweepInterval {
+		return
+	}
+	g.lastSweep = now
+	for key, a := range g.attempts {
+		if g.expired(a, now) {
+			delete(g.attempts, key)
+		}
+	}
+}
+
+func loginKey(login string) string {
+	return "login:" + login
+}
+
+func ipKey(clientIP string) string {
+	return "ip:" + clientIP
+}
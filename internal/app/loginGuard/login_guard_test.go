@@ -0,0 +1,52 @@
+package loginguard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuard(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g := NewGuard(3, 5, 100*time.Millisecond, time.Minute)
+	g.now = func() time.Time { return now }
+
+	t.Run("#1 progressive delays before the lockout", func(t *testing.T) {
+		delay, lockout := g.Fail("user", "10.0.0.1")
+		assert.Equal(t, 100*time.Millisecond, delay)
+		assert.Zero(t, lockout)
+
+		delay, lockout = g.Fail("user", "10.0.0.1")
+		assert.Equal(t, 200*time.Millisecond, delay)
+		assert.Zero(t, lockout)
+		assert.Zero(t, g.Check("user", "10.0.0.1"))
+	})
+
+	t.Run("#2 account is locked after the threshold", func(t *testing.T) {
+		_, lockout := g.Fail("user", "10.0.0.1")
+		assert.Equal(t, time.Minute, lockout)
+		assert.Equal(t, time.Minute, g.Check("user", "10.0.0.2"))
+		assert.Zero(t, g.Check("another", "10.0.0.2"))
+	})
+
+	t.Run("#3 lockout expires", func(t *testing.T) {
+		now = now.Add(time.Minute)
+		assert.Zero(t, g.Check("user", "10.0.0.1"))
+	})
+
+	t.Run("#4 client address is locked after the threshold", func(t *testing.T) {
+		g.Fail("first", "10.0.0.1")
+		_, lockout := g.Fail("second", "10.0.0.1")
+		assert.Equal(t, time.Minute, lockout)
+		assert.Equal(t, time.Minute, g.Check("third", "10.0.0.1"))
+	})
+
+	t.Run("#5 success resets account failures", func(t *testing.T) {
+		g.Fail("user", "10.0.0.3")
+		g.Fail("user", "10.0.0.3")
+		g.Succeed("user")
+		_, lockout := g.Fail("user", "10.0.0.3")
+		assert.Zero(t, lockout)
+	})
+}
@@ -0,0 +1,165 @@
+// Package apikeyauth provides a middleware authenticating partners by an API key.
+package apikeyauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/knstch/gophermart/internal/app/apierror"
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/identity"
+	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/knstch/gophermart/internal/app/storage/psql"
+)
+
+// A header an API key is passed in.
+const Header = "X-API-Key"
+
+// How often the last use of a key is written to the database.
+const touchInterval = time.Minute
+
+// An interface responsible for finding API keys and recording their usage.
+type Storage interface {
+	GetAPIKey(ctx context.Context, key string) (common.APIKey, error)
+	TouchAPIKey(ctx context.Context, id int64, usedAt time.Time) error
+}
+
+// A struct keeping the remaining requests of a key.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// A struct authenticating requests with an API key and limiting
+// the rate of requests per key.
+type Authenticator struct {
+	s                Storage
+	fallback         gin.HandlerFunc
+	defaultRateLimit int
+
+	mu      sync.Mutex
+	buckets map[int64]*bucket
+	touched map[int64]time.Time
+	now     func() time.Time
+}
+
+// A builder function returning an Authenticator. Requests without an API key
+// are passed to the fallback middleware. Keys without their own limit are allowed
+// defaultRateLimit requests per minute.
+func NewAuthenticator(s Storage, fallback gin.HandlerFunc, defaultRateLimit int) *Authenticator {
+	return &Authenticator{
+		s:                s,
+		fallback:         fallback,
+		defaultRateLimit: defaultRateLimit,
+		buckets:          make(map[int64]*bucket),
+		touched:          make(map[int64]time.Time),
+		now:              time.Now,
+	}
+}
+
+// WithScope returns a middleware accepting an API key with the scope.
+// If a request has the X-API-Key header, the key has to be valid, belong to an account
+// which is not suspended, have the scope and be within its rate limit, otherwise the chain
+// is stopped with 401, 403 or 429 status code. A request without the header is authenticated
+// by the fallback middleware.
+func (a *Authenticator) WithScope(scope string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.GetHeader(Header)
+		if key == "" {
+			a.fallback(ctx)
+			return
+		}
+
+		apiKey, err := a.s.GetAPIKey(ctx, key)
+		switch {
+		case errors.Is(err, psql.ErrNoRows):
+			logger.SecurityLogger("Invalid API key", "unknown or revoked API key used from "+ctx.ClientIP())
+			apierror.Abort(ctx, apierror.APIKeyInvalid)
+			return
+		case err != nil:
+			apierror.Abort(ctx, apierror.Internal)
+			return
+		}
+
+		if apiKey.AccountState == common.AccountSuspended {
+			logger.SecurityLogger("Suspended account used", fmt.Sprintf("API key %d of user %d (%s) used from %s", apiKey.ID, apiKey.UserID, apiKey.Login, ctx.ClientIP()))
+			apierror.Abort(ctx, apierror.AccountSuspended)
+			return
+		}
+
+		if !common.Contains(apiKey.Scopes, scope) {
+			apierror.Abort(ctx, apierror.APIKeyScopeMissing.WithMessage("API key doesn't have the "+scope+" scope"))
+			return
+		}
+
+		if retryAfter := a.take(apiKey); retryAfter > 0 {
+			ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			apierror.Abort(ctx, apierror.APIKeyRateLimited)
+			return
+		}
+
+		a.touch(ctx, apiKey.ID)
+
+		identity.Set(ctx, identity.Identity{
+			UserID:   apiKey.UserID,
+			Login:    apiKey.Login,
+			APIKeyID: apiKey.ID,
+			Scopes:   apiKey.Scopes,
+		})
+		ctx.Next()
+	}
+}
+
+// take spends one request from the key bucket. It returns zero if the request
+// is allowed or how long to wait until the next one is.
+func (a *Authenticator) take(apiKey common.APIKey) time.Duration {
+	limit := apiKey.RateLimit
+	if limit <= 0 {
+		limit = a.defaultRateLimit
+	}
+	if limit <= 0 {
+		return 0
+	}
+	perSecond := float64(limit) / 60
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	b, ok := a.buckets[apiKey.ID]
+	if !ok {
+		b = &bucket{tokens: float64(limit), updated: now}
+		a.buckets[apiKey.ID] = b
+	}
+
+	b.tokens = math.Min(float64(limit), b.tokens+now.Sub(b.updated).Seconds()*perSecond)
+	b.updated = now
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
+
+// touch records the last use of a key not more often than once per touchInterval.
+func (a *Authenticator) touch(ctx context.Context, id int64) {
+	a.mu.Lock()
+	now := a.now()
+	if now.Sub(a.touched[id]) < touchInterval {
+		a.mu.Unlock()
+		return
+	}
+	a.touched[id] = now
+	a.mu.Unlock()
+
+	if err := a.s.TouchAPIKey(ctx, id, now); err != nil {
+		logger.ErrorLogger("Can't record API key usage: ", err)
+	}
+}
@@ -0,0 +1,144 @@
+package apikeyauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/identity"
+	"github.com/knstch/gophermart/internal/app/storage/psql"
+	"github.com/stretchr/testify/assert"
+)
+
+type testStorage struct {
+	keys    map[string]common.APIKey
+	touched int
+}
+
+func (s *testStorage) GetAPIKey(ctx context.Context, key string) (common.APIKey, error) {
+	apiKey, ok := s.keys[key]
+	if !ok {
+		return common.APIKey{}, psql.ErrNoRows
+	}
+	return apiKey, nil
+}
+
+func (s *testStorage) TouchAPIKey(ctx context.Context, id int64, usedAt time.Time) error {
+	s.touched++
+	return nil
+}
+
+func TestWithScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	storage := &testStorage{keys: map[string]common.APIKey{
+		"gm_reader":    {ID: 1, UserID: 7, Login: "gopher", Scopes: []string{common.ScopeOrdersRead}, RateLimit: 2, AccountState: common.AccountActive},
+		"gm_suspended": {ID: 2, UserID: 8, Login: "mole", Scopes: []string{common.ScopeOrdersRead}, AccountState: common.AccountSuspended},
+	}}
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	fallback := func(ctx *gin.Context) {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "You are not authenticated"})
+	}
+	a := NewAuthenticator(storage, fallback, 60)
+	a.now = func() time.Time { return now }
+
+	var user identity.Identity
+	router := gin.New()
+	router.GET("/orders", a.WithScope(common.ScopeOrdersRead), func(ctx *gin.Context) {
+		user, _ = identity.From(ctx)
+		ctx.Status(http.StatusOK)
+	})
+	router.GET("/balance", a.WithScope(common.ScopeBalanceRead), func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		path       string
+		key        string
+		advance    time.Duration
+		statusCode int
+	}{
+		{
+			name:       "#1 no key falls back to the cookie",
+			path:       "/orders",
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "#2 unknown key",
+			path:       "/orders",
+			key:        "gm_unknown",
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "#3 key without the scope",
+			path:       "/balance",
+			key:        "gm_reader",
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "#4 valid key",
+			path:       "/orders",
+			key:        "gm_reader",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "#5 valid key within the limit",
+			path:       "/orders",
+			key:        "gm_reader",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "#6 rate limit exceeded",
+			path:       "/orders",
+			key:        "gm_reader",
+			statusCode: http.StatusTooManyRequests,
+		},
+		{
+			name:       "#7 rate limit refilled",
+			path:       "/orders",
+			key:        "gm_reader",
+			advance:    30 * time.Second,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "#8 key of a suspended account",
+			path:       "/orders",
+			key:        "gm_suspended",
+			statusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.advance)
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.key != "" {
+				req.Header.Set(Header, tt.key)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.statusCode, rr.Code)
+			switch tt.statusCode {
+			case http.StatusOK:
+				assert.Equal(t, identity.Identity{
+					UserID:   7,
+					Login:    "gopher",
+					APIKeyID: 1,
+					Scopes:   []string{common.ScopeOrdersRead},
+				}, user)
+			case http.StatusTooManyRequests:
+				assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+			}
+		})
+	}
+
+	assert.Equal(t, 1, storage.touched)
+}
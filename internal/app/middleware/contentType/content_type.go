@@ -0,0 +1,35 @@
+// Package contenttype provides a middleware rejecting request bodies of unsupported content types.
+package contenttype
+
+import (
+	"mime"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/knstch/gophermart/internal/app/apierror"
+)
+
+// RequireContentType returns a middleware aborting a request having a body with 415 Unsupported
+// Media Type unless its Content-Type is one of the media types. Parameters such as charset are
+// ignored. Requests without a body pass through.
+func RequireContentType(mediaTypes ...string) gin.HandlerFunc {
+	message := "Content-Type must be " + strings.Join(mediaTypes, " or ")
+	return func(ctx *gin.Context) {
+		if ctx.Request.ContentLength == 0 {
+			ctx.Next()
+			return
+		}
+
+		mediaType, _, err := mime.ParseMediaType(ctx.GetHeader("Content-Type"))
+		if err == nil {
+			for _, allowed := range mediaTypes {
+				if strings.EqualFold(mediaType, allowed) {
+					ctx.Next()
+					return
+				}
+			}
+		}
+
+		apierror.Abort(ctx, apierror.UnsupportedMediaType.WithMessage(message))
+	}
+}
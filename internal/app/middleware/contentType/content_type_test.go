@@ -0,0 +1,51 @@
+package contenttype
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireContentType(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.POST("/", RequireContentType(gin.MIMEJSON, "text/csv"), func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		wantStatus  int
+	}{
+		{name: "#1 JSON", body: `{}`, contentType: "application/json", wantStatus: http.StatusOK},
+		{name: "#2 JSON with charset", body: `{}`, contentType: "application/json; charset=utf-8", wantStatus: http.StatusOK},
+		{name: "#3 second media type", body: "1\n", contentType: "text/csv", wantStatus: http.StatusOK},
+		{name: "#4 plain text", body: "12345", contentType: "text/plain", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "#5 no content type", body: `{}`, wantStatus: http.StatusUnsupportedMediaType},
+		{name: "#6 malformed content type", body: `{}`, contentType: "application/json; charset", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "#7 no body", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.wantStatus, rr.Code)
+			if tt.wantStatus == http.StatusUnsupportedMediaType {
+				assert.Contains(t, rr.Body.String(), `"code":"unsupported_media_type"`)
+			}
+		})
+	}
+}
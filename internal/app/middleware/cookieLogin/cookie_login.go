@@ -1,31 +1,100 @@
-// Package cookielogin provides a middleware to parse a user login.
+// Package cookielogin provides a middleware to authenticate a user by the auth cookie.
 package cookielogin
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/knstch/gophermart/cmd/config"
+	"github.com/knstch/gophermart/internal/app/apierror"
+	"github.com/knstch/gophermart/internal/app/common"
 	"github.com/knstch/gophermart/internal/app/cookie"
+	"github.com/knstch/gophermart/internal/app/identity"
 	"github.com/knstch/gophermart/internal/app/logger"
 )
 
+// An interface responsible for checking if a session is revoked
+// and recording its usage.
+type Storage interface {
+	cookie.SessionStore
+}
+
+// A key of a gin context marking requests that need a CSRF token regardless of the config.
+const requireCSRFKey = "require_csrf"
+
+// RequireCSRF returns a middleware making WithCookieLogin check CSRF tokens of the requests
+// it serves even if config.ReadyConfig.CSRFProtection isn't set. It is used by API versions
+// built for browsers, while older clients keep working without a token.
+func RequireCSRF() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(requireCSRFKey, true)
+		ctx.Next()
+	}
+}
+
 // A middleware function checking if a user is logged in using cookie.
-// If a URL path is not "/api/user/register" or "/api/user/login" and
-// a user has a valid login cookie,
-// it serves an https requests and inserts login inside of a context.
-// Otherwise, it doesn't allow to go forward and returns 401 status code if
+// The session is checked by the session manager of config.ReadyConfig.SessionStore,
+// so a session revoked by the user, by a password or roles change stops working
+// on the next request. If a user has a valid auth cookie which is not revoked, it puts
+// identity.Identity inside of a context and serves the request. State-changing requests
+// also need the CSRF token of the session in the X-CSRF-Token header
+// if config.ReadyConfig.CSRFProtection is set or the route is behind RequireCSRF,
+// otherwise they get 403 status code.
+// Requests of suspended accounts get 403 status code as well.
+// Otherwise, it stops the chain and returns 401 status code if
 // a user is not authenticated or 500 if there is an Internal Server Error.
-func WithCookieLogin() gin.HandlerFunc {
+func WithCookieLogin(s Storage) gin.HandlerFunc {
+	sessions := cookie.NewSessionManager(s)
+
 	return func(ctx *gin.Context) {
-		userLogin, err := cookie.GetCookie(ctx.Request)
-		if err == cookie.ErrAuth {
+		account, session, err := sessions.Authenticate(ctx.Writer, ctx.Request)
+		switch {
+		case errors.Is(err, cookie.ErrRevoked):
+			logger.SecurityLogger("Revoked session used", fmt.Sprintf("%v, used from %s", err, ctx.ClientIP()))
+			abortUnauthenticated(ctx)
+			return
+		case errors.Is(err, cookie.ErrAuth):
 			logger.ErrorLogger("Error getting cookie", err)
-			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "You are not authenticated"})
-		} else if err != nil {
-			logger.ErrorLogger("Error reading cookie", err)
-			ctx.AbortWithStatus(http.StatusInternalServerError)
+			abortUnauthenticated(ctx)
+			return
+		case err != nil:
+			apierror.Abort(ctx, apierror.Internal)
+			return
 		}
-		ctx.Set("login", userLogin)
+
+		if account.State == common.AccountSuspended {
+			logger.SecurityLogger("Suspended account used", fmt.Sprintf("session of user %d (%s) used from %s", account.ID, account.Login, ctx.ClientIP()))
+			apierror.Abort(ctx, apierror.AccountSuspended)
+			return
+		}
+
+		csrfRequired := config.ReadyConfig.CSRFProtection || ctx.GetBool(requireCSRFKey)
+		if csrfRequired && !safeMethod(ctx.Request.Method) && !cookie.CheckCSRF(ctx.Request, session.ID) {
+			logger.SecurityLogger("CSRF check failed", fmt.Sprintf("%s %s of user %d without a valid CSRF token from %s", ctx.Request.Method, ctx.Request.URL.Path, account.ID, ctx.ClientIP()))
+			apierror.Abort(ctx, apierror.CSRFTokenInvalid)
+			return
+		}
+
+		identity.Set(ctx, identity.Identity{
+			UserID:       account.ID,
+			Login:        account.Login,
+			Roles:        account.Roles,
+			TokenID:      session.ID,
+			SecondFactor: session.SecondFactor,
+			LoggedInAt:   session.CreatedAt,
+		})
 		ctx.Next()
 	}
 }
+
+// safeMethod reports if an HTTP method doesn't change state, so it doesn't need a CSRF token.
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// abortUnauthenticated stops the chain with 401 status code.
+func abortUnauthenticated(ctx *gin.Context) {
+	apierror.Abort(ctx, apierror.Unauthenticated)
+}
@@ -0,0 +1,466 @@
+package cookielogin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/knstch/gophermart/cmd/config"
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/cookie"
+	"github.com/knstch/gophermart/internal/app/identity"
+	"github.com/knstch/gophermart/internal/app/storage/psql"
+	"github.com/stretchr/testify/assert"
+)
+
+type testStorage struct {
+	accounts map[int64]common.Account
+	sessions map[string]common.Session
+	touched  []string
+}
+
+func (s *testStorage) GetSession(ctx context.Context, id string) (common.Account, common.Session, error) {
+	session, ok := s.sessions[id]
+	if !ok {
+		return common.Account{}, common.Session{}, psql.ErrNoRows
+	}
+	account, ok := s.accounts[session.UserID]
+	if !ok {
+		return common.Account{}, common.Session{}, psql.ErrNoRows
+	}
+	return account, session, nil
+}
+
+func (s *testStorage) TouchSession(ctx context.Context, id string, usedAt time.Time, expiresAt *time.Time) error {
+	s.touched = append(s.touched, id)
+	session := s.sessions[id]
+	session.LastUsedAt = usedAt
+	if expiresAt != nil {
+		session.ExpiresAt = expiresAt
+	}
+	s.sessions[id] = session
+	return nil
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, key interface{}, claims cookie.Claims) string {
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	assert.NoError(t, err)
+	return token
+}
+
+func TestWithCookieLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config.ReadyConfig.SecretKey = "test-secret"
+
+	storage := &testStorage{
+		accounts: map[int64]common.Account{
+			7: {ID: 7, Login: "gopher", SessionVersion: 2, Roles: []string{common.RoleUser}},
+		},
+		sessions: map[string]common.Session{
+			"token-id":   {ID: "token-id", UserID: 7, LastUsedAt: time.Now().Add(-time.Hour)},
+			"deleted-id": {ID: "deleted-id", UserID: 8, LastUsedAt: time.Now()},
+		},
+	}
+
+	validClaims := cookie.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "token-id",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		UserID:         7,
+		SessionVersion: 2,
+		Roles:          []string{common.RoleUser},
+	}
+
+	expiredClaims := validClaims
+	expiredClaims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+
+	revokedClaims := validClaims
+	revokedClaims.SessionVersion = 1
+
+	unknownClaims := validClaims
+	unknownClaims.ID = "deleted-id"
+	unknownClaims.UserID = 8
+
+	revokedSessionClaims := validClaims
+	revokedSessionClaims.ID = "revoked-id"
+
+	foreignClaims := validClaims
+	foreignClaims.UserID = 9
+
+	noUserClaims := validClaims
+	noUserClaims.UserID = 0
+
+	secret := []byte(config.ReadyConfig.SecretKey)
+
+	tests := []struct {
+		name       string
+		token      string
+		noCookie   bool
+		statusCode int
+	}{
+		{
+			name:       "#1 missing cookie",
+			noCookie:   true,
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "#2 malformed token",
+			token:      "not-a-jwt",
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "#3 expired token",
+			token:      signToken(t, jwt.SigningMethodHS256, secret, expiredClaims),
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "#4 token signed with another key",
+			token:      signToken(t, jwt.SigningMethodHS256, []byte("another-secret"), validClaims),
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "#5 unsigned token",
+			token:      signToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, validClaims),
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "#6 token without user ID",
+			token:      signToken(t, jwt.SigningMethodHS256, secret, noUserClaims),
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "#7 session revoked by a version change",
+			token:      signToken(t, jwt.SigningMethodHS256, secret, revokedClaims),
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "#8 deleted user",
+			token:      signToken(t, jwt.SigningMethodHS256, secret, unknownClaims),
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "#9 revoked session",
+			token:      signToken(t, jwt.SigningMethodHS256, secret, revokedSessionClaims),
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "#10 session of another user",
+			token:      signToken(t, jwt.SigningMethodHS256, secret, foreignClaims),
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "#11 valid token",
+			token:      signToken(t, jwt.SigningMethodHS256, secret, validClaims),
+			statusCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reached bool
+			var user identity.Identity
+
+			router := gin.New()
+			router.GET("/", WithCookieLogin(storage), func(ctx *gin.Context) {
+				reached = true
+				user, _ = identity.From(ctx)
+				ctx.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if !tt.noCookie {
+				req.AddCookie(&http.Cookie{Name: "Auth", Value: tt.token})
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.statusCode, rr.Code)
+			assert.Equal(t, tt.statusCode == http.StatusOK, reached)
+			if reached {
+				assert.Equal(t, identity.Identity{
+					UserID:  7,
+					Login:   "gopher",
+					Roles:   []string{common.RoleUser},
+					TokenID: "token-id",
+				}, user)
+			} else {
+				assert.JSONEq(t, `{"code":"unauthenticated","error":"You are not authenticated"}`, rr.Body.String())
+			}
+		})
+	}
+
+	assert.Equal(t, []string{"token-id"}, storage.touched)
+}
+
+func TestWithCookieLoginCSRF(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config.ReadyConfig.SecretKey = "test-secret"
+	defer func() { config.ReadyConfig.CSRFProtection = false }()
+
+	storage := &testStorage{
+		accounts: map[int64]common.Account{
+			7: {ID: 7, Login: "gopher", SessionVersion: 2, Roles: []string{common.RoleUser}},
+		},
+		sessions: map[string]common.Session{
+			"token-id": {ID: "token-id", UserID: 7, LastUsedAt: time.Now()},
+		},
+	}
+
+	token := signToken(t, jwt.SigningMethodHS256, []byte(config.ReadyConfig.SecretKey), cookie.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "token-id",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		UserID:         7,
+		SessionVersion: 2,
+		Roles:          []string{common.RoleUser},
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		csrfToken  string
+		disabled   bool
+		required   bool
+		statusCode int
+	}{
+		{
+			name:       "#1 safe method without a token",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "#2 state-changing method without a token",
+			method:     http.MethodPost,
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "#3 token of another session",
+			method:     http.MethodDelete,
+			csrfToken:  cookie.CSRFToken("another-id"),
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "#4 token of the session",
+			method:     http.MethodPost,
+			csrfToken:  cookie.CSRFToken("token-id"),
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "#5 check turned off",
+			method:     http.MethodPost,
+			disabled:   true,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "#6 check turned off on a route requiring it",
+			method:     http.MethodPost,
+			disabled:   true,
+			required:   true,
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "#7 token on a route requiring it",
+			method:     http.MethodPost,
+			csrfToken:  cookie.CSRFToken("token-id"),
+			disabled:   true,
+			required:   true,
+			statusCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.ReadyConfig.CSRFProtection = !tt.disabled
+
+			router := gin.New()
+			if tt.required {
+				router.Use(RequireCSRF())
+			}
+			router.Handle(tt.method, "/", WithCookieLogin(storage), func(ctx *gin.Context) {
+				ctx.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.AddCookie(&http.Cookie{Name: cookie.AuthCookie, Value: token})
+			if tt.csrfToken != "" {
+				req.Header.Set(cookie.CSRFHeader, tt.csrfToken)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.statusCode, rr.Code)
+		})
+	}
+}
+
+func TestWithCookieLoginOpaqueSessions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config.ReadyConfig.SecretKey = "test-secret"
+	config.ReadyConfig.SessionStore = cookie.SessionStorePostgres
+	config.ReadyConfig.SessionTTL = time.Hour
+	defer func() {
+		config.ReadyConfig.SessionStore = cookie.SessionStoreJWT
+		config.ReadyConfig.SessionTTL = 0
+	}()
+
+	account := common.Account{ID: 7, Login: "gopher", SessionVersion: 2, Roles: []string{common.RoleAdmin}}
+	storage := &testStorage{
+		accounts: map[int64]common.Account{7: account},
+		sessions: map[string]common.Session{
+			"session-id": {ID: "session-id", UserID: 7, SessionVersion: 2, SecondFactor: true, LastUsedAt: time.Now().Add(-time.Hour)},
+			"old-id":     {ID: "old-id", UserID: 7, SessionVersion: 1, LastUsedAt: time.Now()},
+		},
+	}
+
+	tokenOf := func(session common.Session) string {
+		rr := httptest.NewRecorder()
+		assert.NoError(t, cookie.NewSessionManager(storage).Start(rr, account, session))
+		for _, c := range rr.Result().Cookies() {
+			if c.Name == cookie.AuthCookie {
+				return c.Value
+			}
+		}
+		return ""
+	}
+	validToken := tokenOf(storage.sessions["session-id"])
+
+	tests := []struct {
+		name       string
+		token      string
+		statusCode int
+	}{
+		{
+			name:       "#1 token without a signature",
+			token:      "session-id",
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "#2 token with a wrong signature",
+			token:      "session-id.0123",
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "#3 token of an unknown session",
+			token:      tokenOf(common.Session{ID: "unknown-id"}),
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "#4 session revoked by a version change",
+			token:      tokenOf(storage.sessions["old-id"]),
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "#5 valid token",
+			token:      validToken,
+			statusCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var user identity.Identity
+
+			router := gin.New()
+			router.GET("/", WithCookieLogin(storage), func(ctx *gin.Context) {
+				user, _ = identity.From(ctx)
+				ctx.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(&http.Cookie{Name: cookie.AuthCookie, Value: tt.token})
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.statusCode, rr.Code)
+			if tt.statusCode == http.StatusOK {
+				assert.Equal(t, identity.Identity{
+					UserID:       7,
+					Login:        "gopher",
+					Roles:        []string{common.RoleAdmin},
+					TokenID:      "session-id",
+					SecondFactor: true,
+				}, user)
+				assert.NotEmpty(t, rr.Result().Cookies(), "cookies of a used session are set again")
+			}
+		})
+	}
+
+	assert.Equal(t, []string{"session-id"}, storage.touched)
+	expiresAt := storage.sessions["session-id"].ExpiresAt
+	if assert.NotNil(t, expiresAt) {
+		assert.WithinDuration(t, time.Now().Add(time.Hour), *expiresAt, time.Minute)
+	}
+}
+
+func TestWithCookieLoginAccountStates(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config.ReadyConfig.SecretKey = "test-secret"
+
+	storage := &testStorage{
+		accounts: map[int64]common.Account{
+			7: {ID: 7, Login: "gopher", SessionVersion: 2, Roles: []string{common.RoleUser}, State: common.AccountFrozen},
+			8: {ID: 8, Login: "mole", SessionVersion: 2, Roles: []string{common.RoleUser}, State: common.AccountSuspended},
+		},
+		sessions: map[string]common.Session{
+			"frozen-id":    {ID: "frozen-id", UserID: 7, LastUsedAt: time.Now()},
+			"suspended-id": {ID: "suspended-id", UserID: 8, LastUsedAt: time.Now()},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		userID     int64
+		sessionID  string
+		statusCode int
+		body       string
+	}{
+		{
+			name:       "#1 frozen account",
+			userID:     7,
+			sessionID:  "frozen-id",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "#2 suspended account",
+			userID:     8,
+			sessionID:  "suspended-id",
+			statusCode: http.StatusForbidden,
+			body:       `{"code":"account_suspended","error":"Account is suspended"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/", WithCookieLogin(storage), func(ctx *gin.Context) {
+				ctx.Status(http.StatusOK)
+			})
+
+			token := signToken(t, jwt.SigningMethodHS256, []byte(config.ReadyConfig.SecretKey), cookie.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					ID:        tt.sessionID,
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				},
+				UserID:         tt.userID,
+				SessionVersion: 2,
+				Roles:          []string{common.RoleUser},
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(&http.Cookie{Name: cookie.AuthCookie, Value: token})
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.statusCode, rr.Code)
+			if tt.body != "" {
+				assert.JSONEq(t, tt.body, rr.Body.String())
+			}
+		})
+	}
+}
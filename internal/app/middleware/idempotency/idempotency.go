@@ -0,0 +1,159 @@
+// Package idempotency provides a middleware replaying responses to requests retried with an idempotency key.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/knstch/gophermart/internal/app/apierror"
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/identity"
+	"github.com/knstch/gophermart/internal/app/logger"
+)
+
+// A header an idempotency key is passed in.
+const Header = "Idempotency-Key"
+
+// A header set on a replayed response.
+const ReplayedHeader = "Idempotent-Replayed"
+
+// The longest idempotency key.
+const maxKeyLength = 255
+
+// The largest body of a request with an idempotency key.
+const maxBodySize = 1 << 20
+
+// An interface responsible for reserving idempotency keys and recording responses.
+type Storage interface {
+	ReserveIdempotencyKey(ctx context.Context, key common.IdempotencyKey) (common.IdempotencyKey, bool, error)
+	SaveIdempotentResponse(ctx context.Context, userID int64, key string, statusCode int, contentType string, body []byte) error
+	ReleaseIdempotencyKey(ctx context.Context, userID int64, key string) error
+}
+
+// A struct copying a response while it is written.
+type recorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+// Write writes data to the response and copies it.
+func (r *recorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+// WriteString writes a string to the response and copies it.
+func (r *recorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
+
+// WithIdempotencyKey returns a middleware for requests of authenticated users which may have
+// the Idempotency-Key header. The first request with a key is handled and its response is kept
+// for ttl. A retry with the same key and the same method, path and body gets the kept response
+// with the Idempotent-Replayed header. A retry with a different request gets 422 status code and
+// a retry made while the first request is handled gets 409. Server errors and panics are not kept,
+// so a request failed with one can be retried. Requests without the header are passed on.
+func WithIdempotencyKey(s Storage, ttl time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.GetHeader(Header)
+		if key == "" {
+			ctx.Next()
+			return
+		}
+		if len(key) > maxKeyLength {
+			apierror.Abort(ctx, apierror.IdempotencyKeyTooLong)
+			return
+		}
+
+		user, ok := identity.From(ctx)
+		if !ok {
+			apierror.Abort(ctx, apierror.Unauthenticated)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxBodySize))
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			apierror.Abort(ctx, apierror.RequestTooLarge)
+			return
+		case err != nil:
+			apierror.Abort(ctx, apierror.BadRequest)
+			return
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		recorded, reserved, err := s.ReserveIdempotencyKey(ctx, common.IdempotencyKey{
+			UserID:      user.UserID,
+			Key:         key,
+			Fingerprint: fingerprint(ctx.Request.Method, ctx.Request.URL.Path, body),
+			ExpiresAt:   time.Now().Add(ttl),
+		})
+		if err != nil {
+			apierror.Abort(ctx, apierror.Internal)
+			return
+		}
+
+		if !reserved {
+			switch {
+			case recorded.Fingerprint != fingerprint(ctx.Request.Method, ctx.Request.URL.Path, body):
+				apierror.Abort(ctx, apierror.IdempotencyKeyMismatch)
+			case recorded.StatusCode == 0:
+				apierror.Abort(ctx, apierror.IdempotencyKeyInProgress)
+			default:
+				ctx.Header(ReplayedHeader, "true")
+				ctx.Data(recorded.StatusCode, recorded.ContentType, recorded.Body)
+				ctx.Abort()
+			}
+			return
+		}
+
+		// The client may be gone after a timeout, which is when the response is needed the most.
+		saveCtx := context.WithoutCancel(ctx.Request.Context())
+
+		// A panic is recovered by the router after this middleware, so the key is released
+		// here, otherwise retries would get 409 status code until the key expires.
+		defer func() {
+			if p := recover(); p != nil {
+				release(saveCtx, s, user.UserID, key)
+				panic(p)
+			}
+		}()
+
+		rec := &recorder{ResponseWriter: ctx.Writer}
+		ctx.Writer = rec
+		ctx.Next()
+
+		if rec.Status() >= http.StatusInternalServerError {
+			release(saveCtx, s, user.UserID, key)
+			return
+		}
+		err = s.SaveIdempotentResponse(saveCtx, user.UserID, key, rec.Status(), rec.Header().Get("Content-Type"), rec.body.Bytes())
+		if err != nil {
+			logger.ErrorLogger("Error saving idempotent response: ", err)
+		}
+	}
+}
+
+// release releases an idempotency key, so a request failed with a server error can be retried.
+func release(ctx context.Context, s Storage, userID int64, key string) {
+	if err := s.ReleaseIdempotencyKey(ctx, userID, key); err != nil {
+		logger.ErrorLogger("Error releasing idempotency key: ", err)
+	}
+}
+
+// fingerprint returns a hash identifying a request by its method, path and body.
+func fingerprint(method string, path string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method + "\n" + path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,195 @@
+package idempotency
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/identity"
+	"github.com/stretchr/testify/assert"
+)
+
+type testStorage struct {
+	keys map[string]common.IdempotencyKey
+}
+
+func (s *testStorage) ReserveIdempotencyKey(ctx context.Context, key common.IdempotencyKey) (common.IdempotencyKey, bool, error) {
+	if recorded, ok := s.keys[key.Key]; ok {
+		return recorded, false, nil
+	}
+	s.keys[key.Key] = key
+	return key, true, nil
+}
+
+func (s *testStorage) SaveIdempotentResponse(ctx context.Context, userID int64, key string, statusCode int, contentType string, body []byte) error {
+	recorded := s.keys[key]
+	recorded.StatusCode = statusCode
+	recorded.ContentType = contentType
+	recorded.Body = body
+	s.keys[key] = recorded
+	return nil
+}
+
+func (s *testStorage) ReleaseIdempotencyKey(ctx context.Context, userID int64, key string) error {
+	delete(s.keys, key)
+	return nil
+}
+
+func TestWithIdempotencyKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	storage := &testStorage{keys: map[string]common.IdempotencyKey{
+		"pending": {UserID: 7, Key: "pending", Fingerprint: fingerprint(http.MethodPost, "/withdraw", []byte(`{"sum": 1}`))},
+	}}
+
+	handled := 0
+	failing := true
+	panicking := true
+	router := gin.New()
+	router.Use(gin.RecoveryWithWriter(io.Discard))
+	router.POST("/withdraw", func(ctx *gin.Context) {
+		identity.Set(ctx, identity.Identity{UserID: 7})
+	}, WithIdempotencyKey(storage, time.Hour), func(ctx *gin.Context) {
+		handled++
+		ctx.JSON(http.StatusOK, gin.H{"message": "Bonuses withdrawn", "n": handled})
+	})
+	router.POST("/flaky", func(ctx *gin.Context) {
+		identity.Set(ctx, identity.Identity{UserID: 7})
+	}, WithIdempotencyKey(storage, time.Hour), func(ctx *gin.Context) {
+		if failing {
+			failing = false
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		ctx.Status(http.StatusAccepted)
+	})
+	router.POST("/panicky", func(ctx *gin.Context) {
+		identity.Set(ctx, identity.Identity{UserID: 7})
+	}, WithIdempotencyKey(storage, time.Hour), func(ctx *gin.Context) {
+		if panicking {
+			panicking = false
+			panic("handler failed")
+		}
+		ctx.Status(http.StatusAccepted)
+	})
+
+	tests := []struct {
+		name       string
+		path       string
+		key        string
+		body       string
+		statusCode int
+		response   string
+		replayed   bool
+		handled    int
+	}{
+		{
+			name:       "#1 no key",
+			path:       "/withdraw",
+			body:       `{"sum": 1}`,
+			statusCode: http.StatusOK,
+			response:   `{"message": "Bonuses withdrawn", "n": 1}`,
+			handled:    1,
+		},
+		{
+			name:       "#2 first request with a key",
+			path:       "/withdraw",
+			key:        "retry-me",
+			body:       `{"sum": 1}`,
+			statusCode: http.StatusOK,
+			response:   `{"message": "Bonuses withdrawn", "n": 2}`,
+			handled:    2,
+		},
+		{
+			name:       "#3 retry is replayed",
+			path:       "/withdraw",
+			key:        "retry-me",
+			body:       `{"sum": 1}`,
+			statusCode: http.StatusOK,
+			response:   `{"message": "Bonuses withdrawn", "n": 2}`,
+			replayed:   true,
+			handled:    2,
+		},
+		{
+			name:       "#4 key reused with a different body",
+			path:       "/withdraw",
+			key:        "retry-me",
+			body:       `{"sum": 2}`,
+			statusCode: http.StatusUnprocessableEntity,
+			handled:    2,
+		},
+		{
+			name:       "#5 request in progress",
+			path:       "/withdraw",
+			key:        "pending",
+			body:       `{"sum": 1}`,
+			statusCode: http.StatusConflict,
+			handled:    2,
+		},
+		{
+			name:       "#6 too long key",
+			path:       "/withdraw",
+			key:        strings.Repeat("k", maxKeyLength+1),
+			body:       `{"sum": 1}`,
+			statusCode: http.StatusBadRequest,
+			handled:    2,
+		},
+		{
+			name:       "#7 server error is not kept",
+			path:       "/flaky",
+			key:        "flaky",
+			statusCode: http.StatusInternalServerError,
+			handled:    2,
+		},
+		{
+			name:       "#8 retry after a server error is handled",
+			path:       "/flaky",
+			key:        "flaky",
+			statusCode: http.StatusAccepted,
+			handled:    2,
+		},
+		{
+			name:       "#9 panic is not kept",
+			path:       "/panicky",
+			key:        "panicky",
+			statusCode: http.StatusInternalServerError,
+			handled:    2,
+		},
+		{
+			name:       "#10 retry after a panic is handled",
+			path:       "/panicky",
+			key:        "panicky",
+			statusCode: http.StatusAccepted,
+			handled:    2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			if tt.key != "" {
+				req.Header.Set(Header, tt.key)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.statusCode, rr.Code)
+			if tt.response != "" {
+				assert.JSONEq(t, tt.response, rr.Body.String())
+			}
+			if tt.replayed {
+				assert.Equal(t, "true", rr.Header().Get(ReplayedHeader))
+				assert.Equal(t, "application/json; charset=utf-8", rr.Header().Get("Content-Type"))
+			} else {
+				assert.Empty(t, rr.Header().Get(ReplayedHeader))
+			}
+			assert.Equal(t, tt.handled, handled)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+// Package requestid provides a middleware giving every request an ID returned to the client.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A header a request ID is passed and returned in.
+const Header = "X-Request-ID"
+
+// A key a request ID is stored by in a gin context.
+const contextKey = "request_id"
+
+// IDs a client can pass instead of a generated one.
+var validID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// WithRequestID returns a middleware putting a request ID inside of a context and into
+// the X-Request-ID response header. An ID passed by a client in the header is kept if it is
+// up to 64 letters, digits, dots, dashes and underscores, a random ID is generated otherwise.
+func WithRequestID() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id := ctx.GetHeader(Header)
+		if !validID.MatchString(id) {
+			id = newID()
+		}
+		ctx.Set(contextKey, id)
+		ctx.Header(Header, id)
+		ctx.Next()
+	}
+}
+
+// From returns a request ID stored in a context, or an empty string if there is none.
+// It accepts *gin.Context as well as any other context.Context.
+func From(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey).(string)
+	return id
+}
+
+// newID returns a random request ID.
+func newID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,50 @@
+package requestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/", WithRequestID(), func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, From(ctx))
+	})
+
+	tests := []struct {
+		name      string
+		header    string
+		generated bool
+	}{
+		{name: "#1 no ID", generated: true},
+		{name: "#2 ID of the client", header: "checkout-42.retry_1"},
+		{name: "#3 too long ID", header: strings.Repeat("a", 65), generated: true},
+		{name: "#4 ID with wrong characters", header: "id\nInjected: true", generated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(Header, tt.header)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			id := rr.Header().Get(Header)
+			assert.Equal(t, id, rr.Body.String())
+			if tt.generated {
+				assert.Len(t, id, 32)
+			} else {
+				assert.Equal(t, tt.header, id)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+// Package requirerole provides a middleware restricting routes by user roles.
+package requirerole
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/knstch/gophermart/internal/app/apierror"
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/identity"
+	"github.com/knstch/gophermart/internal/app/logger"
+)
+
+// A middleware function allowing a request to go forward only if
+// the authenticated user has at least one of the roles.
+// It has to run after a middleware putting identity.Identity inside of a context,
+// otherwise it returns 401 status code.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		user, ok := identity.From(ctx)
+		if !ok {
+			apierror.Abort(ctx, apierror.Unauthenticated)
+			return
+		}
+		for _, role := range roles {
+			if common.Contains(user.Roles, role) {
+				ctx.Next()
+				return
+			}
+		}
+		logger.SecurityLogger("Access denied", user.Login+" tried to access "+ctx.Request.URL.Path)
+		apierror.Abort(ctx, apierror.Forbidden)
+	}
+}
+
+// A middleware function allowing a request to go forward only if
+// the authenticated user has logged in with two-factor authentication.
+// It has to run after a middleware putting identity.Identity inside of a context,
+// otherwise it returns 401 status code.
+func RequireSecondFactor() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		user, ok := identity.From(ctx)
+		if !ok {
+			apierror.Abort(ctx, apierror.Unauthenticated)
+			return
+		}
+		if !user.SecondFactor {
+			logger.SecurityLogger("Access denied", user.Login+" tried to access "+ctx.Request.URL.Path+" without two-factor authentication")
+			apierror.Abort(ctx, apierror.SecondFactorRequired)
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,63 @@
+// Package notifier delivers messages, such as password reset tokens, to users.
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/knstch/gophermart/internal/app/logger"
+)
+
+// An interface responsible for delivering messages to users.
+type Notifier interface {
+	SendPasswordReset(ctx context.Context, login string, token string, expiresAt time.Time) error
+}
+
+// A notifier writing messages to the log. It's meant for development only.
+type LogNotifier struct{}
+
+// SendPasswordReset writes a password reset token to the log.
+func (LogNotifier) SendPasswordReset(ctx context.Context, login string, token string, expiresAt time.Time) error {
+	logger.InfoLogger(resetMessage(login, token, expiresAt))
+	return nil
+}
+
+// A notifier appending messages to a local file. It's meant for development only.
+type FileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+// A builder function returning a FileNotifier writing to the file at path.
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+// SendPasswordReset appends a password reset token to the file.
+func (n *FileNotifier) SendPasswordReset(ctx context.Context, login string, token string, expiresAt time.Time) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	file, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		logger.ErrorLogger("Error opening notifications file: ", err)
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%s %s\n", time.Now().Format(time.RFC3339), resetMessage(login, token, expiresAt))
+	if err != nil {
+		logger.ErrorLogger("Error writing notification: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// resetMessage formats a password reset message.
+func resetMessage(login string, token string, expiresAt time.Time) string {
+	return fmt.Sprintf("Password reset for %q: token %s, valid until %s", login, token, expiresAt.Format(time.RFC3339))
+}
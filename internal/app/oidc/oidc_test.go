@@ -0,0 +1,137 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/knstch/gophermart/internal/app/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+)
+
+// authorize follows the provider login page and returns the code and the state
+// sent back to the redirect URI.
+func authorize(t *testing.T, authURL string) (string, string) {
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Get(authURL)
+	if !assert.NoError(t, err) {
+		return "", ""
+	}
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/callback", location.Path)
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestProvider(t *testing.T) {
+	stub := oidctest.NewProvider("gophermart", "client-secret")
+	defer stub.Close()
+
+	provider := NewProvider(Config{
+		Issuer:       stub.Issuer(),
+		ClientID:     "gophermart",
+		ClientSecret: "client-secret",
+		RedirectURL:  "http://localhost:8080/callback",
+	})
+
+	tests := []struct {
+		name        string
+		modify      func(claims jwt.MapClaims)
+		nonce       string
+		verifier    string
+		reuseCode   bool
+		wantErr     error
+		wantSubject string
+	}{
+		{
+			name:        "#1 valid login",
+			wantSubject: "user-1",
+		},
+		{
+			name:    "#2 wrong nonce",
+			nonce:   "another-nonce",
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "#3 token for another client",
+			modify:  func(claims jwt.MapClaims) { claims["aud"] = "another-client" },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "#4 token of another issuer",
+			modify:  func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "#5 expired token",
+			modify:  func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Minute).Unix() },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:     "#6 wrong code verifier",
+			verifier: "another-verifier",
+			wantErr:  ErrProvider,
+		},
+		{
+			name:      "#7 code used twice",
+			reuseCode: true,
+			wantErr:   ErrProvider,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub.Modify = tt.modify
+			ctx := context.Background()
+
+			state, err := RandomString()
+			assert.NoError(t, err)
+			nonce, err := RandomString()
+			assert.NoError(t, err)
+			verifier, err := RandomString()
+			assert.NoError(t, err)
+
+			authURL, err := provider.AuthURL(ctx, state, nonce, verifier)
+			assert.NoError(t, err)
+			code, returnedState := authorize(t, authURL)
+			assert.Equal(t, state, returnedState)
+
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+			if tt.verifier != "" {
+				verifier = tt.verifier
+			}
+			if tt.reuseCode {
+				_, err = provider.Exchange(ctx, code, verifier, nonce)
+				assert.NoError(t, err)
+			}
+
+			identity, err := provider.Exchange(ctx, code, verifier, nonce)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, stub.Issuer(), identity.Issuer)
+			assert.Equal(t, tt.wantSubject, identity.Subject)
+			assert.Equal(t, "user@example.com", identity.Email)
+			assert.Equal(t, "user", identity.PreferredUsername)
+		})
+	}
+}
+
+func TestProviderUnavailable(t *testing.T) {
+	provider := NewProvider(Config{Issuer: "http://127.0.0.1:1", ClientID: "gophermart"})
+
+	_, err := provider.AuthURL(context.Background(), "state", "nonce", "verifier")
+	assert.ErrorIs(t, err, ErrProvider)
+}
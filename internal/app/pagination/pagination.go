@@ -0,0 +1,80 @@
+// Package pagination provides limits and cursors for keyset pagination of lists.
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// How many items a page has if the cursor is set without a limit.
+const DefaultLimit = 50
+
+// The most items a page can have.
+const MaxLimit = 100
+
+// A header the cursor of the next page is returned in.
+const Header = "X-Next-Cursor"
+
+// A sort direction from old to new items.
+const Asc = "asc"
+
+// A sort direction from new to old items.
+const Desc = "desc"
+
+// An error indicating that a cursor is malformed.
+var ErrInvalidCursor = errors.New("cursor is not valid")
+
+// An error indicating that a limit is not a number between 1 and MaxLimit.
+var ErrInvalidLimit = errors.New("limit is not valid")
+
+// A struct describing a position in a list: the time of the last item of a page
+// and a unique key of the item breaking ties between items with the same time.
+type Cursor struct {
+	Time string `json:"t"`
+	Key  string `json:"k"`
+}
+
+// A struct describing a requested page. Items after the cursor are returned,
+// from new to old ones if Desc is set. A page starts from the first item if After is nil
+// and has all items if Limit is 0.
+type Page struct {
+	Limit int
+	After *Cursor
+	Desc  bool
+}
+
+// Encode returns the cursor as an opaque URL-safe string.
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseCursor decodes a cursor returned by Encode. It returns ErrInvalidCursor
+// if the cursor is malformed.
+func ParseCursor(s string) (Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	var c Cursor
+	if err = json.Unmarshal(data, &c); err != nil || c.Time == "" || c.Key == "" {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// ParseLimit parses a page limit. It returns DefaultLimit if the limit is empty
+// and ErrInvalidLimit if it is not a number between 1 and MaxLimit.
+func ParseLimit(s string) (int, error) {
+	if s == "" {
+		return DefaultLimit, nil
+	}
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit < 1 || limit > MaxLimit {
+		return 0, ErrInvalidLimit
+	}
+	return limit, nil
+}
@@ -0,0 +1,59 @@
+package pagination
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursor(t *testing.T) {
+	cursor := Cursor{Time: "2023-12-17T20:13:42Z", Key: "5105105105105100"}
+
+	parsed, err := ParseCursor(cursor.Encode())
+	assert.NoError(t, err)
+	assert.Equal(t, cursor, parsed)
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{name: "#1 not base64", cursor: "not a cursor!"},
+		{name: "#2 not json", cursor: "bm90IGpzb24"},
+		{name: "#3 empty cursor", cursor: Cursor{}.Encode()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCursor(tt.cursor)
+			assert.ErrorIs(t, err, ErrInvalidCursor)
+		})
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   string
+		want    int
+		wantErr bool
+	}{
+		{name: "#1 default", limit: "", want: DefaultLimit},
+		{name: "#2 valid", limit: "10", want: 10},
+		{name: "#3 maximum", limit: "100", want: MaxLimit},
+		{name: "#4 zero", limit: "0", wantErr: true},
+		{name: "#5 over the maximum", limit: "101", wantErr: true},
+		{name: "#6 not a number", limit: "ten", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit, err := ParseLimit(tt.limit)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidLimit)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, limit)
+		})
+	}
+}
@@ -3,46 +3,148 @@ package router
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/knstch/gophermart/internal/app/apierror"
 	"github.com/knstch/gophermart/internal/app/handler"
 
 	"github.com/gin-contrib/gzip"
+	"github.com/knstch/gophermart/cmd/config"
 	_ "github.com/knstch/gophermart/docs"
+	"github.com/knstch/gophermart/internal/app/common"
+	apikeyauth "github.com/knstch/gophermart/internal/app/middleware/apiKeyAuth"
+	contenttype "github.com/knstch/gophermart/internal/app/middleware/contentType"
 	cookielogin "github.com/knstch/gophermart/internal/app/middleware/cookieLogin"
+	"github.com/knstch/gophermart/internal/app/middleware/idempotency"
+	requestid "github.com/knstch/gophermart/internal/app/middleware/requestID"
+	requirerole "github.com/knstch/gophermart/internal/app/middleware/requireRole"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
-// Router serving requests
-func RequestsRouter(h *handler.Handler) *gin.Engine {
-	router := gin.Default()
+// An interface of a storage used by the authentication and idempotency middlewares.
+type Storage interface {
+	cookielogin.Storage
+	apikeyauth.Storage
+	idempotency.Storage
+}
+
+// Router serving requests. It accepts handlers and a storage
+// used by the authentication and idempotency middlewares.
+func RequestsRouter(h *handler.Handler, s Storage) *gin.Engine {
+	router := gin.New()
+	router.HandleMethodNotAllowed = true
+	router.Use(requestid.WithRequestID(), gin.Logger(), gin.CustomRecovery(func(ctx *gin.Context, _ any) {
+		apierror.Abort(ctx, apierror.Internal)
+	}))
+	router.NoRoute(func(ctx *gin.Context) {
+		apierror.Abort(ctx, apierror.NotFound)
+	})
+	router.NoMethod(func(ctx *gin.Context) {
+		apierror.Abort(ctx, apierror.MethodNotAllowed)
+	})
 
-	router.Use(gzip.Gzip(gzip.BestCompression))
+	cookieAuth := cookielogin.WithCookieLogin(s)
+
+	// Event streams are flushed event by event, which the compressing writer doesn't support.
+	router.Use(gzip.Gzip(gzip.BestCompression, gzip.WithExcludedPaths([]string{"/api/user/events", "/api/v2/user/events"})))
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
-	api := router.Group("/api")
+
+	mw := middlewares{
+		cookieAuth: cookieAuth,
+		apiKeys:    apikeyauth.NewAuthenticator(s, cookieAuth, config.ReadyConfig.APIKeyRateLimit),
+		idempotent: idempotency.WithIdempotencyKey(s, config.ReadyConfig.IdempotencyTTL),
+	}
+	addRoutes(router.Group("/api"), h, mw, version{
+		json:            func(ctx *gin.Context) { ctx.Next() },
+		uploadOrder:     h.UploadOrder,
+		withdrawBonuses: h.WithdrawBonuses,
+	})
+	addRoutes(router.Group("/api/v2", cookielogin.RequireCSRF()), h, mw, version{
+		json:            contenttype.RequireContentType(gin.MIMEJSON),
+		uploadOrder:     h.UploadOrderV2,
+		withdrawBonuses: h.WithdrawBonusesV2,
+	})
+
+	return router
+}
+
+// A struct designed to keep the middlewares shared by all API versions.
+type middlewares struct {
+	cookieAuth gin.HandlerFunc
+	apiKeys    *apikeyauth.Authenticator
+	idempotent gin.HandlerFunc
+}
+
+// A struct designed to keep what differs between API versions: a middleware checking
+// the content type of JSON requests and the handlers reading orders in a different way.
+type version struct {
+	json            gin.HandlerFunc
+	uploadOrder     gin.HandlerFunc
+	withdrawBonuses gin.HandlerFunc
+}
+
+// addRoutes adds the routes of an API version to a group.
+func addRoutes(api *gin.RouterGroup, h *handler.Handler, mw middlewares, v version) {
+	admin := api.Group("/admin")
+	admin.Use(mw.cookieAuth, requirerole.RequireRole(common.RoleAdmin))
+	if config.ReadyConfig.RequireAdmin2FA {
+		admin.Use(requirerole.RequireSecondFactor())
+	}
 	{
-		user := api.Group("/user")
+		admin.GET("/users/:login", h.GetUser)
+		admin.PUT("/users/:login/roles", v.json, h.SetRoles)
+		admin.PUT("/users/:login/state", v.json, h.SetAccountState)
+		admin.GET("/users/:login/state", h.GetAccountStateChanges)
+		admin.GET("/users/:login/export", h.AdminExportAccount)
+		admin.DELETE("/users/:login", h.AdminDeleteAccount)
+		admin.GET("/orders/:number", h.AdminGetOrder)
+		admin.POST("/withdrawals/:number/reversal", h.AdminReverseWithdrawal)
+
+		admin.POST("/api-keys", v.json, h.CreateAPIKey)
+		admin.GET("/api-keys", h.GetAPIKeys)
+		admin.DELETE("/api-keys/:id", h.RevokeAPIKey)
+	}
+
+	user := api.Group("/user")
+	{
+		user.POST("/register", v.json, h.SignUp)
+		user.POST("/login", v.json, h.Auth)
+		user.POST("/login/2fa", v.json, h.LoginSecondFactor)
+		if config.ReadyConfig.OIDCIssuer != "" {
+			user.GET("/oidc/login", h.OIDCLogin)
+			user.GET("/oidc/callback", h.OIDCCallback)
+		}
+		user.POST("/password/reset", v.json, h.RequestPasswordReset)
+		user.POST("/password/reset/confirm", v.json, h.ConfirmPasswordReset)
+
+		user.GET("/statement", mw.apiKeys.WithScope(common.ScopeBalanceRead), h.GetStatement)
+		user.GET("/statement/export", mw.apiKeys.WithScope(common.ScopeBalanceRead), h.ExportStatement)
+		user.GET("/withdrawals", mw.apiKeys.WithScope(common.ScopeWithdrawalsRead), h.GetOrderWithSpentBonuses)
+		user.GET("/withdrawals/export", mw.apiKeys.WithScope(common.ScopeWithdrawalsRead), h.ExportWithdrawals)
+		user.POST("/withdrawals/:number/reversal", mw.apiKeys.WithScope(common.ScopeBalanceWithdraw), h.ReverseWithdrawal)
+		user.POST("/password", mw.cookieAuth, v.json, h.ChangePassword)
+		user.PATCH("/profile", mw.cookieAuth, v.json, h.UpdateProfile)
+		user.GET("/export", mw.cookieAuth, h.ExportAccount)
+		user.DELETE("", mw.cookieAuth, v.json, h.DeleteAccount)
+		user.GET("/events", mw.cookieAuth, h.Events)
+		user.GET("/sessions", mw.cookieAuth, h.GetSessions)
+		user.DELETE("/sessions/:id", mw.cookieAuth, h.RevokeSession)
+		user.POST("/2fa", mw.cookieAuth, h.StartTwoFactor)
+		user.POST("/2fa/confirm", mw.cookieAuth, v.json, h.ConfirmTwoFactor)
+		user.DELETE("/2fa", mw.cookieAuth, v.json, h.DisableTwoFactor)
+
+		orders := user.Group("/orders")
 		{
-			user.POST("/register", h.SignUp)
-			user.POST("/login", h.Auth)
-
-			user.Use(cookielogin.WithCookieLogin())
-			{
-				user.GET("/withdrawals", h.GetOrderWithSpentBonuses)
-
-				orders := user.Group("/orders")
-				{
-					orders.POST("/", h.UploadOrder)
-					orders.GET("/", h.GetOrders)
-				}
-
-				balance := user.Group("/balance")
-				{
-					balance.GET("/", h.Balance)
-					balance.POST("/withdraw", h.WithdrawBonuses)
-				}
-			}
+			orders.POST("/", mw.apiKeys.WithScope(common.ScopeOrdersSubmit), v.json, mw.idempotent, v.uploadOrder)
+			orders.GET("/", mw.apiKeys.WithScope(common.ScopeOrdersRead), h.GetOrders)
+			orders.POST("/batch", mw.apiKeys.WithScope(common.ScopeOrdersSubmit), mw.idempotent, h.UploadOrders)
+			orders.GET("/export", mw.apiKeys.WithScope(common.ScopeOrdersRead), h.ExportOrders)
+			orders.GET("/:number", mw.apiKeys.WithScope(common.ScopeOrdersRead), h.GetOrder)
 		}
-	}
 
-	return router
+		balance := user.Group("/balance")
+		{
+			balance.GET("/", mw.apiKeys.WithScope(common.ScopeBalanceRead), h.Balance)
+			balance.POST("/withdraw", mw.apiKeys.WithScope(common.ScopeBalanceWithdraw), v.json, mw.idempotent, v.withdrawBonuses)
+		}
+	}
 }
@@ -0,0 +1,255 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// A format of logins given to deleted accounts. It is not allowed by the login
+// policy, so a deleted login can't be registered or taken by a rename.
+const deletedLoginFormat = "deleted#%d"
+
+// HasPassword accepts context and user ID and reports whether the user has a password.
+// Accounts created through OpenID Connect have none until the password is reset.
+// It returns ErrNoRows if there is no such user or the account is deleted.
+func (storage *PsqURLlStorage) HasPassword(ctx context.Context, userID int64) (bool, error) {
+	var user User
+
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	err := db.NewSelect().
+		Model(&user).
+		Column("password").
+		Where("id = ? AND deleted_at IS NULL", userID).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrNoRows
+	}
+	if err != nil {
+		logger.ErrorLogger("Error finding user: ", err)
+		return false, err
+	}
+	return user.Password != "", nil
+}
+
+// ExportAccount accepts context and user ID and returns all data kept about the user:
+// the account, orders, withdrawals, balance history, sessions, API keys and linked identities.
+// It returns ErrNoRows if there is no such user.
+func (storage *PsqURLlStorage) ExportAccount(ctx context.Context, userID int64) (common.AccountExport, error) {
+	var user User
+
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	err := db.NewSelect().
+		Model(&user).
+		Where("id = ?", userID).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.AccountExport{}, ErrNoRows
+	}
+	if err != nil {
+		logger.ErrorLogger("Error finding user: ", err)
+		return common.AccountExport{}, err
+	}
+
+	var orders []common.Order
+	err = db.NewSelect().
+		Model(&orders).
+		Column(orderColumns...).
+		Where("user_id = ?", userID).
+		Order("uploaded_at ASC").
+		Scan(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error getting orders: ", err)
+		return common.AccountExport{}, err
+	}
+
+	var withdrawalRows []Withdrawal
+	err = withdrawalsFilter(db.NewSelect().Model(&withdrawalRows), userID, common.WithdrawalsQuery{}).
+		Scan(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error getting withdrawals: ", err)
+		return common.AccountExport{}, err
+	}
+	withdrawals := make(map[string]Withdrawal, len(withdrawalRows))
+	for _, withdrawal := range withdrawalRows {
+		withdrawals[withdrawal.Order] = withdrawal
+	}
+
+	var balanceHistory []BalanceLine
+	err = statementQuery(db, userID, nil, nil).Scan(ctx, &balanceHistory)
+	if err != nil {
+		logger.ErrorLogger("Error getting balance history: ", err)
+		return common.AccountExport{}, err
+	}
+
+	var sessions []Session
+	err = db.NewSelect().
+		Model(&sessions).
+		Where("user_id = ?", userID).
+		Order("created_at ASC").
+		Scan(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error getting sessions: ", err)
+		return common.AccountExport{}, err
+	}
+
+	var identities []OIDCIdentity
+	err = db.NewSelect().
+		Model(&identities).
+		Where("user_id = ?", userID).
+		Order("created_at ASC").
+		Scan(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error getting identities: ", err)
+		return common.AccountExport{}, err
+	}
+
+	var apiKeys []APIKey
+	err = db.NewSelect().
+		Model(&apiKeys).
+		Where("user_id = ?", userID).
+		Order("created_at ASC").
+		Scan(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error getting API keys: ", err)
+		return common.AccountExport{}, err
+	}
+
+	export := common.AccountExport{
+		ExportedAt:     time.Now(),
+		Account:        user.toAccountInfo(),
+		Orders:         make([]common.Order, 0, len(orders)),
+		Withdrawals:    []common.OrdersWithSpentBonuses{},
+		BalanceHistory: make([]common.BalanceChange, 0, len(balanceHistory)),
+		Sessions:       make([]common.Session, 0, len(sessions)),
+		APIKeys:        make([]common.APIKey, 0, len(apiKeys)),
+		Identities:     make([]common.OIDCIdentity, 0, len(identities)),
+	}
+
+	for _, order := range orders {
+		if order.BonusesWithdrawn != nil && *order.BonusesWithdrawn != 0 {
+			export.Withdrawals = append(export.Withdrawals, withdrawals[order.Order].toCommon())
+		}
+		export.Orders = append(export.Orders, order)
+	}
+	for _, line := range balanceHistory {
+		export.BalanceHistory = append(export.BalanceHistory, line.toCommon())
+	}
+	for _, session := range sessions {
+		export.Sessions = append(export.Sessions, session.toCommon())
+	}
+	for _, apiKey := range apiKeys {
+		apiKey.Login = user.Login
+		export.APIKeys = append(export.APIKeys, apiKey.toCommon())
+	}
+	for _, identity := range identities {
+		export.Identities = append(export.Identities, common.OIDCIdentity{
+			Issuer:    identity.Issuer,
+			Subject:   identity.Subject,
+			CreatedAt: identity.CreatedAt,
+		})
+	}
+
+	return export, nil
+}
+
+// DeleteAccount accepts context and user ID and anonymises the account: the login
+// is replaced, the password, roles and two-factor authentication are removed,
+// sessions and API keys are revoked, linked identities and reset tokens are deleted.
+// Orders, withdrawals and the balance are kept for accounting.
+// It returns ErrNoRows if there is no such user or it is already deleted.
+func (storage *PsqURLlStorage) DeleteAccount(ctx context.Context, userID int64) error {
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	now := time.Now()
+
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		result, err := tx.NewUpdate().
+			Model((*User)(nil)).
+			Set("login = ?", fmt.Sprintf(deletedLoginFormat, userID)).
+			Set("password = ''").
+			Set("roles = '{}'").
+			Set("totp_secret = NULL, totp_enabled = false, recovery_codes = '{}'").
+			Set("session_version = session_version + 1").
+			Set("deleted_at = ?", now).
+			Where("id = ? AND deleted_at IS NULL", userID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if err = checkAffected(result); err != nil {
+			return err
+		}
+
+		_, err = tx.NewUpdate().
+			Model((*Session)(nil)).
+			Set("revoked_at = ?", now).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.NewUpdate().
+			Model((*APIKey)(nil)).
+			Set("revoked_at = ?", now).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.NewDelete().
+			Model((*OIDCIdentity)(nil)).
+			Where("user_id = ?", userID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.NewDelete().
+			Model((*PasswordResetToken)(nil)).
+			Where("user_id = ?", userID).
+			Exec(ctx)
+		return err
+	})
+	if errors.Is(err, ErrNoRows) {
+		return err
+	}
+	if err != nil {
+		logger.ErrorLogger("Error deleting account: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// toAccountInfo returns the data about a user shown to support staff and in exports.
+func (user User) toAccountInfo() common.AccountInfo {
+	info := common.AccountInfo{
+		ID:          user.ID,
+		Login:       user.Login,
+		Roles:       user.Roles,
+		Balance:     user.Balance,
+		Withdrawn:   user.Withdrawn,
+		TwoFactor:   user.TOTPEnabled,
+		State:       user.State,
+		StateReason: user.StateReason,
+	}
+	if !user.StateChangedAt.IsZero() {
+		info.StateChangedAt = &user.StateChangedAt
+	}
+	if !user.DeletedAt.IsZero() {
+		info.DeletedAt = &user.DeletedAt
+	}
+	return info
+}
@@ -0,0 +1,175 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// CreateAPIKey accepts context, an API key description and the key itself.
+// It stores a hash of the key for the user found by login and returns
+// the stored API key. It returns ErrUnknownScope if a scope is not one of common.KnownScopes
+// and ErrNoRows if there is no such user or the account is deleted.
+func (storage *PsqURLlStorage) CreateAPIKey(ctx context.Context, apiKey common.APIKey, key string) (common.APIKey, error) {
+	for _, scope := range apiKey.Scopes {
+		if !common.Contains(common.KnownScopes, scope) {
+			return common.APIKey{}, ErrUnknownScope
+		}
+	}
+
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	var user User
+
+	err := db.NewSelect().
+		Model(&user).
+		Column("id", "login").
+		Where("lower(login) = lower(?) AND deleted_at IS NULL", apiKey.Login).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.APIKey{}, ErrNoRows
+	}
+	if err != nil {
+		logger.ErrorLogger("Error finding user: ", err)
+		return common.APIKey{}, err
+	}
+
+	row := &APIKey{
+		UserID:    user.ID,
+		Name:      apiKey.Name,
+		Prefix:    apiKey.Prefix,
+		KeyHash:   hashToken(key),
+		Scopes:    apiKey.Scopes,
+		RateLimit: apiKey.RateLimit,
+		CreatedAt: time.Now(),
+	}
+
+	_, err = db.NewInsert().
+		Model(row).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error writing API key: ", err)
+		return common.APIKey{}, err
+	}
+	row.Login = user.Login
+
+	return row.toCommon(), nil
+}
+
+// GetAPIKey accepts context and an API key and returns the key description
+// with the owner's user ID and account state. It returns ErrNoRows if the key is unknown or revoked.
+func (storage *PsqURLlStorage) GetAPIKey(ctx context.Context, key string) (common.APIKey, error) {
+	var row APIKey
+
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	err := db.NewSelect().
+		Model(&row).
+		ColumnExpr("api_key.*").
+		ColumnExpr("u.login, u.state AS account_state").
+		Join("JOIN users AS u ON u.id = api_key.user_id").
+		Where("api_key.key_hash = ? AND api_key.revoked_at IS NULL", hashToken(key)).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.APIKey{}, ErrNoRows
+	}
+	if err != nil {
+		logger.ErrorLogger("Error finding API key: ", err)
+		return common.APIKey{}, err
+	}
+
+	return row.toCommon(), nil
+}
+
+// GetAPIKeys accepts context and login and returns all API keys of the user,
+// or of all users if login is empty, ordered from new to old ones.
+func (storage *PsqURLlStorage) GetAPIKeys(ctx context.Context, login string) ([]common.APIKey, error) {
+	var rows []APIKey
+
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	query := db.NewSelect().
+		Model(&rows).
+		ColumnExpr("api_key.*").
+		ColumnExpr("u.login").
+		Join("JOIN users AS u ON u.id = api_key.user_id").
+		Order("api_key.created_at DESC")
+	if login != "" {
+		query = query.Where("lower(u.login) = lower(?)", login)
+	}
+
+	err := query.Scan(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error getting API keys: ", err)
+		return nil, err
+	}
+
+	apiKeys := make([]common.APIKey, 0, len(rows))
+	for _, row := range rows {
+		apiKeys = append(apiKeys, row.toCommon())
+	}
+	return apiKeys, nil
+}
+
+// RevokeAPIKey accepts context and an API key ID and revokes the key.
+// Revoking a revoked key is not an error. It returns ErrNoRows if there is no such key.
+func (storage *PsqURLlStorage) RevokeAPIKey(ctx context.Context, id int64) error {
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	result, err := db.NewUpdate().
+		Model((*APIKey)(nil)).
+		Set("revoked_at = COALESCE(revoked_at, ?)", time.Now()).
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error revoking API key: ", err)
+		return err
+	}
+
+	return checkAffected(result)
+}
+
+// TouchAPIKey accepts context, an API key ID and time and records it as the last use of the key.
+func (storage *PsqURLlStorage) TouchAPIKey(ctx context.Context, id int64, usedAt time.Time) error {
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	_, err := db.NewUpdate().
+		Model((*APIKey)(nil)).
+		Set("last_used_at = ?", usedAt).
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error updating API key usage: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// toCommon converts an API key row to common.APIKey.
+func (row APIKey) toCommon() common.APIKey {
+	apiKey := common.APIKey{
+		ID:           row.ID,
+		UserID:       row.UserID,
+		Login:        row.Login,
+		Name:         row.Name,
+		Prefix:       row.Prefix,
+		Scopes:       row.Scopes,
+		RateLimit:    row.RateLimit,
+		CreatedAt:    row.CreatedAt,
+		AccountState: row.AccountState,
+	}
+	if !row.LastUsedAt.IsZero() {
+		apiKey.LastUsedAt = &row.LastUsedAt
+	}
+	if !row.RevokedAt.IsZero() {
+		apiKey.RevokedAt = &row.RevokedAt
+	}
+	return apiKey
+}
@@ -0,0 +1,110 @@
+package psql
+
+import (
+	"context"
+	"time"
+
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// StreamOrders accepts context, user ID, a query and a function, and calls the function with every
+// order of the user matching the query filters, ordered by the upload time. Orders are read from
+// the database cursor one by one, the page of the query except the sort direction is ignored.
+// It stops at the first error returned by the function and returns it.
+func (storage *PsqURLlStorage) StreamOrders(ctx context.Context, userID int64, query common.OrdersQuery, fn func(common.Order) error) error {
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	rows, err := ordersFilter(db.NewSelect().Model((*common.Order)(nil)).Column(orderColumns...), userID, query).
+		OrderExpr(orderByUploadTime(query.Desc)).
+		Rows(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error getting orders: ", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row common.Order
+		if err := db.ScanRow(ctx, rows, &row); err != nil {
+			logger.ErrorLogger("Error scanning order: ", err)
+			return err
+		}
+		err := fn(common.Order{
+			Order:      row.Order,
+			UploadedAt: row.UploadedAt,
+			Status:     row.Status,
+			Accrual:    row.Accrual,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// StreamWithdrawals accepts context, user ID, a query and a function, and calls the function with
+// every withdrawal of the user matching the query filters, ordered by time. Withdrawals are read from
+// the database cursor one by one, the page of the query except the sort direction is ignored.
+// It stops at the first error returned by the function and returns it.
+func (storage *PsqURLlStorage) StreamWithdrawals(ctx context.Context, userID int64, query common.WithdrawalsQuery, fn func(common.OrdersWithSpentBonuses) error) error {
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	rows, err := withdrawalsFilter(db.NewSelect().Model((*Withdrawal)(nil)), userID, query).
+		OrderExpr(orderByUploadTime(query.Desc)).
+		Rows(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error getting withdrawals: ", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row Withdrawal
+		if err := db.ScanRow(ctx, rows, &row); err != nil {
+			logger.ErrorLogger("Error scanning withdrawal: ", err)
+			return err
+		}
+		if err := fn(row.toCommon()); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// StreamStatement accepts context, user ID, a period and a function, and calls the function with
+// every change of the user's balance made in the period, ordered by time, as GetStatement returns them.
+// Changes are read from the database cursor one by one.
+// It stops at the first error returned by the function and returns it.
+func (storage *PsqURLlStorage) StreamStatement(ctx context.Context, userID int64, from *time.Time, to *time.Time, fn func(common.BalanceChange) error) error {
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	rows, err := statementQuery(db, userID, from, to).Rows(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error getting statement: ", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var line BalanceLine
+		if err := db.ScanRow(ctx, rows, &line); err != nil {
+			logger.ErrorLogger("Error scanning statement: ", err)
+			return err
+		}
+		if err := fn(line.toCommon()); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// orderByUploadTime returns an ORDER BY expression sorting orders by the upload time and the number.
+func orderByUploadTime(desc bool) string {
+	if desc {
+		return `uploaded_at DESC, "order" DESC`
+	}
+	return `uploaded_at ASC, "order" ASC`
+}
@@ -0,0 +1,105 @@
+package psql
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// ReserveIdempotencyKey accepts context and a key of a user with the request fingerprint and
+// the expiration time, and reserves the key for the request. Expired keys of the user are deleted
+// first, so an expired key can be used again. If the key is reserved, it returns true. Otherwise,
+// it returns the key as it was recorded by the first request and false.
+func (storage *PsqURLlStorage) ReserveIdempotencyKey(ctx context.Context, key common.IdempotencyKey) (common.IdempotencyKey, bool, error) {
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	now := time.Now()
+
+	_, err := db.NewDelete().
+		Model((*IdempotencyKey)(nil)).
+		Where("user_id = ? AND expires_at <= ?", key.UserID, now).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error deleting expired idempotency keys: ", err)
+		return common.IdempotencyKey{}, false, err
+	}
+
+	row := IdempotencyKey{
+		UserID:      key.UserID,
+		Key:         key.Key,
+		Fingerprint: key.Fingerprint,
+		CreatedAt:   now,
+		ExpiresAt:   key.ExpiresAt,
+	}
+	result, err := db.NewInsert().
+		Model(&row).
+		On(`CONFLICT (user_id, "key") DO NOTHING`).
+		Exec(ctx)
+	if err == nil {
+		err = checkAffected(result)
+	}
+	if err == nil {
+		return key, true, nil
+	}
+	if !errors.Is(err, ErrNoRows) {
+		logger.ErrorLogger("Error reserving idempotency key: ", err)
+		return common.IdempotencyKey{}, false, err
+	}
+
+	err = db.NewSelect().
+		Model(&row).
+		Where(`user_id = ? AND "key" = ?`, key.UserID, key.Key).
+		Scan(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error finding idempotency key: ", err)
+		return common.IdempotencyKey{}, false, err
+	}
+
+	return common.IdempotencyKey{
+		UserID:      row.UserID,
+		Key:         row.Key,
+		Fingerprint: row.Fingerprint,
+		StatusCode:  row.StatusCode,
+		ContentType: row.ContentType,
+		Body:        row.Response,
+		ExpiresAt:   row.ExpiresAt,
+	}, false, nil
+}
+
+// SaveIdempotentResponse accepts context, user ID, a key and the response to the request
+// the key is reserved for, and records the response to be replayed.
+func (storage *PsqURLlStorage) SaveIdempotentResponse(ctx context.Context, userID int64, key string, statusCode int, contentType string, body []byte) error {
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	_, err := db.NewUpdate().
+		Model((*IdempotencyKey)(nil)).
+		Set("status_code = ?", statusCode).
+		Set("content_type = ?", contentType).
+		Set("response = ?", body).
+		Where(`user_id = ? AND "key" = ?`, userID, key).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error saving idempotent response: ", err)
+	}
+	return err
+}
+
+// ReleaseIdempotencyKey accepts context, user ID and a key, and deletes the key,
+// so the request can be retried with it.
+func (storage *PsqURLlStorage) ReleaseIdempotencyKey(ctx context.Context, userID int64, key string) error {
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	_, err := db.NewDelete().
+		Model((*IdempotencyKey)(nil)).
+		Where(`user_id = ? AND "key" = ?`, userID, key).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error releasing idempotency key: ", err)
+	}
+	return err
+}
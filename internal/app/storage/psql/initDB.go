@@ -3,6 +3,7 @@ package psql
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"time"
 
 	"github.com/knstch/gophermart/internal/app/logger"
@@ -11,8 +12,67 @@ import (
 	"github.com/uptrace/bun/dialect/pgdialect"
 )
 
-// A functing receiving database params (*sql.DB) and creates Users and Orders tables
-// in the database. The function returns an error.
+// Statements bringing the users table created by older versions up to date.
+// They run before other tables are created, as those reference users by ID.
+// Every statement has to be safe to run on each start.
+var userMigrations = []string{
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS session_version integer NOT NULL DEFAULT 0`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS roles text[] NOT NULL DEFAULT '{user}'`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS id bigserial UNIQUE`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at timestamp`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret varchar(64)`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled boolean NOT NULL DEFAULT false`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_counter bigint NOT NULL DEFAULT 0`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS recovery_codes text[] NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS state varchar(16) NOT NULL DEFAULT 'active'`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS state_reason text NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS state_changed_at timestamp`,
+	`DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = 'users'::regclass AND contype = 'p') THEN
+		ALTER TABLE users ADD PRIMARY KEY (id);
+	END IF;
+END $$`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS users_lower_login_idx ON users (lower(login))`,
+}
+
+// Statements bringing other tables created by older versions up to date.
+// Every statement has to be safe to run on each start.
+var migrations = []string{
+	moveToUserID("orders", "RESTRICT"),
+	moveToUserID("password_reset_tokens", "CASCADE"),
+	moveToUserID("api_keys", "CASCADE"),
+	moveToUserID("sessions", "CASCADE"),
+	`ALTER TABLE sessions ADD COLUMN IF NOT EXISTS second_factor boolean NOT NULL DEFAULT false`,
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS reversed_at timestamp`,
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS reversed_by bigint REFERENCES users (id) ON DELETE SET NULL`,
+	`CREATE INDEX IF NOT EXISTS orders_user_id_idx ON orders (user_id)`,
+	`CREATE INDEX IF NOT EXISTS orders_user_id_uploaded_at_idx ON orders (user_id, uploaded_at, "order")`,
+	`CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id)`,
+	`CREATE INDEX IF NOT EXISTS account_state_changes_user_id_idx ON account_state_changes (user_id)`,
+	`CREATE INDEX IF NOT EXISTS order_status_changes_order_idx ON order_status_changes ("order")`,
+}
+
+// moveToUserID returns a statement replacing the login column of a table
+// created by an older version with a user_id column referencing users.
+func moveToUserID(table string, onDelete string) string {
+	return fmt.Sprintf(`DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = '%[1]s' AND column_name = 'login') THEN
+		ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS user_id bigint;
+		UPDATE %[1]s SET user_id = users.id FROM users WHERE %[1]s.login = users.login;
+		ALTER TABLE %[1]s ALTER COLUMN user_id SET NOT NULL;
+		ALTER TABLE %[1]s ADD FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE %[2]s;
+		ALTER TABLE %[1]s DROP COLUMN login;
+	END IF;
+END $$`, table, onDelete)
+}
+
+// A functing receiving database params (*sql.DB) and creates Users, Orders, PasswordResetTokens,
+// APIKeys, Sessions, OIDCIdentities, AccountStateChanges, OrderStatusChanges and IdempotencyKeys
+// tables in the database applying migrations.
+// Tables keeping user data reference users by ID. The function returns an error.
 func InitDB(dbParams *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -25,13 +85,95 @@ func InitDB(dbParams *sql.DB) error {
 		return err
 	}
 
-	_, err = db.NewCreateTable().Model((*Orders)(nil)).IfNotExists().Exec(ctx)
+	err = migrate(ctx, db, userMigrations)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateTable().Model((*Orders)(nil)).IfNotExists().
+		ForeignKey(`(user_id) REFERENCES users (id) ON DELETE RESTRICT`).
+		ForeignKey(`(reversed_by) REFERENCES users (id) ON DELETE SET NULL`).
+		Exec(ctx)
 	if err != nil {
 		logger.ErrorLogger("Error initing table Orders: ", err)
 		return err
 	}
 
+	_, err = db.NewCreateTable().Model((*PasswordResetTokens)(nil)).IfNotExists().
+		ForeignKey(`(user_id) REFERENCES users (id) ON DELETE CASCADE`).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error initing table PasswordResetTokens: ", err)
+		return err
+	}
+
+	_, err = db.NewCreateTable().Model((*APIKeys)(nil)).IfNotExists().
+		ForeignKey(`(user_id) REFERENCES users (id) ON DELETE CASCADE`).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error initing table APIKeys: ", err)
+		return err
+	}
+
+	_, err = db.NewCreateTable().Model((*Sessions)(nil)).IfNotExists().
+		ForeignKey(`(user_id) REFERENCES users (id) ON DELETE CASCADE`).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error initing table Sessions: ", err)
+		return err
+	}
+
+	_, err = db.NewCreateTable().Model((*OIDCIdentities)(nil)).IfNotExists().
+		ForeignKey(`(user_id) REFERENCES users (id) ON DELETE CASCADE`).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error initing table OIDCIdentities: ", err)
+		return err
+	}
+
+	_, err = db.NewCreateTable().Model((*AccountStateChanges)(nil)).IfNotExists().
+		ForeignKey(`(user_id) REFERENCES users (id) ON DELETE CASCADE`).
+		ForeignKey(`(changed_by) REFERENCES users (id) ON DELETE SET NULL`).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error initing table AccountStateChanges: ", err)
+		return err
+	}
+
+	_, err = db.NewCreateTable().Model((*OrderStatusChanges)(nil)).IfNotExists().
+		ForeignKey(`("order") REFERENCES orders ("order") ON DELETE CASCADE`).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error initing table OrderStatusChanges: ", err)
+		return err
+	}
+
+	_, err = db.NewCreateTable().Model((*IdempotencyKeys)(nil)).IfNotExists().
+		ForeignKey(`(user_id) REFERENCES users (id) ON DELETE CASCADE`).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error initing table IdempotencyKeys: ", err)
+		return err
+	}
+
+	err = migrate(ctx, db, migrations)
+	if err != nil {
+		return err
+	}
+
 	logger.InfoLogger("Tables inited")
 
 	return nil
 }
+
+// migrate runs migration statements one by one.
+func migrate(ctx context.Context, db *bun.DB, statements []string) error {
+	for _, statement := range statements {
+		_, err := db.ExecContext(ctx, statement)
+		if err != nil {
+			logger.ErrorLogger("Error migrating tables: ", err)
+			return err
+		}
+	}
+	return nil
+}
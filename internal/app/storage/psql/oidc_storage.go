@@ -0,0 +1,127 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// GetOIDCAccount accepts context, an issuer and a subject of an external identity
+// and returns the account the identity is linked to, or ErrNoRows if it is not linked
+// to an active account.
+func (storage *PsqURLlStorage) GetOIDCAccount(ctx context.Context, issuer string, subject string) (common.Account, error) {
+	var user User
+
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	err := db.NewSelect().
+		Model(&user).
+		Column("user.id", "user.login", "user.session_version", "user.roles", "user.totp_enabled", "user.state").
+		Join("JOIN oidc_identities AS i ON i.user_id = \"user\".id").
+		Where("i.issuer = ? AND i.subject = ?", issuer, subject).
+		Where("\"user\".deleted_at IS NULL").
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.Account{}, ErrNoRows
+	}
+	if err != nil {
+		logger.ErrorLogger("Error finding identity: ", err)
+		return common.Account{}, err
+	}
+
+	return common.Account{
+		ID:             user.ID,
+		Login:          user.Login,
+		SessionVersion: user.SessionVersion,
+		Roles:          user.Roles,
+		TOTPEnabled:    user.TOTPEnabled,
+		State:          user.State,
+	}, nil
+}
+
+// LinkOIDCIdentity accepts context, user ID, an issuer and a subject and links
+// the external identity to the user. Linking an identity to its account again is not
+// an error. It returns ErrIdentityLinked if the identity is linked to another account.
+func (storage *PsqURLlStorage) LinkOIDCIdentity(ctx context.Context, userID int64, issuer string, subject string) error {
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	return linkIdentity(ctx, db, userID, issuer, subject)
+}
+
+// CreateOIDCAccount accepts context, a normalized login, an issuer and a subject.
+// It registers a user without a password, so the user can log in only through
+// the identity provider or after a password reset, links the identity to it
+// and returns the new account. If a login differing only by case is already
+// registered, it returns ErrLoginTaken.
+func (storage *PsqURLlStorage) CreateOIDCAccount(ctx context.Context, login string, issuer string, subject string) (common.Account, error) {
+	user := &User{Login: login}
+
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewInsert().
+			Model(user).
+			Returning("id, session_version, roles, state").
+			Exec(ctx)
+		if isLoginTaken(err) {
+			return ErrLoginTaken
+		}
+		if err != nil {
+			return err
+		}
+
+		return linkIdentity(ctx, tx, user.ID, issuer, subject)
+	})
+	if errors.Is(err, ErrLoginTaken) || errors.Is(err, ErrIdentityLinked) {
+		return common.Account{}, err
+	}
+	if err != nil {
+		logger.ErrorLogger("Error creating account: ", err)
+		return common.Account{}, err
+	}
+
+	return common.Account{
+		ID:             user.ID,
+		Login:          user.Login,
+		SessionVersion: user.SessionVersion,
+		Roles:          user.Roles,
+		State:          user.State,
+	}, nil
+}
+
+// linkIdentity inserts a link of an external identity to a user. A conflicting link
+// is left as it is, so nothing is changed if the identity belongs to another user.
+func linkIdentity(ctx context.Context, db bun.IDB, userID int64, issuer string, subject string) error {
+	identity := &OIDCIdentity{
+		Issuer:    issuer,
+		Subject:   subject,
+		UserID:    userID,
+		CreatedAt: time.Now(),
+	}
+
+	result, err := db.NewInsert().
+		Model(identity).
+		On("CONFLICT (issuer, subject) DO UPDATE").
+		Set("user_id = EXCLUDED.user_id").
+		Where("oidc_identity.user_id = EXCLUDED.user_id").
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error linking identity: ", err)
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrIdentityLinked
+	}
+	return nil
+}
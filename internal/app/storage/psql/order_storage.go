@@ -0,0 +1,219 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/logger"
+	validitycheck "github.com/knstch/gophermart/internal/app/validityCheck"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// GetOrder accepts context and an order number and returns the order with the timeline
+// of its status changes ordered from old to new ones. Orders uploaded before the timeline
+// was recorded have only the changes made since. It returns ErrNoRows if there is no such order.
+func (storage *PsqURLlStorage) GetOrder(ctx context.Context, number string) (common.OrderDetails, error) {
+	var order common.Order
+
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	err := db.NewSelect().
+		Model(&order).
+		Column(orderColumns...).
+		Where(`"order" = ?`, number).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.OrderDetails{}, ErrNoRows
+	}
+	if err != nil {
+		logger.ErrorLogger("Error finding order: ", err)
+		return common.OrderDetails{}, err
+	}
+
+	var rows []OrderStatusChange
+	err = db.NewSelect().
+		Model(&rows).
+		Where(`"order" = ?`, number).
+		Order("changed_at ASC", "id ASC").
+		Scan(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error getting order status changes: ", err)
+		return common.OrderDetails{}, err
+	}
+
+	details := common.OrderDetails{
+		UserID:     order.UserID,
+		Order:      order.Order,
+		Status:     order.Status,
+		UploadedAt: order.UploadedAt,
+		Accrual:    order.Accrual,
+		Timeline:   make([]common.OrderStatusChange, 0, len(rows)),
+	}
+	if order.BonusesWithdrawn != nil && *order.BonusesWithdrawn > 0 {
+		details.BonusesWithdrawn = order.BonusesWithdrawn
+	}
+	for _, row := range rows {
+		details.Timeline = append(details.Timeline, row.toCommon())
+	}
+	return details, nil
+}
+
+// InsertOrders accepts context, user ID and order numbers and uploads them in one transaction.
+// Every number is checked with the Luhn algorithm and against orders uploaded before, and
+// the result is returned for every number in the same order. A number repeated in the batch
+// is accepted once and is already the user's after that.
+func (storage *PsqURLlStorage) InsertOrders(ctx context.Context, userID int64, numbers []string) ([]common.OrderUploadResult, error) {
+	results := make([]common.OrderUploadResult, len(numbers))
+	var valid []string
+	for i, number := range numbers {
+		results[i].Number = number
+		if !validitycheck.LuhnAlgorithm(number) {
+			results[i].Result = common.UploadInvalid
+			continue
+		}
+		valid = append(valid, number)
+	}
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	now := time.Now()
+	bonusesWithdrawn := float32(0)
+
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		owners, err := orderOwners(ctx, tx, valid)
+		if err != nil {
+			return err
+		}
+
+		var orders []common.Order
+		for i := range results {
+			if results[i].Result == common.UploadInvalid {
+				continue
+			}
+			owner, ok := owners[results[i].Number]
+			switch {
+			case !ok:
+				owners[results[i].Number] = userID
+				results[i].Result = common.UploadAccepted
+				orders = append(orders, common.Order{
+					UserID:           userID,
+					Order:            results[i].Number,
+					UploadedAt:       now.Format(time.RFC3339),
+					Status:           common.OrderNew,
+					BonusesWithdrawn: &bonusesWithdrawn,
+				})
+			case owner == userID:
+				results[i].Result = common.UploadAlreadyYours
+			default:
+				results[i].Result = common.UploadOwnedByOther
+			}
+		}
+		if len(orders) == 0 {
+			return nil
+		}
+
+		// Orders uploaded by concurrent requests after the check are skipped
+		// and their results are corrected below.
+		var inserted []string
+		_, err = tx.NewInsert().
+			Model(&orders).
+			On(`CONFLICT ("order") DO NOTHING`).
+			Returning(`"order"`).
+			Exec(ctx, &inserted)
+		if err != nil {
+			return err
+		}
+
+		changes := make([]OrderStatusChange, 0, len(inserted))
+		insertedSet := make(map[string]bool, len(inserted))
+		for _, number := range inserted {
+			insertedSet[number] = true
+			changes = append(changes, OrderStatusChange{Order: number, Status: common.OrderNew, ChangedAt: now})
+		}
+
+		if len(inserted) < len(orders) {
+			var skipped []string
+			for _, order := range orders {
+				if !insertedSet[order.Order] {
+					skipped = append(skipped, order.Order)
+				}
+			}
+			owners, err = orderOwners(ctx, tx, skipped)
+			if err != nil {
+				return err
+			}
+			for i := range results {
+				if results[i].Result != common.UploadAccepted || insertedSet[results[i].Number] {
+					continue
+				}
+				results[i].Result = common.UploadOwnedByOther
+				if owners[results[i].Number] == userID {
+					results[i].Result = common.UploadAlreadyYours
+				}
+			}
+		}
+
+		if len(changes) == 0 {
+			return nil
+		}
+		_, err = tx.NewInsert().
+			Model(&changes).
+			Exec(ctx)
+		return err
+	})
+	if err != nil {
+		logger.ErrorLogger("Error writing orders: ", err)
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// orderOwners returns user IDs of the users who uploaded the orders, keyed by order number.
+// Numbers which are not uploaded are left out.
+func orderOwners(ctx context.Context, db bun.IDB, numbers []string) (map[string]int64, error) {
+	var orders []common.Order
+	err := db.NewSelect().
+		Model(&orders).
+		Column("user_id", "order").
+		Where(`"order" IN (?)`, bun.In(numbers)).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[string]int64, len(orders))
+	for _, order := range orders {
+		owners[order.Order] = order.UserID
+	}
+	return owners, nil
+}
+
+// recordOrderStatus adds a change to the timeline of an order.
+func recordOrderStatus(ctx context.Context, db bun.IDB, change OrderStatusChange) error {
+	_, err := db.NewInsert().
+		Model(&change).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error recording order status change: ", err)
+	}
+	return err
+}
+
+// toCommon converts an order status change row to common.OrderStatusChange.
+func (row OrderStatusChange) toCommon() common.OrderStatusChange {
+	return common.OrderStatusChange{
+		From:      row.FromStatus,
+		Status:    row.Status,
+		Accrual:   row.Accrual,
+		Payload:   row.Payload,
+		ChangedAt: row.ChangedAt,
+	}
+}
@@ -0,0 +1,147 @@
+package psql
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// ChangePassword accepts context, user ID and a new password. It sets the password,
+// revokes all sessions and unused reset tokens of the user and returns the new session version.
+func (storage *PsqURLlStorage) ChangePassword(ctx context.Context, userID int64, password string) (int, error) {
+	var user User
+
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var err error
+		user, err = setPassword(ctx, tx, userID, password)
+		return err
+	})
+	if err != nil {
+		logger.ErrorLogger("Error changing password: ", err)
+		return 0, err
+	}
+
+	return user.SessionVersion, nil
+}
+
+// CreateResetToken accepts context, login, a password reset token and its expiration time.
+// It stores a hash of the token for the user found by login ignoring the case
+// and returns the stored login, or ErrNoRows if there is no such user.
+func (storage *PsqURLlStorage) CreateResetToken(ctx context.Context, login string, token string, expiresAt time.Time) (string, error) {
+	var user User
+
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	err := db.NewSelect().
+		Model(&user).
+		Column("id", "login").
+		Where("lower(login) = lower(?) AND deleted_at IS NULL", login).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNoRows
+	}
+	if err != nil {
+		logger.ErrorLogger("Error finding user: ", err)
+		return "", err
+	}
+
+	resetToken := &PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: expiresAt,
+	}
+
+	_, err = db.NewInsert().
+		Model(resetToken).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error writing reset token: ", err)
+		return "", err
+	}
+
+	return user.Login, nil
+}
+
+// ResetPassword accepts context, a password reset token and a new password.
+// It uses the token once, sets the password, revokes all sessions and other reset tokens
+// of the user and returns the login. If the token is unknown, expired or already used,
+// it returns ErrInvalidResetToken.
+func (storage *PsqURLlStorage) ResetPassword(ctx context.Context, token string, password string) (string, error) {
+	var user User
+
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewUpdate().
+			Model((*PasswordResetToken)(nil)).
+			Set("used_at = ?", time.Now()).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(token), time.Now()).
+			Returning("user_id").
+			Scan(ctx, &user.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidResetToken
+		}
+		if err != nil {
+			return err
+		}
+
+		user, err = setPassword(ctx, tx, user.ID, password)
+		return err
+	})
+	if errors.Is(err, ErrInvalidResetToken) {
+		return "", err
+	}
+	if err != nil {
+		logger.ErrorLogger("Error resetting password: ", err)
+		return "", err
+	}
+
+	return user.Login, nil
+}
+
+// setPassword sets a password of a user inside a transaction, bumps the session version
+// and marks all unused reset tokens of the user as used. It returns the user
+// with the login and the new session version.
+func setPassword(ctx context.Context, tx bun.Tx, userID int64, password string) (User, error) {
+	var user User
+
+	err := tx.NewUpdate().
+		Model((*User)(nil)).
+		Set("password = ?", password).
+		Set("session_version = session_version + 1").
+		Where("id = ?", userID).
+		Returning("login, session_version").
+		Scan(ctx, &user.Login, &user.SessionVersion)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrNoRows
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	_, err = tx.NewUpdate().
+		Model((*PasswordResetToken)(nil)).
+		Set("used_at = ?", time.Now()).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Exec(ctx)
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// hashToken returns a hex encoded SHA-256 hash of a token, so tokens are never stored as is.
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
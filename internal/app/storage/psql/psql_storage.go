@@ -3,19 +3,29 @@ package psql
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"time"
 
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/events"
 	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/knstch/gophermart/internal/app/pagination"
 	validitycheck "github.com/knstch/gophermart/internal/app/validityCheck"
 	"github.com/uptrace/bun"
 	"github.com/uptrace/bun/dialect/pgdialect"
 )
 
+// Columns of the orders table in the order rows are scanned in.
+var orderColumns = []string{"user_id", "order", "status", "uploaded_at", "bonuses_withdrawn", "accrual"}
+
 // Register is used to add users to the database.
-// It accepts context, login and password, inserts them to the database,
-// and returns an error.
-func (storage *PsqURLlStorage) Register(ctx context.Context, login string, password string) error {
+// It accepts context, normalized login and password, inserts them to the database,
+// and returns the new account and an error. If a login differing only by case
+// is already registered, it returns ErrLoginTaken.
+func (storage *PsqURLlStorage) Register(ctx context.Context, login string, password string) (common.Account, error) {
 	credentials := &User{
 		Login:    login,
 		Password: password,
@@ -25,47 +35,99 @@ func (storage *PsqURLlStorage) Register(ctx context.Context, login string, passw
 
 	_, err := db.NewInsert().
 		Model(credentials).
+		Returning("id, session_version, roles, state").
 		Exec(ctx)
 
+	if isLoginTaken(err) {
+		return common.Account{}, ErrLoginTaken
+	}
 	if err != nil {
 		logger.ErrorLogger("Error writing data: ", err)
-		return err
+		return common.Account{}, err
 	}
 
-	return nil
+	return common.Account{
+		ID:             credentials.ID,
+		Login:          credentials.Login,
+		SessionVersion: credentials.SessionVersion,
+		Roles:          credentials.Roles,
+		State:          credentials.State,
+	}, nil
 }
 
 // CheckCredentials accepts context, login and password, then check if
-// there is a match in the database. If nothing was found,
-// it returns error.
-func (storage *PsqURLlStorage) CheckCredentials(ctx context.Context, login string, password string) error {
+// there is a match in the database ignoring the login case. It returns the account
+// with the login as it is stored, or ErrWrongCredentials if nothing was found.
+// Deleted accounts never match.
+func (storage *PsqURLlStorage) CheckCredentials(ctx context.Context, login string, password string) (common.Account, error) {
 	var user User
 
 	db := bun.NewDB(storage.db, pgdialect.New())
 
 	err := db.NewSelect().
 		Model(&user).
-		Where("login = ? and password = ?", login, password).
+		Where("lower(login) = lower(?) and password = ? and password != '' and deleted_at IS NULL", login, password).
 		Scan(ctx)
 
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.Account{}, ErrWrongCredentials
+	}
+	if err != nil {
+		logger.ErrorLogger("Error checking credentials: ", err)
+		return common.Account{}, err
+	}
+
+	return common.Account{
+		ID:             user.ID,
+		Login:          user.Login,
+		SessionVersion: user.SessionVersion,
+		Roles:          user.Roles,
+		TOTPEnabled:    user.TOTPEnabled,
+		State:          user.State,
+	}, nil
+}
+
+// ChangeLogin accepts context, user ID and a new normalized login and renames the user.
+// Orders, sessions and other data of the user are bound to the user ID and stay intact.
+// If another user has a login differing only by case, it returns ErrLoginTaken.
+// It returns ErrNoRows if there is no such user.
+func (storage *PsqURLlStorage) ChangeLogin(ctx context.Context, userID int64, login string) error {
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	result, err := db.NewUpdate().
+		Model((*User)(nil)).
+		Set("login = ?", login).
+		Where("id = ?", userID).
+		Exec(ctx)
+	if isLoginTaken(err) {
+		return ErrLoginTaken
+	}
 	if err != nil {
+		logger.ErrorLogger("Error changing login: ", err)
 		return err
 	}
 
-	return nil
+	return checkAffected(result)
+}
+
+// isLoginTaken reports whether an error is a violation of a unique index on logins,
+// which users_lower_login_idx makes case-insensitive.
+func isLoginTaken(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation
 }
 
 // InsertOrder is used to insert information about an order to
-// the database. It accepts context, login and order number and returns error.
+// the database. It accepts context, user ID and order number and returns error.
 // Before insering data, it checks the order number using Luhn algorithm,
 // if the number is wrong, it returns a custom error.
-func (storage *PsqURLlStorage) InsertOrder(ctx context.Context, login string, orderNum string) error {
+func (storage *PsqURLlStorage) InsertOrder(ctx context.Context, userID int64, orderNum string) error {
 	now := time.Now()
 
 	bonusesWithdrawn := float32(0)
 
 	userOrder := &common.Order{
-		Login:            login,
+		UserID:           userID,
 		Order:            orderNum,
 		UploadedAt:       now.Format(time.RFC3339),
 		Status:           "NEW",
@@ -94,64 +156,95 @@ func (storage *PsqURLlStorage) InsertOrder(ctx context.Context, login string, or
 			return err
 		}
 
+		err = recordOrderStatus(ctx, db, OrderStatusChange{Order: orderNum, Status: userOrder.Status, ChangedAt: now})
+		if err != nil {
+			return err
+		}
 	}
-	if checkOrder.Login != login && checkOrder.Order == orderNum {
+	if checkOrder.UserID != userID && checkOrder.Order == orderNum {
 		return ErrAlreadyLoadedOrder
-	} else if checkOrder.Login == login && checkOrder.Order == orderNum {
+	} else if checkOrder.UserID == userID && checkOrder.Order == orderNum {
 		return ErrYouAlreadyLoadedOrder
 	}
 
 	return nil
 }
 
-// GetOrders accepts context, login and returns an error and all user's orders
-// ordered from old to new ones in json format.
-func (storage *PsqURLlStorage) GetOrders(ctx context.Context, login string) ([]common.Order, error) {
-	var allOrders []common.Order
-
-	order := new(common.Order)
+// GetOrders accepts context, user ID and a query and returns a page of user's orders
+// matching the query filters, ordered by the upload time, and a cursor of the next page,
+// or nil if there are no more orders. The cursor is the upload time and the number
+// of the last order, so pages stay consistent while new orders are uploaded.
+// All matching orders are returned if the limit of the query is 0.
+func (storage *PsqURLlStorage) GetOrders(ctx context.Context, userID int64, query common.OrdersQuery) ([]common.Order, *pagination.Cursor, error) {
+	var rows []common.Order
 
 	db := bun.NewDB(storage.db, pgdialect.New())
 
-	rows, err := db.NewSelect().
-		Model(order).
-		Where("login = ?", login).
-		Order("uploaded_at ASC").
-		Rows(ctx)
-	rows.Err()
+	direction, compare := "ASC", ">"
+	if query.Desc {
+		direction, compare = "DESC", "<"
+	}
+
+	q := ordersFilter(db.NewSelect().Model(&rows).Column(orderColumns...), userID, query)
+	if query.After != nil {
+		q = q.Where(`(uploaded_at, "order") `+compare+` (?::timestamp, ?)`, query.After.Time, query.After.Key)
+	}
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit + 1)
+	}
+
+	err := q.OrderExpr(`uploaded_at ` + direction + `, "order" ` + direction).
+		Scan(ctx)
 	if err != nil {
 		logger.ErrorLogger("Error getting data: ", err)
-		return nil, err
+		return nil, nil, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var orderRow common.Order
-		err := rows.Scan(&orderRow.Login, &orderRow.Order, &orderRow.Status, &orderRow.UploadedAt, &orderRow.BonusesWithdrawn, &orderRow.Accrual)
-		if err != nil {
-			logger.ErrorLogger("Error scanning data: ", err)
-			return nil, err
-		}
-		allOrders = append(allOrders, common.Order{
-			Order:      orderRow.Order,
-			UploadedAt: orderRow.UploadedAt,
-			Status:     orderRow.Status,
-			Accrual:    orderRow.Accrual,
+	var next *pagination.Cursor
+	if query.Limit > 0 && len(rows) > query.Limit {
+		rows = rows[:query.Limit]
+		last := rows[len(rows)-1]
+		next = &pagination.Cursor{Time: last.UploadedAt, Key: last.Order}
+	}
+
+	orders := make([]common.Order, 0, len(rows))
+	for _, row := range rows {
+		orders = append(orders, common.Order{
+			Order:      row.Order,
+			UploadedAt: row.UploadedAt,
+			Status:     row.Status,
+			Accrual:    row.Accrual,
 		})
 	}
-	return allOrders, nil
+	return orders, next, nil
+}
+
+// ordersFilter restricts a query of orders to orders of a user
+// matching the status and time filters of an orders query.
+func ordersFilter(q *bun.SelectQuery, userID int64, query common.OrdersQuery) *bun.SelectQuery {
+	q = q.Where("user_id = ?", userID)
+	if len(query.Statuses) > 0 {
+		q = q.Where("status IN (?)", bun.In(query.Statuses))
+	}
+	if query.From != nil {
+		q = q.Where("uploaded_at >= ?", *query.From)
+	}
+	if query.To != nil {
+		q = q.Where("uploaded_at < ?", *query.To)
+	}
+	return q
 }
 
-// GetBalance accepts context and login, and returns bonuses balance, withdraw
+// GetBalance accepts context and user ID, and returns bonuses balance, withdraw
 // amount, and error.
-func (storage *PsqURLlStorage) GetBalance(ctx context.Context, login string) (float32, float32, error) {
+func (storage *PsqURLlStorage) GetBalance(ctx context.Context, userID int64) (float32, float32, error) {
 	var user User
 
 	db := bun.NewDB(storage.db, pgdialect.New())
 
 	err := db.NewSelect().
 		Model(&user).
-		Where("login = ?", login).
+		Where("id = ?", userID).
 		Scan(ctx)
 	if err != nil {
 		logger.ErrorLogger("Error finding user's balance: ", err)
@@ -161,105 +254,185 @@ func (storage *PsqURLlStorage) GetBalance(ctx context.Context, login string) (fl
 	return user.Balance, user.Withdrawn, nil
 }
 
-// SpendBonuses accepts context, login, order number, and amount of bonuses to spend.
-// It allows to spend user's bonuses on an order.
+// SpendBonuses accepts context, user ID, order number, and amount of bonuses to spend.
+// It allows to spend user's bonuses on an order, recording the order and taking the bonuses
+// off the balance in one transaction, and publishes the new balance once it is committed.
 // This function returns error in an error case or nil if everything is good.
-func (storage *PsqURLlStorage) SpendBonuses(ctx context.Context, login string, orderNum string, spendBonuses float32) error {
-	bonusesAvailable, _, nil := storage.GetBalance(ctx, login)
-	if bonusesAvailable < spendBonuses {
-		return ErrNotEnoughBalance
-	}
-
+// It returns ErrAccountFrozen if the account is not active.
+func (storage *PsqURLlStorage) SpendBonuses(ctx context.Context, userID int64, orderNum string, spendBonuses float32) error {
 	db := bun.NewDB(storage.db, pgdialect.New())
 
-	checkOrder := new(common.Order)
+	var user User
 
-	now := time.Now()
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		// Locking the user row makes concurrent withdrawals, accruals, reversals and state
+		// changes of the user wait, so neither the state nor the balance can change
+		// between the checks and the update.
+		err := tx.NewSelect().
+			Model(&user).
+			Column("balance", "state").
+			Where("id = ?", userID).
+			For("UPDATE").
+			Scan(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRows
+		}
+		if err != nil {
+			return err
+		}
+		if user.State != common.AccountActive {
+			return ErrAccountFrozen
+		}
+		if user.Balance < spendBonuses {
+			return ErrNotEnoughBalance
+		}
 
-	userOrder := &common.Order{
-		Login:            login,
-		Order:            orderNum,
-		UploadedAt:       now.Format(time.RFC3339),
-		Status:           "NEW",
-		BonusesWithdrawn: &spendBonuses,
-	}
+		checkOrder := new(common.Order)
+		err = tx.NewSelect().
+			Model(checkOrder).
+			Where(`"order" = ?`, orderNum).
+			Scan(ctx)
+		if err == nil {
+			if checkOrder.UserID != userID {
+				return ErrAlreadyLoadedOrder
+			}
+			return ErrYouAlreadyLoadedOrder
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
 
-	err := db.NewSelect().
-		Model(checkOrder).
-		Where(`"order" = ?`, orderNum).
-		Scan(ctx)
-	if err != nil {
-		_, err := db.NewInsert().
-			Model(userOrder).
+		now := time.Now()
+
+		_, err = tx.NewInsert().
+			Model(&common.Order{
+				UserID:           userID,
+				Order:            orderNum,
+				UploadedAt:       now.Format(time.RFC3339),
+				Status:           "NEW",
+				BonusesWithdrawn: &spendBonuses,
+			}).
 			Exec(ctx)
+		if err != nil {
+			return err
+		}
 
+		err = recordOrderStatus(ctx, tx, OrderStatusChange{Order: orderNum, Status: "NEW", ChangedAt: now})
 		if err != nil {
-			logger.ErrorLogger("Error writing data: ", err)
 			return err
 		}
-	}
-	if checkOrder.Login != login && checkOrder.Order == orderNum {
-		return ErrAlreadyLoadedOrder
-	} else if checkOrder.Login == login && checkOrder.Order == orderNum {
-		return ErrYouAlreadyLoadedOrder
-	}
 
-	_, err = db.NewUpdate().
-		TableExpr("users").
-		Set("balance = ?", bonusesAvailable-spendBonuses).
-		Set("withdrawn = withdrawn + ?", spendBonuses).
-		Where("login = ?", login).
-		Exec(ctx)
+		res, err := tx.NewUpdate().
+			Model(&user).
+			Set("balance = balance - ?", spendBonuses).
+			Set("withdrawn = withdrawn + ?", spendBonuses).
+			Where("id = ? AND balance >= ?", userID, spendBonuses).
+			Returning("balance, withdrawn").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return ErrNotEnoughBalance
+		}
+		return nil
+	})
+	if errors.Is(err, ErrNoRows) || errors.Is(err, ErrAccountFrozen) || errors.Is(err, ErrNotEnoughBalance) ||
+		errors.Is(err, ErrAlreadyLoadedOrder) || errors.Is(err, ErrYouAlreadyLoadedOrder) {
+		return err
+	}
 	if err != nil {
 		logger.ErrorLogger("Error withdrawning bonuses from the account: ", err)
 		return err
 	}
 
+	storage.events.Publish(userID, events.Balance, events.BalanceData{Current: user.Balance, Withdrawn: user.Withdrawn})
 	return nil
 }
 
-// This function accepts context and login, and returns an error and json response with orders where a user
-// spent bonuses.
-func (storage *PsqURLlStorage) GetOrdersWithBonuses(ctx context.Context, login string) ([]common.OrdersWithSpentBonuses, error) {
-	var allOrders []common.OrdersWithSpentBonuses
-
-	order := new(common.Order)
+// GetOrdersWithBonuses accepts context, user ID and a query and returns a page of orders
+// where the user spent bonuses matching the query filters, ordered by time, and a cursor
+// of the next page, or nil if there are no more withdrawals. All matching withdrawals
+// are returned if the limit of the query is 0. It returns ErrNoRows if the page is empty.
+func (storage *PsqURLlStorage) GetOrdersWithBonuses(ctx context.Context, userID int64, query common.WithdrawalsQuery) ([]common.OrdersWithSpentBonuses, *pagination.Cursor, error) {
+	var rows []Withdrawal
 
 	db := bun.NewDB(storage.db, pgdialect.New())
 
-	rows, err := db.NewSelect().
-		Model(order).
-		Where("login = ? and bonuses_withdrawn != 0", login).
-		Order("uploaded_at ASC").
-		Rows(ctx)
-	rows.Err()
+	direction, compare := "ASC", ">"
+	if query.Desc {
+		direction, compare = "DESC", "<"
+	}
+
+	q := withdrawalsFilter(db.NewSelect().Model(&rows), userID, query)
+	if query.After != nil {
+		q = q.Where(`(uploaded_at, "order") `+compare+` (?::timestamp, ?)`, query.After.Time, query.After.Key)
+	}
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit + 1)
+	}
+
+	err := q.OrderExpr(`uploaded_at ` + direction + `, "order" ` + direction).
+		Scan(ctx)
 	if err != nil {
 		logger.ErrorLogger("Error getting data: ", err)
-		return nil, err
+		return nil, nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil, ErrNoRows
 	}
-	defer rows.Close()
 
-	noRows := true
-	for rows.Next() {
-		noRows = false
-		var orderRow common.Order
-		err := rows.Scan(&orderRow.Login, &orderRow.Order, &orderRow.Status, &orderRow.UploadedAt, &orderRow.BonusesWithdrawn, &orderRow.Accrual)
-		if err != nil {
-			logger.ErrorLogger("Error scanning data: ", err)
-			return nil, err
-		}
+	var next *pagination.Cursor
+	if query.Limit > 0 && len(rows) > query.Limit {
+		rows = rows[:query.Limit]
+		last := rows[len(rows)-1]
+		next = &pagination.Cursor{Time: last.UploadedAt, Key: last.Order}
+	}
 
-		allOrders = append(allOrders, common.OrdersWithSpentBonuses{
-			Order:            orderRow.Order,
-			Time:             orderRow.UploadedAt,
-			BonusesWithdrawn: *orderRow.BonusesWithdrawn,
-		})
+	withdrawals := make([]common.OrdersWithSpentBonuses, 0, len(rows))
+	for _, row := range rows {
+		withdrawals = append(withdrawals, row.toCommon())
 	}
+	return withdrawals, next, nil
+}
+
+// SumWithdrawals accepts context, user ID and a query and returns the sum of bonuses
+// spent in all withdrawals matching the query filters, leaving reversed withdrawals out.
+// The page of the query is ignored.
+func (storage *PsqURLlStorage) SumWithdrawals(ctx context.Context, userID int64, query common.WithdrawalsQuery) (float32, error) {
+	var total float32
 
-	if noRows {
-		return nil, ErrNoRows
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	err := withdrawalsFilter(db.NewSelect().Model((*Withdrawal)(nil)), userID, query).
+		Where("reversed_at IS NULL").
+		ColumnExpr("COALESCE(SUM(bonuses_withdrawn), 0)").
+		Scan(ctx, &total)
+	if err != nil {
+		logger.ErrorLogger("Error summing withdrawals: ", err)
+		return 0, err
 	}
-	return allOrders, nil
+
+	return total, nil
+}
+
+// withdrawalsFilter restricts a query of orders to withdrawals of a user
+// matching the time and sum filters of a withdrawals query.
+func withdrawalsFilter(q *bun.SelectQuery, userID int64, query common.WithdrawalsQuery) *bun.SelectQuery {
+	q = q.Where("user_id = ? AND bonuses_withdrawn != 0", userID)
+	if query.From != nil {
+		q = q.Where("uploaded_at >= ?", *query.From)
+	}
+	if query.To != nil {
+		q = q.Where("uploaded_at < ?", *query.To)
+	}
+	if query.MinSum != nil {
+		q = q.Where("bonuses_withdrawn >= ?", *query.MinSum)
+	}
+	if query.MaxSum != nil {
+		q = q.Where("bonuses_withdrawn <= ?", *query.MaxSum)
+	}
+	return q
 }
 
 // A function that looking for orders where bonuses were not
@@ -278,6 +451,7 @@ func (storage *PsqURLlStorage) Sync() {
 
 		rows, err := db.NewSelect().
 			Model(order).
+			Column(orderColumns...).
 			Where("status != ? AND status != ?", "PROCESSED", "INVALID").
 			Rows(ctx)
 		rows.Err()
@@ -287,7 +461,7 @@ func (storage *PsqURLlStorage) Sync() {
 
 		for rows.Next() {
 			var orderRow common.Order
-			err := rows.Scan(&orderRow.Login, &orderRow.Order, &orderRow.Status, &orderRow.UploadedAt, &orderRow.BonusesWithdrawn, &orderRow.Accrual)
+			err := rows.Scan(&orderRow.UserID, &orderRow.Order, &orderRow.Status, &orderRow.UploadedAt, &orderRow.BonusesWithdrawn, &orderRow.Accrual)
 			if err != nil {
 				logger.ErrorLogger("Error scanning data: ", err)
 			}
@@ -296,45 +470,92 @@ func (storage *PsqURLlStorage) Sync() {
 				UploadedAt: orderRow.UploadedAt,
 				Status:     orderRow.Status,
 				Accrual:    orderRow.Accrual,
-				Login:      orderRow.Login,
+				UserID:     orderRow.UserID,
 			})
 		}
 		rows.Close()
 
 		for _, unfinishedOrder := range allUnfinishedOrders {
 			finishedOrder := common.GetStatusFromAccrual(unfinishedOrder)
-			storage.UpdateStatus(ctx, finishedOrder, unfinishedOrder.Login)
+			storage.UpdateStatus(ctx, finishedOrder, unfinishedOrder.UserID)
 		}
 	}
 }
 
 // This function works with 2 tables: orders and users. As we get a status update from the accrual system,
-// we make an update in the DB.
-func (storage *PsqURLlStorage) UpdateStatus(ctx context.Context, orderFromAccural common.OrderUpdateFromAccural, login string) error {
-
-	orderModel := &common.Order{}
-	userModel := &User{}
+// we make an update in the DB. A status change is added to the order timeline with the response
+// of the accrual system in the same transaction. An update without a status, which the accrual
+// system returns when it can't be reached, is skipped. After the transaction commits, the status
+// change and the new balance are published to the user's event streams.
+func (storage *PsqURLlStorage) UpdateStatus(ctx context.Context, orderFromAccural common.OrderUpdateFromAccural, userID int64) error {
+	if orderFromAccural.Status == "" {
+		return nil
+	}
 
 	db := bun.NewDB(storage.db, pgdialect.New())
 
-	_, err := db.NewUpdate().
-		Model(orderModel).
-		Set("status = ?, accrual = ?", orderFromAccural.Status, orderFromAccural.Accrual).
-		Where(`"order" = ?`, orderFromAccural.Order).
-		Exec(ctx)
+	var order common.Order
+	var user User
+	changedAt := time.Now()
+
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().
+			Model(&order).
+			Column("status").
+			Where(`"order" = ?`, orderFromAccural.Order).
+			For("UPDATE").
+			Scan(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.NewUpdate().
+			Model((*common.Order)(nil)).
+			Set("status = ?, accrual = ?", orderFromAccural.Status, orderFromAccural.Accrual).
+			Where(`"order" = ?`, orderFromAccural.Order).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.NewUpdate().
+			Model(&user).
+			Set("balance = balance + ?", orderFromAccural.Accrual).
+			Where(`id = ?`, userID).
+			Returning("balance, withdrawn").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		if order.Status == orderFromAccural.Status {
+			return nil
+		}
+		return recordOrderStatus(ctx, tx, OrderStatusChange{
+			Order:      orderFromAccural.Order,
+			FromStatus: order.Status,
+			Status:     orderFromAccural.Status,
+			Accrual:    &orderFromAccural.Accrual,
+			Payload:    orderFromAccural.Payload,
+			ChangedAt:  changedAt,
+		})
+	})
 	if err != nil {
-		logger.ErrorLogger("Error making an update request in order table", err)
+		logger.ErrorLogger("Error updating order status: ", err)
 		return err
 	}
 
-	_, err = db.NewUpdate().
-		Model(userModel).
-		Set("balance = balance + ?", orderFromAccural.Accrual).
-		Where(`login = ?`, login).
-		Exec(ctx)
-	if err != nil {
-		logger.ErrorLogger("Error making an update request in user table", err)
-		return err
+	if order.Status != orderFromAccural.Status {
+		storage.events.Publish(userID, events.OrderStatus, events.OrderStatusData{
+			Number:    orderFromAccural.Order,
+			From:      order.Status,
+			Status:    orderFromAccural.Status,
+			Accrual:   orderFromAccural.Accrual,
+			ChangedAt: changedAt,
+		})
+	}
+	if orderFromAccural.Accrual != 0 {
+		storage.events.Publish(userID, events.Balance, events.BalanceData{Current: user.Balance, Withdrawn: user.Withdrawn})
 	}
 	return nil
 }
@@ -2,45 +2,265 @@ package psql
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"time"
+
+	"github.com/knstch/gophermart/internal/app/events"
+	"github.com/uptrace/bun"
 )
 
 // A struct designed to insert login and password data to users table
 type User struct {
-	Login     string  `bun:"login"`
-	Password  string  `bun:"password"`
-	Balance   float32 `bun:"balance"`
-	Withdrawn float32 `bun:"withdrawn"`
+	ID             int64     `bun:"id,nullzero"`
+	Login          string    `bun:"login"`
+	Password       string    `bun:"password"`
+	Balance        float32   `bun:"balance"`
+	Withdrawn      float32   `bun:"withdrawn"`
+	SessionVersion int       `bun:"session_version"`
+	Roles          []string  `bun:"roles,array,nullzero"`
+	DeletedAt      time.Time `bun:"deleted_at,nullzero"`
+	TOTPSecret     string    `bun:"totp_secret,nullzero"`
+	TOTPEnabled    bool      `bun:"totp_enabled"`
+	TOTPCounter    int64     `bun:"totp_counter"`
+	RecoveryCodes  []string  `bun:"recovery_codes,array,nullzero"`
+	State          string    `bun:"state,nullzero"`
+	StateReason    string    `bun:"state_reason,nullzero"`
+	StateChangedAt time.Time `bun:"state_changed_at,nullzero"`
 }
 
 // A struct designed to initialize users table in the database
 type Users struct {
-	Login     string  `bun:"type:varchar(255),unique"`
-	Password  string  `bun:"type:varchar(255)"`
-	Balance   float32 `bun:"type:float"`
-	Withdrawn float32 `bun:"type:float"`
+	ID             int64     `bun:"type:bigserial,pk"`
+	Login          string    `bun:"type:varchar(255),unique"`
+	Password       string    `bun:"type:varchar(255)"`
+	Balance        float32   `bun:"type:float"`
+	Withdrawn      float32   `bun:"type:float"`
+	SessionVersion int       `bun:"type:integer,notnull,default:0"`
+	Roles          []string  `bun:"type:text[],notnull,default:'{user}'"`
+	DeletedAt      time.Time `bun:"type:timestamp,nullzero"`
+	TOTPSecret     string    `bun:"type:varchar(64),nullzero"`
+	TOTPEnabled    bool      `bun:"type:boolean,notnull,default:false"`
+	TOTPCounter    int64     `bun:"type:bigint,notnull,default:0"`
+	RecoveryCodes  []string  `bun:"type:text[],notnull,default:'{}'"`
+	State          string    `bun:"type:varchar(16),notnull,default:'active'"`
+	StateReason    string    `bun:"type:text,notnull,default:''"`
+	StateChangedAt time.Time `bun:"type:timestamp,nullzero"`
+}
+
+// A struct designed to insert and read password reset tokens
+type PasswordResetToken struct {
+	UserID    int64     `bun:"user_id"`
+	TokenHash string    `bun:"token_hash"`
+	ExpiresAt time.Time `bun:"expires_at"`
+	UsedAt    time.Time `bun:"used_at,nullzero"`
+}
+
+// A struct designed to initialize password_reset_tokens table in the database
+type PasswordResetTokens struct {
+	UserID    int64     `bun:"type:bigint,notnull"`
+	TokenHash string    `bun:"type:varchar(64),unique"`
+	ExpiresAt time.Time `bun:"type:timestamp,notnull"`
+	UsedAt    time.Time `bun:"type:timestamp,nullzero"`
+}
+
+// A struct designed to insert and read sessions
+type Session struct {
+	ID             string    `bun:"id"`
+	UserID         int64     `bun:"user_id"`
+	SessionVersion int       `bun:"session_version"`
+	UserAgent      string    `bun:"user_agent"`
+	ClientIP       string    `bun:"client_ip"`
+	CreatedAt      time.Time `bun:"created_at"`
+	LastUsedAt     time.Time `bun:"last_used_at"`
+	ExpiresAt      time.Time `bun:"expires_at,nullzero"`
+	RevokedAt      time.Time `bun:"revoked_at,nullzero"`
+	SecondFactor   bool      `bun:"second_factor"`
+}
+
+// A struct designed to initialize sessions table in the database
+type Sessions struct {
+	ID             string    `bun:"type:varchar(64),pk"`
+	UserID         int64     `bun:"type:bigint,notnull"`
+	SessionVersion int       `bun:"type:integer,notnull,default:0"`
+	UserAgent      string    `bun:"type:varchar(512)"`
+	ClientIP       string    `bun:"type:varchar(64)"`
+	CreatedAt      time.Time `bun:"type:timestamp,notnull"`
+	LastUsedAt     time.Time `bun:"type:timestamp,notnull"`
+	ExpiresAt      time.Time `bun:"type:timestamp,nullzero"`
+	RevokedAt      time.Time `bun:"type:timestamp,nullzero"`
+	SecondFactor   bool      `bun:"type:boolean,notnull,default:false"`
+}
+
+// A struct designed to insert and read external identities linked to users
+type OIDCIdentity struct {
+	ID        int64     `bun:"id,pk,autoincrement"`
+	Issuer    string    `bun:"issuer"`
+	Subject   string    `bun:"subject"`
+	UserID    int64     `bun:"user_id"`
+	CreatedAt time.Time `bun:"created_at"`
+}
+
+// A struct designed to initialize oidc_identities table in the database
+type OIDCIdentities struct {
+	ID        int64     `bun:"type:bigserial,pk"`
+	Issuer    string    `bun:"type:varchar(255),notnull,unique:oidc_identities_issuer_subject"`
+	Subject   string    `bun:"type:varchar(255),notnull,unique:oidc_identities_issuer_subject"`
+	UserID    int64     `bun:"type:bigint,notnull"`
+	CreatedAt time.Time `bun:"type:timestamp,notnull"`
+}
+
+// A struct designed to insert and read changes of account states
+type AccountStateChange struct {
+	ID             int64     `bun:"id,pk,autoincrement"`
+	UserID         int64     `bun:"user_id"`
+	FromState      string    `bun:"from_state"`
+	State          string    `bun:"state"`
+	Reason         string    `bun:"reason"`
+	ChangedBy      int64     `bun:"changed_by,nullzero"`
+	ChangedAt      time.Time `bun:"changed_at"`
+	ChangedByLogin string    `bun:"changed_by_login,scanonly"`
+}
+
+// A struct designed to initialize account_state_changes table in the database
+type AccountStateChanges struct {
+	ID        int64     `bun:"type:bigserial,pk"`
+	UserID    int64     `bun:"type:bigint,notnull"`
+	FromState string    `bun:"type:varchar(16),notnull"`
+	State     string    `bun:"type:varchar(16),notnull"`
+	Reason    string    `bun:"type:text,notnull"`
+	ChangedBy int64     `bun:"type:bigint"`
+	ChangedAt time.Time `bun:"type:timestamp,notnull"`
 }
 
 // A struct designed to initialize orders table in the database
 type Orders struct {
-	Login            string  `bun:"type:varchar(255)"`
-	Order            string  `bun:"type:varchar(255),unique"`
-	Status           string  `bun:"type:varchar(255)"`
-	UploadedAt       string  `bun:"type:timestamp"`
-	BonusesWithdrawn float32 `bun:"type:float"`
-	Accrual          float32 `bun:"type:float"`
+	UserID           int64     `bun:"type:bigint,notnull"`
+	Order            string    `bun:"type:varchar(255),unique"`
+	Status           string    `bun:"type:varchar(255)"`
+	UploadedAt       string    `bun:"type:timestamp"`
+	BonusesWithdrawn float32   `bun:"type:float"`
+	Accrual          float32   `bun:"type:float"`
+	ReversedAt       time.Time `bun:"type:timestamp,nullzero"`
+	ReversedBy       int64     `bun:"type:bigint"`
+}
+
+// A struct designed to read and reverse withdrawals, orders where bonuses were spent.
+// InWindow is set by queries checking if a withdrawal can still be reversed by its user.
+type Withdrawal struct {
+	bun.BaseModel `bun:"table:orders,alias:withdrawal"`
+
+	UserID           int64     `bun:"user_id"`
+	Order            string    `bun:"order"`
+	UploadedAt       string    `bun:"uploaded_at"`
+	BonusesWithdrawn float32   `bun:"bonuses_withdrawn"`
+	ReversedAt       time.Time `bun:"reversed_at,nullzero"`
+	ReversedBy       int64     `bun:"reversed_by,nullzero"`
+	InWindow         bool      `bun:"in_window,scanonly"`
+}
+
+// A struct designed to read changes of a balance with the balance after every change
+type BalanceLine struct {
+	ChangedAt time.Time `bun:"changed_at"`
+	Order     string    `bun:"order"`
+	Type      string    `bun:"type"`
+	Amount    float32   `bun:"amount"`
+	Balance   float32   `bun:"balance"`
+}
+
+// A struct designed to insert and read changes of order statuses
+type OrderStatusChange struct {
+	ID         int64           `bun:"id,pk,autoincrement"`
+	Order      string          `bun:"order"`
+	FromStatus string          `bun:"from_status"`
+	Status     string          `bun:"status"`
+	Accrual    *float32        `bun:"accrual"`
+	Payload    json.RawMessage `bun:"payload,type:jsonb,nullzero"`
+	ChangedAt  time.Time       `bun:"changed_at"`
+}
+
+// A struct designed to initialize order_status_changes table in the database
+type OrderStatusChanges struct {
+	ID         int64           `bun:"type:bigserial,pk"`
+	Order      string          `bun:"type:varchar(255),notnull"`
+	FromStatus string          `bun:"type:varchar(255),notnull"`
+	Status     string          `bun:"type:varchar(255),notnull"`
+	Accrual    float32         `bun:"type:float"`
+	Payload    json.RawMessage `bun:"type:jsonb"`
+	ChangedAt  time.Time       `bun:"type:timestamp,notnull"`
+}
+
+// A struct designed to insert and read API keys
+type APIKey struct {
+	ID           int64     `bun:"id,nullzero"`
+	UserID       int64     `bun:"user_id"`
+	Name         string    `bun:"name"`
+	Prefix       string    `bun:"prefix"`
+	KeyHash      string    `bun:"key_hash"`
+	Scopes       []string  `bun:"scopes,array"`
+	RateLimit    int       `bun:"rate_limit"`
+	CreatedAt    time.Time `bun:"created_at"`
+	LastUsedAt   time.Time `bun:"last_used_at,nullzero"`
+	RevokedAt    time.Time `bun:"revoked_at,nullzero"`
+	Login        string    `bun:"login,scanonly"`
+	AccountState string    `bun:"account_state,scanonly"`
+}
+
+// A struct designed to initialize api_keys table in the database
+type APIKeys struct {
+	ID         int64     `bun:"type:bigserial,unique"`
+	UserID     int64     `bun:"type:bigint,notnull"`
+	Name       string    `bun:"type:varchar(255),notnull"`
+	Prefix     string    `bun:"type:varchar(16),notnull"`
+	KeyHash    string    `bun:"type:varchar(64),unique"`
+	Scopes     []string  `bun:"type:text[],notnull"`
+	RateLimit  int       `bun:"type:integer,notnull,default:0"`
+	CreatedAt  time.Time `bun:"type:timestamp,notnull"`
+	LastUsedAt time.Time `bun:"type:timestamp,nullzero"`
+	RevokedAt  time.Time `bun:"type:timestamp,nullzero"`
+}
+
+// A struct designed to insert and read idempotency keys and recorded responses
+type IdempotencyKey struct {
+	UserID      int64     `bun:"user_id"`
+	Key         string    `bun:"key"`
+	Fingerprint string    `bun:"fingerprint"`
+	StatusCode  int       `bun:"status_code"`
+	ContentType string    `bun:"content_type"`
+	Response    []byte    `bun:"response"`
+	CreatedAt   time.Time `bun:"created_at"`
+	ExpiresAt   time.Time `bun:"expires_at"`
+}
+
+// A struct designed to initialize idempotency_keys table in the database
+type IdempotencyKeys struct {
+	UserID      int64     `bun:"type:bigint,notnull,unique:idempotency_keys_user_id_key"`
+	Key         string    `bun:"type:varchar(255),notnull,unique:idempotency_keys_user_id_key"`
+	Fingerprint string    `bun:"type:varchar(64),notnull"`
+	StatusCode  int       `bun:"type:integer,notnull,default:0"`
+	ContentType string    `bun:"type:varchar(255),notnull,default:''"`
+	Response    []byte    `bun:"type:bytea"`
+	CreatedAt   time.Time `bun:"type:timestamp,notnull"`
+	ExpiresAt   time.Time `bun:"type:timestamp,notnull"`
 }
 
 // A struct used to set database connection and
-// bind database interaction methods
+// bind database interaction methods. Order status changes and balance updates
+// are published to the events broker.
 type PsqURLlStorage struct {
-	db *sql.DB
+	db     *sql.DB
+	events *events.Broker
 }
 
 // A builder function used in main.go file made to initialize Postgres storage
-// with its methods
+// with its methods and an events broker
 func NewPsqlStorage(db *sql.DB) *PsqURLlStorage {
-	return &PsqURLlStorage{db: db}
+	return &PsqURLlStorage{db: db, events: events.NewBroker()}
+}
+
+// Events returns the broker order status changes and balance updates are published to.
+func (storage *PsqURLlStorage) Events() *events.Broker {
+	return storage.events
 }
 
 // An error indicating that an order is loaded by another user.
@@ -54,3 +274,33 @@ var ErrNotEnoughBalance = errors.New("not enough balance")
 
 // An error indiating that no rows were found.
 var ErrNoRows = errors.New("no rows were found")
+
+// An error indicating that a login and password pair doesn't match any user.
+var ErrWrongCredentials = errors.New("wrong login or password")
+
+// An error indicating that an external identity is already linked to another account.
+var ErrIdentityLinked = errors.New("identity is linked to another account")
+
+// An error indicating that a login is already registered.
+var ErrLoginTaken = errors.New("login is already taken")
+
+// An error indicating that a password reset token is unknown, expired or already used.
+var ErrInvalidResetToken = errors.New("invalid password reset token")
+
+// An error indicating that a role is not known.
+var ErrUnknownRole = errors.New("unknown role")
+
+// An error indicating that a one-time or recovery code is wrong or already used.
+var ErrInvalidCode = errors.New("invalid one-time code")
+
+// An error indicating that an account state is not known.
+var ErrUnknownState = errors.New("unknown account state")
+
+// An error indicating that an account is frozen and can't spend bonuses.
+var ErrAccountFrozen = errors.New("account is frozen")
+
+// An error indicating that a withdrawal is older than the time its user can reverse it within.
+var ErrReversalWindowClosed = errors.New("reversal window is closed")
+
+// An error indicating that an API key scope is not known.
+var ErrUnknownScope = errors.New("unknown scope")
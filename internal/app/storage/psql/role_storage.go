@@ -0,0 +1,110 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// GetAccountInfo accepts context and login and returns roles, balance and state of the user,
+// or ErrNoRows if there is no such user.
+func (storage *PsqURLlStorage) GetAccountInfo(ctx context.Context, login string) (common.AccountInfo, error) {
+	var user User
+
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	err := db.NewSelect().
+		Model(&user).
+		Where("lower(login) = lower(?)", login).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.AccountInfo{}, ErrNoRows
+	}
+	if err != nil {
+		logger.ErrorLogger("Error finding user: ", err)
+		return common.AccountInfo{}, err
+	}
+
+	return user.toAccountInfo(), nil
+}
+
+// SetRoles accepts context, login and roles and replaces roles of the user.
+// Existing sessions of the user are revoked, so tokens never carry stale roles.
+// It returns ErrUnknownRole if a role is not one of common.KnownRoles
+// and ErrNoRows if there is no such user.
+func (storage *PsqURLlStorage) SetRoles(ctx context.Context, login string, roles []string) error {
+	for _, role := range roles {
+		if !common.Contains(common.KnownRoles, role) {
+			return ErrUnknownRole
+		}
+	}
+
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	result, err := db.NewUpdate().
+		Model((*User)(nil)).
+		Set("roles = ?", pgdialect.Array(roles)).
+		Set("session_version = session_version + 1").
+		Where("lower(login) = lower(?)", login).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error setting roles: ", err)
+		return err
+	}
+
+	return checkAffected(result)
+}
+
+// GrantRole accepts context, login and a role and adds the role to the user
+// if the user doesn't have it yet, revoking existing sessions.
+// It returns ErrUnknownRole if the role is not one of common.KnownRoles
+// and ErrNoRows if there is no such user.
+func (storage *PsqURLlStorage) GrantRole(ctx context.Context, login string, role string) error {
+	if !common.Contains(common.KnownRoles, role) {
+		return ErrUnknownRole
+	}
+
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	exists, err := db.NewSelect().
+		Model((*User)(nil)).
+		Where("lower(login) = lower(?)", login).
+		Exists(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error finding user: ", err)
+		return err
+	}
+	if !exists {
+		return ErrNoRows
+	}
+
+	_, err = db.NewUpdate().
+		Model((*User)(nil)).
+		Set("roles = array_append(roles, ?)", role).
+		Set("session_version = session_version + 1").
+		Where("lower(login) = lower(?) AND NOT ? = ANY(roles)", login, role).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error granting role: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// checkAffected returns ErrNoRows if a query hasn't changed any rows.
+func checkAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// A struct designed to read a session together with the account it belongs to
+type sessionWithAccount struct {
+	bun.BaseModel `bun:"table:sessions,alias:s"`
+
+	Session
+	Login              string   `bun:"login,scanonly"`
+	UserSessionVersion int      `bun:"user_session_version,scanonly"`
+	Roles              []string `bun:"roles,array,scanonly"`
+	State              string   `bun:"state,scanonly"`
+}
+
+// CreateSession accepts context and a session and records it.
+func (storage *PsqURLlStorage) CreateSession(ctx context.Context, session common.Session) error {
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	row := &Session{
+		ID:             session.ID,
+		UserID:         session.UserID,
+		SessionVersion: session.SessionVersion,
+		UserAgent:      session.UserAgent,
+		ClientIP:       session.ClientIP,
+		CreatedAt:      session.CreatedAt,
+		LastUsedAt:     session.LastUsedAt,
+		SecondFactor:   session.SecondFactor,
+	}
+	if session.ExpiresAt != nil {
+		row.ExpiresAt = *session.ExpiresAt
+	}
+
+	_, err := db.NewInsert().
+		Model(row).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error writing session: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetSession accepts context and a session ID and returns the account the session
+// belongs to, with the account's current session version and state, and the session itself.
+// A single lookup by the primary key is made for every authenticated request.
+// It returns ErrNoRows if there is no such session, it is revoked or expired.
+func (storage *PsqURLlStorage) GetSession(ctx context.Context, id string) (common.Account, common.Session, error) {
+	var row sessionWithAccount
+
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	err := db.NewSelect().
+		Model(&row).
+		ColumnExpr("s.*").
+		ColumnExpr("u.login, u.session_version AS user_session_version, u.roles, u.state").
+		Join("JOIN users AS u ON u.id = s.user_id").
+		Where("s.id = ?", id).
+		Where("s.revoked_at IS NULL").
+		Where("s.expires_at IS NULL OR s.expires_at > ?", time.Now()).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.Account{}, common.Session{}, ErrNoRows
+	}
+	if err != nil {
+		logger.ErrorLogger("Error getting session: ", err)
+		return common.Account{}, common.Session{}, err
+	}
+
+	account := common.Account{
+		ID:             row.UserID,
+		Login:          row.Login,
+		SessionVersion: row.UserSessionVersion,
+		Roles:          row.Roles,
+		State:          row.State,
+	}
+
+	return account, row.Session.toCommon(), nil
+}
+
+// GetSessions accepts context and user ID and returns active sessions of the user
+// ordered from recently used to stale ones. Revoked and expired sessions, as well as
+// sessions revoked by a password or roles change, are left out.
+func (storage *PsqURLlStorage) GetSessions(ctx context.Context, userID int64) ([]common.Session, error) {
+	var rows []Session
+
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	err := db.NewSelect().
+		Model(&rows).
+		ColumnExpr("session.*").
+		Join("JOIN users AS u ON u.id = session.user_id AND u.session_version = session.session_version").
+		Where("session.user_id = ?", userID).
+		Where("session.revoked_at IS NULL").
+		Where("session.expires_at IS NULL OR session.expires_at > ?", time.Now()).
+		Order("session.last_used_at DESC").
+		Scan(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error getting sessions: ", err)
+		return nil, err
+	}
+
+	sessions := make([]common.Session, 0, len(rows))
+	for _, row := range rows {
+		sessions = append(sessions, row.toCommon())
+	}
+	return sessions, nil
+}
+
+// RevokeSession accepts context, user ID and a session ID and revokes the session
+// if it belongs to the user. It returns ErrNoRows if there is no such active session.
+func (storage *PsqURLlStorage) RevokeSession(ctx context.Context, userID int64, id string) error {
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	result, err := db.NewUpdate().
+		Model((*Session)(nil)).
+		Set("revoked_at = ?", time.Now()).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error revoking session: ", err)
+		return err
+	}
+
+	return checkAffected(result)
+}
+
+// TouchSession accepts context, a session ID, time and a new expiration time.
+// It records the time as the last use of the session and, if expiresAt is not nil,
+// moves the session expiration, so sessions with a sliding expiry stay active while used.
+func (storage *PsqURLlStorage) TouchSession(ctx context.Context, id string, usedAt time.Time, expiresAt *time.Time) error {
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	query := db.NewUpdate().
+		Model((*Session)(nil)).
+		Set("last_used_at = ?", usedAt).
+		Where("id = ?", id)
+	if expiresAt != nil {
+		query = query.Set("expires_at = ?", *expiresAt)
+	}
+
+	_, err := query.Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error updating session usage: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// toCommon converts a session row to common.Session.
+func (row Session) toCommon() common.Session {
+	session := common.Session{
+		ID:             row.ID,
+		UserID:         row.UserID,
+		SessionVersion: row.SessionVersion,
+		UserAgent:      row.UserAgent,
+		ClientIP:       row.ClientIP,
+		CreatedAt:      row.CreatedAt,
+		LastUsedAt:     row.LastUsedAt,
+		SecondFactor:   row.SecondFactor,
+	}
+	if !row.ExpiresAt.IsZero() {
+		session.ExpiresAt = &row.ExpiresAt
+	}
+	return session
+}
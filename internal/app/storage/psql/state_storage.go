@@ -0,0 +1,114 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// SetAccountState accepts context, login, a state, a reason and user ID of the staff member
+// making the change. It puts the account in the state and records the change in the account
+// history, both in one transaction. It returns the recorded change, ErrUnknownState if the state
+// is not one of common.KnownAccountStates and ErrNoRows if there is no such active user.
+func (storage *PsqURLlStorage) SetAccountState(ctx context.Context, login string, state string, reason string, changedBy int64) (common.AccountStateChange, error) {
+	if !common.Contains(common.KnownAccountStates, state) {
+		return common.AccountStateChange{}, ErrUnknownState
+	}
+
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	change := AccountStateChange{
+		State:     state,
+		Reason:    reason,
+		ChangedBy: changedBy,
+		ChangedAt: time.Now(),
+	}
+
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var user User
+		err := tx.NewSelect().
+			Model(&user).
+			Column("id", "state").
+			Where("lower(login) = lower(?) AND deleted_at IS NULL", login).
+			For("UPDATE").
+			Scan(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRows
+		}
+		if err != nil {
+			return err
+		}
+		change.UserID = user.ID
+		change.FromState = user.State
+
+		_, err = tx.NewUpdate().
+			Model((*User)(nil)).
+			Set("state = ?", state).
+			Set("state_reason = ?", reason).
+			Set("state_changed_at = ?", change.ChangedAt).
+			Where("id = ?", user.ID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.NewInsert().
+			Model(&change).
+			Exec(ctx)
+		return err
+	})
+	if errors.Is(err, ErrNoRows) {
+		return common.AccountStateChange{}, err
+	}
+	if err != nil {
+		logger.ErrorLogger("Error setting account state: ", err)
+		return common.AccountStateChange{}, err
+	}
+
+	return change.toCommon(), nil
+}
+
+// GetAccountStateChanges accepts context and user ID and returns changes
+// of the account state ordered from new to old ones.
+func (storage *PsqURLlStorage) GetAccountStateChanges(ctx context.Context, userID int64) ([]common.AccountStateChange, error) {
+	var rows []AccountStateChange
+
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	err := db.NewSelect().
+		Model(&rows).
+		ColumnExpr("account_state_change.*").
+		ColumnExpr("u.login AS changed_by_login").
+		Join("LEFT JOIN users AS u ON u.id = account_state_change.changed_by").
+		Where("account_state_change.user_id = ?", userID).
+		Order("account_state_change.changed_at DESC", "account_state_change.id DESC").
+		Scan(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error getting account state changes: ", err)
+		return nil, err
+	}
+
+	changes := make([]common.AccountStateChange, 0, len(rows))
+	for _, row := range rows {
+		changes = append(changes, row.toCommon())
+	}
+	return changes, nil
+}
+
+// toCommon converts an account state change row to common.AccountStateChange.
+// The staff member is named by the current login.
+func (row AccountStateChange) toCommon() common.AccountStateChange {
+	return common.AccountStateChange{
+		From:      row.FromState,
+		State:     row.State,
+		Reason:    row.Reason,
+		ChangedBy: row.ChangedByLogin,
+		ChangedAt: row.ChangedAt,
+	}
+}
@@ -0,0 +1,101 @@
+package psql
+
+import (
+	"context"
+	"time"
+
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// GetStatement accepts context, user ID and a period and returns the changes of the user's balance
+// made in it ordered by time: accruals, withdrawals and reversals of withdrawals, each with the balance
+// after it. The opening balance is the balance before the period and the closing balance is the balance
+// at its end. A nil from or to leaves the period open at that side.
+func (storage *PsqURLlStorage) GetStatement(ctx context.Context, userID int64, from *time.Time, to *time.Time) (common.Statement, error) {
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	statement := common.Statement{
+		From:  from,
+		To:    to,
+		Lines: []common.BalanceChange{},
+	}
+
+	if from != nil {
+		err := db.NewSelect().
+			TableExpr("(?) AS statement", balanceLines(db, userID)).
+			ColumnExpr("COALESCE(sum(amount), 0)").
+			Where("changed_at < ?", *from).
+			Scan(ctx, &statement.OpeningBalance)
+		if err != nil {
+			logger.ErrorLogger("Error getting opening balance: ", err)
+			return common.Statement{}, err
+		}
+	}
+
+	var lines []BalanceLine
+	err := statementQuery(db, userID, from, to).Scan(ctx, &lines)
+	if err != nil {
+		logger.ErrorLogger("Error getting statement: ", err)
+		return common.Statement{}, err
+	}
+
+	statement.ClosingBalance = statement.OpeningBalance
+	for _, line := range lines {
+		statement.Lines = append(statement.Lines, line.toCommon())
+		statement.ClosingBalance = line.Balance
+	}
+	return statement, nil
+}
+
+// statementQuery returns a query of changes of the user's balance made in a period ordered by time.
+func statementQuery(db bun.IDB, userID int64, from *time.Time, to *time.Time) *bun.SelectQuery {
+	q := db.NewSelect().
+		TableExpr("(?) AS statement", balanceLines(db, userID))
+	if from != nil {
+		q = q.Where("changed_at >= ?", *from)
+	}
+	if to != nil {
+		q = q.Where("changed_at < ?", *to)
+	}
+	return q.OrderExpr(`changed_at, "order", type`)
+}
+
+// balanceLines returns a query of all changes of the user's balance with the running balance
+// after every change. Bonuses are accrued when the accrual system processes an order, orders
+// processed before their status changes were recorded are accrued at the upload time.
+func balanceLines(db bun.IDB, userID int64) *bun.SelectQuery {
+	accruals := db.NewSelect().
+		TableExpr("orders AS o").
+		ColumnExpr(`o."order", ? AS type, o.accrual AS amount`, common.BalanceAccrual).
+		ColumnExpr(`COALESCE((SELECT max(c.changed_at) FROM order_status_changes AS c WHERE c."order" = o."order" AND c.status = ?), o.uploaded_at) AS changed_at`, "PROCESSED").
+		Where("o.user_id = ? AND o.accrual != 0", userID)
+
+	withdrawals := db.NewSelect().
+		TableExpr("orders").
+		ColumnExpr(`"order", ? AS type, -bonuses_withdrawn AS amount, uploaded_at AS changed_at`, common.BalanceWithdrawal).
+		Where("user_id = ? AND bonuses_withdrawn != 0", userID)
+
+	reversals := db.NewSelect().
+		TableExpr("orders").
+		ColumnExpr(`"order", ? AS type, bonuses_withdrawn AS amount, reversed_at AS changed_at`, common.BalanceReversal).
+		Where("user_id = ? AND reversed_at IS NOT NULL", userID)
+
+	return db.NewSelect().
+		TableExpr("(?) AS lines", accruals.UnionAll(withdrawals).UnionAll(reversals)).
+		ColumnExpr(`changed_at, "order", type, amount`).
+		ColumnExpr(`sum(amount) OVER (ORDER BY changed_at, "order", type) AS balance`)
+}
+
+// toCommon converts a balance line to common.BalanceChange.
+func (line BalanceLine) toCommon() common.BalanceChange {
+	return common.BalanceChange{
+		Time:    line.ChangedAt.Format(time.RFC3339),
+		Order:   line.Order,
+		Type:    line.Type,
+		Amount:  line.Amount,
+		Balance: line.Balance,
+	}
+}
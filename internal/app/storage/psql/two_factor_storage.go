@@ -0,0 +1,152 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// GetTwoFactor accepts context and user ID and returns the account and its
+// two-factor authentication settings, or ErrNoRows if there is no such active user.
+func (storage *PsqURLlStorage) GetTwoFactor(ctx context.Context, userID int64) (common.Account, common.TwoFactor, error) {
+	var user User
+
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	err := db.NewSelect().
+		Model(&user).
+		Column("id", "login", "session_version", "roles", "totp_secret", "totp_enabled", "state").
+		Where("id = ? AND deleted_at IS NULL", userID).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.Account{}, common.TwoFactor{}, ErrNoRows
+	}
+	if err != nil {
+		logger.ErrorLogger("Error finding user: ", err)
+		return common.Account{}, common.TwoFactor{}, err
+	}
+
+	account := common.Account{
+		ID:             user.ID,
+		Login:          user.Login,
+		SessionVersion: user.SessionVersion,
+		Roles:          user.Roles,
+		TOTPEnabled:    user.TOTPEnabled,
+		State:          user.State,
+	}
+	return account, common.TwoFactor{Secret: user.TOTPSecret, Enabled: user.TOTPEnabled}, nil
+}
+
+// StartTwoFactor accepts context, user ID and a TOTP secret and stores the secret
+// as a pending enrolment, replacing a previous pending one. It returns ErrNoRows
+// if there is no such active user or two-factor authentication is already enabled.
+func (storage *PsqURLlStorage) StartTwoFactor(ctx context.Context, userID int64, secret string) error {
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	result, err := db.NewUpdate().
+		Model((*User)(nil)).
+		Set("totp_secret = ?", secret).
+		Where("id = ? AND deleted_at IS NULL AND NOT totp_enabled", userID).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error starting two-factor enrolment: ", err)
+		return err
+	}
+
+	return checkAffected(result)
+}
+
+// EnableTwoFactor accepts context, user ID, the time step of a confirmed TOTP code
+// and recovery codes. It enables two-factor authentication with the pending secret
+// and stores hashes of the recovery codes. It returns ErrNoRows if there is
+// no pending enrolment.
+func (storage *PsqURLlStorage) EnableTwoFactor(ctx context.Context, userID int64, counter int64, recoveryCodes []string) error {
+	hashes := make([]string, 0, len(recoveryCodes))
+	for _, code := range recoveryCodes {
+		hashes = append(hashes, hashToken(code))
+	}
+
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	result, err := db.NewUpdate().
+		Model((*User)(nil)).
+		Set("totp_enabled = true").
+		Set("totp_counter = ?", counter).
+		Set("recovery_codes = ?", pgdialect.Array(hashes)).
+		Where("id = ? AND deleted_at IS NULL AND NOT totp_enabled AND totp_secret IS NOT NULL", userID).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error enabling two-factor authentication: ", err)
+		return err
+	}
+
+	return checkAffected(result)
+}
+
+// DisableTwoFactor accepts context and user ID and removes the TOTP secret
+// and recovery codes of the user. It returns ErrNoRows if there is no such user.
+func (storage *PsqURLlStorage) DisableTwoFactor(ctx context.Context, userID int64) error {
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	result, err := db.NewUpdate().
+		Model((*User)(nil)).
+		Set("totp_secret = NULL, totp_enabled = false, totp_counter = 0, recovery_codes = '{}'").
+		Where("id = ?", userID).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error disabling two-factor authentication: ", err)
+		return err
+	}
+
+	return checkAffected(result)
+}
+
+// UseTOTPCode accepts context, user ID and the time step of a valid TOTP code
+// and records it as the last used one. It returns ErrInvalidCode if a code of
+// the same or a later time step is already used, so every code works only once.
+func (storage *PsqURLlStorage) UseTOTPCode(ctx context.Context, userID int64, counter int64) error {
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	result, err := db.NewUpdate().
+		Model((*User)(nil)).
+		Set("totp_counter = ?", counter).
+		Where("id = ? AND totp_enabled AND totp_counter < ?", userID, counter).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error using TOTP code: ", err)
+		return err
+	}
+
+	if err = checkAffected(result); errors.Is(err, ErrNoRows) {
+		return ErrInvalidCode
+	}
+	return err
+}
+
+// UseRecoveryCode accepts context, user ID and a recovery code and removes the code
+// from the user's ones. It returns ErrInvalidCode if the code is unknown or already used.
+func (storage *PsqURLlStorage) UseRecoveryCode(ctx context.Context, userID int64, code string) error {
+	hash := hashToken(code)
+
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	result, err := db.NewUpdate().
+		Model((*User)(nil)).
+		Set("recovery_codes = array_remove(recovery_codes, ?)", hash).
+		Where("id = ? AND totp_enabled AND ? = ANY(recovery_codes)", userID, hash).
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorLogger("Error using recovery code: ", err)
+		return err
+	}
+
+	if err = checkAffected(result); errors.Is(err, ErrNoRows) {
+		return ErrInvalidCode
+	}
+	return err
+}
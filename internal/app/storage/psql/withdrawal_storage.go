@@ -0,0 +1,107 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/knstch/gophermart/internal/app/common"
+	"github.com/knstch/gophermart/internal/app/events"
+	"github.com/knstch/gophermart/internal/app/logger"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// ReverseWithdrawal accepts context, an order number, user ID of the owner, user ID of the user
+// making the reversal and the time the owner can reverse a withdrawal within. It marks the withdrawal
+// as reversed and gives the spent bonuses back to the owner's balance in one transaction.
+// An owner ID of 0 and a window of 0 allow to reverse a withdrawal of any user at any time.
+// A withdrawal reversed before is returned as it is with false, so a reversal is safe to repeat.
+// It returns ErrNoRows if there is no such withdrawal of the owner and ErrReversalWindowClosed
+// if the withdrawal is older than the window.
+func (storage *PsqURLlStorage) ReverseWithdrawal(ctx context.Context, number string, ownerID int64, reversedBy int64, window time.Duration) (common.OrdersWithSpentBonuses, bool, error) {
+	db := bun.NewDB(storage.db, pgdialect.New())
+
+	var withdrawal Withdrawal
+	var user User
+	reversed := false
+
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		q := tx.NewSelect().
+			Model(&withdrawal).
+			Column("user_id", "order", "uploaded_at", "bonuses_withdrawn", "reversed_at", "reversed_by").
+			Where(`"order" = ? AND bonuses_withdrawn > 0`, number)
+		if ownerID != 0 {
+			q = q.Where("user_id = ?", ownerID)
+		}
+		if window > 0 {
+			// The upload time is written as a local time, so the start of the window is too.
+			q = q.ColumnExpr("uploaded_at >= ?::timestamp AS in_window", time.Now().Add(-window).Format(time.RFC3339))
+		}
+		err := q.For("UPDATE").Scan(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRows
+		}
+		if err != nil {
+			return err
+		}
+
+		if !withdrawal.ReversedAt.IsZero() {
+			return nil
+		}
+		if window > 0 && !withdrawal.InWindow {
+			return ErrReversalWindowClosed
+		}
+
+		withdrawal.ReversedAt = time.Now()
+		withdrawal.ReversedBy = reversedBy
+		_, err = tx.NewUpdate().
+			Model(&withdrawal).
+			Column("reversed_at", "reversed_by").
+			Where(`"order" = ?`, number).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.NewUpdate().
+			Model(&user).
+			Set("balance = balance + ?", withdrawal.BonusesWithdrawn).
+			Set("withdrawn = withdrawn - ?", withdrawal.BonusesWithdrawn).
+			Where("id = ?", withdrawal.UserID).
+			Returning("balance, withdrawn").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		reversed = true
+		return nil
+	})
+	if errors.Is(err, ErrNoRows) || errors.Is(err, ErrReversalWindowClosed) {
+		return common.OrdersWithSpentBonuses{}, false, err
+	}
+	if err != nil {
+		logger.ErrorLogger("Error reversing withdrawal: ", err)
+		return common.OrdersWithSpentBonuses{}, false, err
+	}
+
+	if reversed {
+		storage.events.Publish(withdrawal.UserID, events.Balance, events.BalanceData{Current: user.Balance, Withdrawn: user.Withdrawn})
+	}
+	return withdrawal.toCommon(), reversed, nil
+}
+
+// toCommon converts a withdrawal row to common.OrdersWithSpentBonuses.
+func (row Withdrawal) toCommon() common.OrdersWithSpentBonuses {
+	withdrawal := common.OrdersWithSpentBonuses{
+		Order:            row.Order,
+		Time:             row.UploadedAt,
+		BonusesWithdrawn: row.BonusesWithdrawn,
+	}
+	if !row.ReversedAt.IsZero() {
+		reversedAt := row.ReversedAt
+		withdrawal.ReversedAt = &reversedAt
+	}
+	return withdrawal
+}
@@ -0,0 +1,113 @@
+// Package totp implements time-based one-time passwords (RFC 6238)
+// compatible with common authenticator apps.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// A number of digits in a code.
+const Digits = 6
+
+// A number of seconds a code is valid for.
+const Period = 30
+
+// A size of a generated secret in bytes, as recommended by RFC 4226.
+const secretSize = 20
+
+// An encoding of secrets used by authenticator apps.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a random base32 encoded secret.
+func GenerateSecret() (string, error) {
+	secret := make([]byte, secretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(secret), nil
+}
+
+// URI returns an otpauth URI an authenticator app is enrolled with,
+// usually shown as a QR code.
+func URI(issuer string, account string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(Period))
+
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+	return uri.String()
+}
+
+// Code returns a code of the secret for the given time.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decode(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, counter(t), Digits), nil
+}
+
+// Validate accepts a secret, a code and the current time and reports if the code
+// matches the current time step or one of skew steps around it. It returns the counter
+// of the matched step, so the caller can reject a code used before.
+func Validate(secret string, code string, t time.Time, skew int) (int64, bool) {
+	key, err := decode(secret)
+	if err != nil || len(code) != Digits {
+		return 0, false
+	}
+
+	current := counter(t)
+	for i := -skew; i <= skew; i++ {
+		step := current + int64(i)
+		if hmac.Equal([]byte(hotp(key, step, Digits)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// counter returns a number of the time step.
+func counter(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// decode decodes a base32 secret ignoring its case and spaces.
+func decode(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	return encoding.DecodeString(strings.TrimRight(secret, "="))
+}
+
+// hotp returns an HMAC-based one-time password (RFC 4226).
+func hotp(key []byte, counter int64, digits int) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%modulo)
+}
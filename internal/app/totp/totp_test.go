@@ -0,0 +1,82 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A secret used by test vectors of RFC 4226 and RFC 6238.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestHOTP(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		assert.Equal(t, code, hotp([]byte("12345678901234567890"), int64(counter), 6))
+	}
+}
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		name string
+		time int64
+		code string
+	}{
+		{name: "#1 59", time: 59, code: "94287082"},
+		{name: "#2 1111111109", time: 1111111109, code: "07081804"},
+		{name: "#3 1234567890", time: 1234567890, code: "89005924"},
+		{name: "#4 2000000000", time: 2000000000, code: "69279037"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := decode(rfcSecret)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.code, hotp(key, counter(time.Unix(tt.time, 0)), 8))
+
+			code, err := Code(rfcSecret, time.Unix(tt.time, 0))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.code[2:], code)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	code, err := Code(secret, now)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name string
+		code string
+		time time.Time
+		ok   bool
+	}{
+		{name: "#1 current step", code: code, time: now, ok: true},
+		{name: "#2 previous step within skew", code: code, time: now.Add(Period * time.Second), ok: true},
+		{name: "#3 step outside of skew", code: code, time: now.Add(2 * Period * time.Second), ok: false},
+		{name: "#4 wrong code", code: "000000", time: now, ok: code == "000000"},
+		{name: "#5 wrong length", code: "12345", time: now, ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(strings.ToLower(secret), tt.code, tt.time, 1)
+			assert.Equal(t, tt.ok, ok)
+			if ok {
+				assert.Equal(t, counter(now), step)
+			}
+		})
+	}
+}
+
+func TestURI(t *testing.T) {
+	assert.Equal(t, "otpauth://totp/Gophermart:gopher@example.com?algorithm=SHA1&digits=6&issuer=Gophermart&period=30&secret=JBSWY3DPEHPK3PXP",
+		URI("Gophermart", "gopher@example.com", "JBSWY3DPEHPK3PXP"))
+}
@@ -0,0 +1,159 @@
+package validitycheck
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The longest password accepted on registration.
+const maxPasswordLength = 128
+
+// A built-in list of common passwords that can't be used.
+//
+//go:embed common_passwords.txt
+var commonPasswords string
+
+// A struct describing why a single field of a request is not valid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// A struct with rules that logins and passwords of new accounts have to follow.
+type CredentialsPolicy struct {
+	loginMinLength     int
+	loginMaxLength     int
+	loginCharset       string
+	loginPattern       *regexp.Regexp
+	passwordMinLength  int
+	passwordMinClasses int
+	deniedPasswords    map[string]struct{}
+}
+
+// A builder function returning CredentialsPolicy. loginCharset is a regular expression
+// character class, like "a-z0-9._-", describing characters allowed in a normalized login.
+// passwordMinClasses is a number of character classes (lower case, upper case,
+// digits and other symbols) a password has to contain. Passwords from the built-in list
+// and from the denylistPath file, one per line, are rejected. It returns an error
+// if the charset can't be compiled or the built-in list or the denylist file can't be read.
+func NewCredentialsPolicy(loginMinLength int, loginMaxLength int, loginCharset string,
+	passwordMinLength int, passwordMinClasses int, denylistPath string) (*CredentialsPolicy, error) {
+	loginPattern, err := regexp.Compile("^[" + loginCharset + "]*$")
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &CredentialsPolicy{
+		loginMinLength:     loginMinLength,
+		loginMaxLength:     loginMaxLength,
+		loginCharset:       loginCharset,
+		loginPattern:       loginPattern,
+		passwordMinLength:  passwordMinLength,
+		passwordMinClasses: passwordMinClasses,
+		deniedPasswords:    make(map[string]struct{}),
+	}
+
+	if err := policy.denyPasswords(strings.NewReader(commonPasswords)); err != nil {
+		return nil, fmt.Errorf("reading built-in password denylist: %w", err)
+	}
+
+	if denylistPath != "" {
+		file, err := os.Open(denylistPath)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		if err := policy.denyPasswords(file); err != nil {
+			return nil, err
+		}
+	}
+
+	return policy, nil
+}
+
+// NormalizeLogin trims spaces around a login and lowers its case,
+// so logins differing only by case belong to the same account.
+func NormalizeLogin(login string) string {
+	return strings.ToLower(strings.TrimSpace(login))
+}
+
+// Validate accepts a normalized login and a password and returns
+// a list of rules they break. An empty list means the credentials are valid.
+func (p *CredentialsPolicy) Validate(login string, password string) []FieldError {
+	return append(p.ValidateLogin("login", login), p.ValidatePassword("password", password)...)
+}
+
+// ValidateLogin accepts a name of the request field and a normalized login and returns
+// a list of rules the login breaks. An empty list means the login is valid.
+func (p *CredentialsPolicy) ValidateLogin(field string, login string) []FieldError {
+	loginLength := utf8.RuneCountInString(login)
+	switch {
+	case loginLength < p.loginMinLength:
+		return []FieldError{{field, fmt.Sprintf("must be at least %d characters long", p.loginMinLength)}}
+	case p.loginMaxLength > 0 && loginLength > p.loginMaxLength:
+		return []FieldError{{field, fmt.Sprintf("must be at most %d characters long", p.loginMaxLength)}}
+	case !p.loginPattern.MatchString(login):
+		return []FieldError{{field, fmt.Sprintf("may contain only [%s] characters", p.loginCharset)}}
+	}
+	return nil
+}
+
+// ValidatePassword accepts a name of the request field and a password and returns
+// a list of rules the password breaks. An empty list means the password is valid.
+func (p *CredentialsPolicy) ValidatePassword(field string, password string) []FieldError {
+	passwordLength := utf8.RuneCountInString(password)
+	switch {
+	case passwordLength < p.passwordMinLength:
+		return []FieldError{{field, fmt.Sprintf("must be at least %d characters long", p.passwordMinLength)}}
+	case passwordLength > maxPasswordLength:
+		return []FieldError{{field, fmt.Sprintf("must be at most %d characters long", maxPasswordLength)}}
+	case characterClasses(password) < p.passwordMinClasses:
+		return []FieldError{{field, fmt.Sprintf("must contain at least %d of: lower case letters, upper case letters, digits, symbols", p.passwordMinClasses)}}
+	case p.isDenied(password):
+		return []FieldError{{field, "is too common"}}
+	}
+	return nil
+}
+
+// isDenied reports if a password is on the denylist, ignoring its case.
+func (p *CredentialsPolicy) isDenied(password string) bool {
+	_, ok := p.deniedPasswords[strings.ToLower(password)]
+	return ok
+}
+
+// denyPasswords adds passwords read line by line to the denylist.
+func (p *CredentialsPolicy) denyPasswords(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if password := strings.TrimSpace(scanner.Text()); password != "" {
+			p.deniedPasswords[strings.ToLower(password)] = struct{}{}
+		}
+	}
+	return scanner.Err()
+}
+
+// characterClasses counts lower case letters, upper case letters,
+// digits and other symbols used in a password.
+func characterClasses(password string) int {
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
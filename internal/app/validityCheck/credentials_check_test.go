@@ -0,0 +1,71 @@
+package validitycheck
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentialsPolicy(t *testing.T) {
+	policy, err := NewCredentialsPolicy(3, 16, "a-z0-9._-", 8, 2, "")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		want     []FieldError
+		login    string
+		password string
+	}{
+		{
+			name:     "#1 valid credentials",
+			want:     nil,
+			login:    "gopher.one",
+			password: "Gopher-Mart",
+		},
+		{
+			name:     "#2 short login",
+			want:     []FieldError{{"login", "must be at least 3 characters long"}},
+			login:    "go",
+			password: "Gopher-Mart",
+		},
+		{
+			name:     "#3 long login",
+			want:     []FieldError{{"login", "must be at most 16 characters long"}},
+			login:    "gophergophergopher",
+			password: "Gopher-Mart",
+		},
+		{
+			name:     "#4 login with forbidden characters",
+			want:     []FieldError{{"login", "may contain only [a-z0-9._-] characters"}},
+			login:    "gopher mart",
+			password: "Gopher-Mart",
+		},
+		{
+			name:     "#5 short password",
+			want:     []FieldError{{"password", "must be at least 8 characters long"}},
+			login:    "gopher",
+			password: "Go-1",
+		},
+		{
+			name:     "#6 simple password",
+			want:     []FieldError{{"password", "must contain at least 2 of: lower case letters, upper case letters, digits, symbols"}},
+			login:    "gopher",
+			password: "gophermartpass",
+		},
+		{
+			name:     "#7 common password",
+			want:     []FieldError{{"password", "is too common"}},
+			login:    "gopher",
+			password: "Password123",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, policy.Validate(tt.login, tt.password))
+		})
+	}
+}
+
+func TestNormalizeLogin(t *testing.T) {
+	assert.Equal(t, "gopher", NormalizeLogin("  GoPher "))
+}
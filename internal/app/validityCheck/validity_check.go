@@ -1,4 +1,4 @@
-// Package validity check provides a function that checks correctness of an order number.
+// Package validity check provides functions that check correctness of order numbers and credentials.
 package validitycheck
 
 import (